@@ -0,0 +1,659 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// Access levels of a grant; write implies read.
+const (
+	accessRead  = "read"
+	accessWrite = "write"
+)
+
+// tokenPrefix starts every token pb hands out, so that they are easy to
+// spot in a config file or a leaked log.
+const tokenPrefix = "pb_"
+
+var (
+	// errUnauthenticated is returned to clients of pb serve that send no
+	// token, or an unknown one, once the board has users or tokens.
+	errUnauthenticated = errors.New("a valid token is required, see pb acl and pb token")
+	// errForbidden is returned for keys that the user has no grant for.
+	errForbidden = errors.New("access denied")
+)
+
+func usersTable() string {
+	return kvTable + "_users"
+}
+
+func grantsTable() string {
+	return kvTable + "_grants"
+}
+
+// aclUser is a user of pb serve, who authenticates with a token.
+type aclUser struct {
+	Name      string    `json:"name" yaml:"name"`
+	CreatedAt time.Time `json:"created_at" yaml:"created_at"`
+}
+
+// aclGrant lets a user read, or read and write, the keys starting with
+// Prefix.
+type aclGrant struct {
+	User   string `json:"user" yaml:"user"`
+	Prefix string `json:"prefix" yaml:"prefix"`
+	Access string `json:"access" yaml:"access"`
+}
+
+// principal is who a request is from, and what they may do.
+type principal struct {
+	User   string
+	grants []aclGrant
+	// scope narrows the grants, for a token of the user, see pb token.
+	scope *aclGrant
+}
+
+// can reports whether p may read key, or also write it if write is set.
+// Keys include the namespace. A nil principal may do anything: it is who
+// pb serve sees when the board has no users.
+func (p *principal) can(key string, write bool) bool {
+	if p == nil {
+		return true
+	}
+	if s := p.scope; s != nil && (!strings.HasPrefix(key, s.Prefix) || write && s.Access != accessWrite) {
+		return false
+	}
+	for _, g := range p.grants {
+		if strings.HasPrefix(key, g.Prefix) && (!write || g.Access == accessWrite) {
+			return true
+		}
+	}
+	return false
+}
+
+// check is can as an error, for key without the namespace.
+func (p *principal) check(key string, write bool) error {
+	if p.can(namespace+key, write) {
+		return nil
+	}
+	verb := "read"
+	if write {
+		verb = "write"
+	}
+	return fmt.Errorf("%w: %s may not %s %s", errForbidden, p.User, verb, key)
+}
+
+// requireACLs fails for drivers that cannot keep users and grants.
+func requireACLs() error {
+	if db == nil {
+		return fmt.Errorf("the %s driver has no access control lists", sqlDriver)
+	}
+	return nil
+}
+
+// newToken returns a random token and the hash it is stored as.
+func newToken() (string, string) {
+	b := make([]byte, 24)
+	rand.Read(b)
+	token := tokenPrefix + hex.EncodeToString(b)
+	return token, hashToken(token)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticate returns the principal holding token, that of a user, one
+// made by pb token or an ID token of the OIDC provider. Without a token it
+// returns nil, who may do anything, unless the board has users or tokens
+// or pb serve takes ID tokens.
+func authenticate(token string) (*principal, error) {
+	if db == nil {
+		return nil, nil
+	}
+	if token == "" && oidcVerifier != nil {
+		return nil, errUnauthenticated
+	}
+	if isIDToken(token) {
+		return oidcPrincipal(token)
+	}
+	if token == "" {
+		var one int
+		err := queryerFor(ctx).QueryRowContext(ctx, "SELECT 1 FROM "+usersTable()+" UNION ALL SELECT 1 FROM "+tokensTable()+" LIMIT 1").Scan(&one)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, errUnauthenticated
+	}
+	p := &principal{}
+	err := queryerFor(ctx).QueryRowContext(ctx, "SELECT name FROM "+usersTable()+" WHERE token_hash = ?", hashToken(token)).Scan(&p.User)
+	if err == sql.ErrNoRows {
+		p, err = tokenPrincipal(hashToken(token))
+		if err == sql.ErrNoRows {
+			return nil, errUnauthenticated
+		}
+		return p, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.grants, err = listGrants(p.User); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// requestToken returns the token of an HTTP request, sent as a bearer
+// token or, for browsers and WebDAV clients, as the password of basic
+// authentication.
+func requestToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	_, token, _ := r.BasicAuth()
+	return token
+}
+
+type principalKey struct{}
+
+// withPrincipal authenticates the requests to h, rejecting them once the
+// board has users unless they carry a valid token, and passes on who they
+// are from, see requestPrincipal.
+func withPrincipal(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := authenticate(requestToken(r))
+		if errors.Is(err, errUnauthenticated) {
+			// lets browsers ask for the token
+			w.Header().Set("WWW-Authenticate", `Basic realm="postboard"`)
+			apiError(w, r, http.StatusUnauthorized, err)
+			return
+		}
+		if err != nil {
+			apiError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+// requestPrincipal returns who a request passed through withPrincipal is
+// from, or nil if anyone may do anything.
+func requestPrincipal(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+// aclWrite runs a change to the users or grants, which are no keys, so
+// it is up to it to honour --read-only and --dry-run.
+func aclWrite(what string, fn func() error) error {
+	if err := requireACLs(); err != nil {
+		return err
+	}
+	if readOnly {
+		return errReadOnly
+	}
+	if _, ok := store.(aclStore); ok {
+		return fmt.Errorf("%w: the user of Token may not change users and grants", errForbidden)
+	}
+	if dryRunWrites {
+		fmt.Fprintf(os.Stderr, "dry run: would %s\n", what)
+		return nil
+	}
+	return fn()
+}
+
+// addUser creates a user and returns their token, which is only stored
+// hashed.
+func addUser(name string) (string, error) {
+	token, hash := newToken()
+	return token, aclWrite("add user "+name, func() error {
+		res, err := queryerFor(ctx).ExecContext(ctx, "INSERT INTO "+usersTable()+" (name, token_hash, created_at) VALUES (?, ?, "+currentTimestamp()+")"+ignoreConflict("name"), name, hash)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err == nil && n == 0 {
+			err = fmt.Errorf("user %s exists", name)
+		}
+		return err
+	})
+}
+
+// removeUser deletes a user, their grants and their tokens.
+func removeUser(name string) error {
+	return aclWrite("remove user "+name, func() error {
+		return inTx(ctx, func(ctx context.Context) error {
+			for _, table := range []string{grantsTable(), tokensTable()} {
+				if _, err := queryerFor(ctx).ExecContext(ctx, "DELETE FROM "+table+" WHERE name = ?", name); err != nil {
+					return err
+				}
+			}
+			res, err := queryerFor(ctx).ExecContext(ctx, "DELETE FROM "+usersTable()+" WHERE name = ?", name)
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			if err == nil && n == 0 {
+				err = fmt.Errorf("no user %s: %w", name, sql.ErrNoRows)
+			}
+			return err
+		})
+	})
+}
+
+func listUsers() ([]aclUser, error) {
+	if err := requireACLs(); err != nil {
+		return nil, err
+	}
+	rows, err := queryerFor(ctx).QueryContext(ctx, "SELECT name, created_at FROM "+usersTable()+" ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []aclUser
+	for rows.Next() {
+		var u aclUser
+		var at string
+		if err := rows.Scan(&u.Name, &at); err != nil {
+			return nil, err
+		}
+		if u.CreatedAt, err = parseDBTime(at); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// grant lets user read, or also write, the keys starting with prefix,
+// replacing any grant they have for the same prefix.
+func grant(user, prefix, access string) error {
+	return aclWrite(fmt.Sprintf("grant %s %s access to %q", user, access, prefix), func() error {
+		var one int
+		err := queryerFor(ctx).QueryRowContext(ctx, "SELECT 1 FROM "+usersTable()+" WHERE name = ?", user).Scan(&one)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("no user %s, add them with pb acl add-user: %w", user, err)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = queryerFor(ctx).ExecContext(ctx, "INSERT INTO "+grantsTable()+" (name, prefix, access) VALUES (?, ?, ?)"+onConflict("name, prefix", "access = "+inserted("access")),
+			user, namespace+prefix, access)
+		return err
+	})
+}
+
+// revoke deletes the grant of user for prefix.
+func revoke(user, prefix string) error {
+	return aclWrite(fmt.Sprintf("revoke the access of %s to %q", user, prefix), func() error {
+		res, err := queryerFor(ctx).ExecContext(ctx, "DELETE FROM "+grantsTable()+" WHERE name = ? AND prefix = ?", user, namespace+prefix)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err == nil && n == 0 {
+			err = fmt.Errorf("%s has no grant for %q: %w", user, prefix, sql.ErrNoRows)
+		}
+		return err
+	})
+}
+
+// listGrants returns the grants of user, or of everyone if user is empty,
+// with their prefixes including the namespace.
+func listGrants(user string) ([]aclGrant, error) {
+	if err := requireACLs(); err != nil {
+		return nil, err
+	}
+	stmt, args := "SELECT name, prefix, access FROM "+grantsTable(), []any{}
+	if user != "" {
+		stmt, args = stmt+" WHERE name = ?", append(args, user)
+	}
+	rows, err := queryerFor(ctx).QueryContext(ctx, stmt+" ORDER BY name, prefix", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var grants []aclGrant
+	for rows.Next() {
+		var g aclGrant
+		if err := rows.Scan(&g.User, &g.Prefix, &g.Access); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// aclStore keeps the CLI to the grants of the user of Config.Token, as
+// pb serve does. It drops the optional interfaces of the store it wraps
+// but for the ones it checks, so that no code path goes around it.
+type aclStore struct {
+	Store
+	MetaStore
+	p *principal
+}
+
+func (s aclStore) check(key string, write bool) error {
+	return s.p.check(key[len(namespace):], write)
+}
+
+func (s aclStore) Put(ctx context.Context, key string, value []byte) error {
+	if err := s.check(key, true); err != nil {
+		return err
+	}
+	return s.Store.Put(ctx, key, value)
+}
+
+func (s aclStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := s.check(key, false); err != nil {
+		return nil, err
+	}
+	return s.Store.Get(ctx, key)
+}
+
+// List leaves out the keys the user may not read.
+func (s aclStore) List(ctx context.Context, prefix, after string, limit int) ([]string, error) {
+	var keys []string
+	for len(keys) < limit {
+		page, err := s.Store.List(ctx, prefix, after, limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range page {
+			if s.p.can(key, false) {
+				keys = append(keys, key)
+			}
+		}
+		if len(page) < limit {
+			break
+		}
+		after = page[len(page)-1]
+	}
+	return keys[:min(len(keys), limit)], nil
+}
+
+func (s aclStore) Delete(ctx context.Context, key string) (bool, error) {
+	if err := s.check(key, true); err != nil {
+		return false, err
+	}
+	return s.Store.Delete(ctx, key)
+}
+
+func (s aclStore) SetMeta(ctx context.Context, key string, fields map[string]string) error {
+	if err := s.check(key, true); err != nil {
+		return err
+	}
+	return s.MetaStore.SetMeta(ctx, key, fields)
+}
+
+func (s aclStore) GetMeta(ctx context.Context, key string) (map[string]string, error) {
+	if err := s.check(key, false); err != nil {
+		return nil, err
+	}
+	return s.MetaStore.GetMeta(ctx, key)
+}
+
+func (s aclStore) DeleteMeta(ctx context.Context, key string) error {
+	if err := s.check(key, true); err != nil {
+		return err
+	}
+	return s.MetaStore.DeleteMeta(ctx, key)
+}
+
+func (s aclStore) IncrMeta(ctx context.Context, key, name string) error {
+	if err := s.check(key, true); err != nil {
+		return err
+	}
+	return s.MetaStore.IncrMeta(ctx, key, name)
+}
+
+func (s aclStore) PutWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	inner, ok := s.Store.(expiringStore)
+	if !ok {
+		return fmt.Errorf("the %s driver does not support --ttl", sqlDriver)
+	}
+	if err := s.check(key, true); err != nil {
+		return err
+	}
+	return inner.PutWithTTL(ctx, key, value, ttl)
+}
+
+func (s aclStore) PutIf(ctx context.Context, key string, value []byte, ttl time.Duration, match func([]byte) (bool, error)) (bool, error) {
+	inner, ok := s.Store.(conditionalStore)
+	if !ok {
+		return false, fmt.Errorf("the %s driver does not support conditional writes", sqlDriver)
+	}
+	if err := s.check(key, true); err != nil {
+		return false, err
+	}
+	return inner.PutIf(ctx, key, value, ttl, match)
+}
+
+func (s aclStore) DeleteIf(ctx context.Context, key string, match func([]byte) (bool, error)) (bool, error) {
+	inner, ok := s.Store.(conditionalStore)
+	if !ok {
+		return false, fmt.Errorf("the %s driver does not support conditional writes", sqlDriver)
+	}
+	if err := s.check(key, true); err != nil {
+		return false, err
+	}
+	return inner.DeleteIf(ctx, key, match)
+}
+
+func (s aclStore) History(ctx context.Context, key string) ([]keyVersion, error) {
+	inner, ok := s.Store.(versionedStore)
+	if !ok {
+		return nil, fmt.Errorf("the %s driver does not keep history", sqlDriver)
+	}
+	if err := s.check(key, false); err != nil {
+		return nil, err
+	}
+	return inner.History(ctx, key)
+}
+
+func (s aclStore) GetVersion(ctx context.Context, key string, version int) ([]byte, error) {
+	inner, ok := s.Store.(versionedStore)
+	if !ok {
+		return nil, fmt.Errorf("the %s driver does not keep history", sqlDriver)
+	}
+	if err := s.check(key, false); err != nil {
+		return nil, err
+	}
+	return inner.GetVersion(ctx, key, version)
+}
+
+// Audit leaves out the writes to keys the user may not read.
+func (s aclStore) Audit(ctx context.Context, prefix string, since time.Duration, limit int) ([]auditEntry, error) {
+	inner, ok := s.Store.(auditingStore)
+	if !ok {
+		return nil, fmt.Errorf("the %s driver keeps no audit log", sqlDriver)
+	}
+	entries, err := inner.Audit(ctx, prefix, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	var readable []auditEntry
+	for _, e := range entries {
+		if s.p.can(e.Key, false) {
+			readable = append(readable, e)
+		}
+	}
+	return readable, nil
+}
+
+func aclCommand() *gcli.Command {
+	var write, noToken bool
+	return &gcli.Command{
+		Name: "acl",
+		Desc: "Manage who may read and write which keys through pb serve",
+		Help: `Once the board has a user, pb serve only answers requests carrying the
+token of one, as a bearer token or the password of basic authentication,
+and only for the keys they were granted:
+
+  pb acl add-user ci
+  pb acl grant --write ci app/staging/
+  curl -H "Authorization: Bearer $TOKEN" http://localhost:8080/v1/kv/app/staging/db_host
+
+With "OIDC" in the config, pb serve also takes the ID tokens of an
+OpenID Connect provider, whose users are added with --no-token under the
+name the provider gives them, such as their email address.
+
+With "Token" in the config, the CLI keeps to the grants of its user too.
+That guards against mistakes only: the DSN itself gives full access.`,
+		Subs: []*gcli.Command{
+			{
+				Name: "add-user",
+				Desc: "Add a user and print their token",
+				Config: func(c *gcli.Command) {
+					c.BoolOpt(&noToken, "no-token", "", false, "Print no token, for users who sign in through OIDC in the config")
+					c.AddArg("name", "The name of the user", true)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					if err := connect(); err != nil {
+						return err
+					}
+					token, err := addUser(c.Arg("name").String())
+					if err != nil || dryRunWrites || noToken {
+						return err
+					}
+					fmt.Fprintln(os.Stderr, "The token is not stored and cannot be shown again:")
+					fmt.Println(token)
+					return nil
+				},
+			},
+			{
+				Name: "remove-user",
+				Desc: "Remove a user, their grants and their tokens",
+				Config: func(c *gcli.Command) {
+					c.AddArg("name", "The name of the user", true)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					if err := connect(); err != nil {
+						return err
+					}
+					return removeUser(c.Arg("name").String())
+				},
+			},
+			{
+				Name: "users",
+				Desc: "List the users",
+				Config: func(c *gcli.Command) {
+					formatOpt(c, "the users", formatText)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					if err := connect(); err != nil {
+						return err
+					}
+					users, err := listUsers()
+					if err != nil {
+						return err
+					}
+					switch format := commandFormat(formatText); format {
+					case formatJSON, formatYAML:
+						if users == nil {
+							users = []aclUser{}
+						}
+						return printStructured(format, users)
+					case formatTable:
+						rows := make([][]string, len(users))
+						for i, u := range users {
+							rows[i] = []string{u.Name, formatTime(&u.CreatedAt)}
+						}
+						return printTable([]string{"NAME", "CREATED"}, rows)
+					}
+					for _, u := range users {
+						fmt.Println(u.Name)
+					}
+					return nil
+				},
+			},
+			{
+				Name: "grant",
+				Desc: "Let a user read, or with --write also write, the keys starting with a prefix",
+				Config: func(c *gcli.Command) {
+					c.BoolOpt(&write, "write", "w", false, "Also let the user set and delete the keys")
+					c.AddArg("user", "The name of the user", true)
+					c.AddArg("prefix", "The beginning of the keys, empty for all", false)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					access := accessRead
+					if write {
+						access = accessWrite
+					}
+					if err := connect(); err != nil {
+						return err
+					}
+					return grant(c.Arg("user").String(), strings.TrimSuffix(c.Arg("prefix").String(), "*"), access)
+				},
+			},
+			{
+				Name: "revoke",
+				Desc: "Delete the grant of a user for a prefix",
+				Config: func(c *gcli.Command) {
+					c.AddArg("user", "The name of the user", true)
+					c.AddArg("prefix", "The prefix of the grant", false)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					if err := connect(); err != nil {
+						return err
+					}
+					return revoke(c.Arg("user").String(), strings.TrimSuffix(c.Arg("prefix").String(), "*"))
+				},
+			},
+			{
+				Name: "list",
+				Desc: "List the grants, of one user or of everyone",
+				Config: func(c *gcli.Command) {
+					formatOpt(c, "the grants", formatText)
+					c.AddArg("user", "Only list the grants of this user", false)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					if err := connect(); err != nil {
+						return err
+					}
+					all, err := listGrants(c.Arg("user").String())
+					if err != nil {
+						return err
+					}
+					// the grants of other namespaces are not for this board
+					grants := []aclGrant{}
+					for _, g := range all {
+						if prefix, ok := strings.CutPrefix(g.Prefix, namespace); ok {
+							g.Prefix = prefix
+							grants = append(grants, g)
+						}
+					}
+					switch format := commandFormat(formatText); format {
+					case formatJSON, formatYAML:
+						return printStructured(format, grants)
+					case formatTable:
+						rows := make([][]string, len(grants))
+						for i, g := range grants {
+							rows[i] = []string{g.User, g.Prefix, g.Access}
+						}
+						return printTable([]string{"USER", "PREFIX", "ACCESS"}, rows)
+					}
+					for _, g := range grants {
+						fmt.Printf("%s\t%s\t%q\n", g.User, g.Access, g.Prefix)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+)
+
+// apiMaxValue bounds the body of a PUT to the API.
+const apiMaxValue = 16 << 20
+
+// apiEntry is a key and its value as returned by GET /v1/kv/{key}. Values
+// that are not valid UTF-8 are base64-encoded, and Encoding says so.
+type apiEntry struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// apiRoute is an operation of the JSON API, with what pb openapi says
+// about it.
+type apiRoute struct {
+	pattern string
+	handler http.HandlerFunc
+	id      string
+	summary string
+	query   []apiParam
+	// body describes the request body, if the operation takes one
+	body string
+	// result is the schema of the response, which is 204 No Content
+	// without one
+	result string
+	// errors are the statuses the operation fails with besides 401,
+	// 429 and 500, which any request may get
+	errors []int
+}
+
+// apiParam is a query parameter of an operation.
+type apiParam struct {
+	name, typ, desc string
+}
+
+// apiRoutes are the operations of the JSON API, by their ServeMux
+// patterns.
+var apiRoutes = []apiRoute{
+	{
+		pattern: "GET /v1/kv",
+		handler: apiList,
+		id:      "listKeys",
+		summary: "List the keys starting with a prefix, a page at a time",
+		query: []apiParam{
+			{"prefix", "string", "Only list the keys starting with it"},
+			{"after", "string", "Start after this key, the next of the previous page"},
+			{"limit", "integer", "The most keys to return, 1 to 1000, 1000 by default"},
+		},
+		result: "KeyList",
+		errors: []int{http.StatusBadRequest},
+	},
+	{
+		pattern: "GET /v1/kv/{key...}",
+		handler: apiGet,
+		id:      "getKey",
+		summary: "Get the value of a key",
+		result:  "Entry",
+		errors:  []int{http.StatusForbidden, http.StatusNotFound},
+	},
+	{
+		pattern: "PUT /v1/kv/{key...}",
+		handler: apiPut,
+		id:      "putKey",
+		summary: "Set the value of a key",
+		body:    "The value, up to 16 MiB",
+		errors:  []int{http.StatusBadRequest, http.StatusForbidden, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity},
+	},
+	{
+		pattern: "DELETE /v1/kv/{key...}",
+		handler: apiDelete,
+		id:      "deleteKey",
+		summary: "Delete a key",
+		errors:  []int{http.StatusForbidden, http.StatusNotFound},
+	},
+}
+
+// registerAPI adds the JSON key/value API under /v1/ to mux, and its
+// OpenAPI spec at /v1/openapi.json.
+func registerAPI(mux *http.ServeMux) {
+	for _, route := range apiRoutes {
+		mux.HandleFunc(route.pattern, withPrincipal(route.handler))
+	}
+	mux.HandleFunc("GET /v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(apiSpecJSON())
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// apiError reports err as {"error": "..."}, hiding the details of
+// unexpected errors from clients.
+func apiError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	msg := err.Error()
+	if status == http.StatusInternalServerError {
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		msg = "internal error"
+	}
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// apiList returns the keys starting with ?prefix=, at most ?limit= of
+// them, 1000 by default. When there may be more, next is the ?after= of
+// the next page. Keys the user may not read are left out, so a page may
+// be short.
+func apiList(w http.ResponseWriter, r *http.Request) {
+	limit := listPage
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > listPage {
+			apiError(w, r, http.StatusBadRequest, errors.New("limit must be between 1 and 1000"))
+			return
+		}
+		limit = n
+	}
+	keys, err := listKeysPage(ctx, r.URL.Query().Get("prefix"), r.URL.Query().Get("after"), limit)
+	if err != nil {
+		apiError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	resp := map[string]any{}
+	if len(keys) == limit {
+		resp["next"] = keys[len(keys)-1]
+	}
+	p := requestPrincipal(r.Context())
+	readable := []string{}
+	for _, key := range keys {
+		if p.can(namespace+key, false) {
+			readable = append(readable, key)
+		}
+	}
+	resp["keys"] = readable
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func apiGet(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if err := requestPrincipal(r.Context()).check(key, false); err != nil {
+		apiError(w, r, http.StatusForbidden, err)
+		return
+	}
+	value, err := getKey(ctx, key)
+	if err == sql.ErrNoRows {
+		apiError(w, r, http.StatusNotFound, errors.New("no such key"))
+		return
+	}
+	if err != nil {
+		apiError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	e := apiEntry{Key: key, Value: string(value)}
+	if !utf8.Valid(value) {
+		e.Value, e.Encoding = base64.StdEncoding.EncodeToString(value), "base64"
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+// apiPut stores the request body as the value, as sent by
+// curl --data-binary @file.
+func apiPut(w http.ResponseWriter, r *http.Request) {
+	if err := requestPrincipal(r.Context()).check(r.PathValue("key"), true); err != nil {
+		apiError(w, r, http.StatusForbidden, err)
+		return
+	}
+	value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, apiMaxValue))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		apiError(w, r, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	if err != nil {
+		apiError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	err = putKeyValue(ctx, r.PathValue("key"), value)
+	var rejected *hookRejection
+	if errors.As(err, &rejected) {
+		apiError(w, r, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if errors.Is(err, errReadOnly) || errors.Is(err, errForbidden) {
+		apiError(w, r, http.StatusForbidden, err)
+		return
+	}
+	if err != nil {
+		apiError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiDelete(w http.ResponseWriter, r *http.Request) {
+	if err := requestPrincipal(r.Context()).check(r.PathValue("key"), true); err != nil {
+		apiError(w, r, http.StatusForbidden, err)
+		return
+	}
+	deleted, err := deleteKey(ctx, r.PathValue("key"))
+	if errors.Is(err, errReadOnly) || errors.Is(err, errForbidden) {
+		apiError(w, r, http.StatusForbidden, err)
+		return
+	}
+	if err != nil {
+		apiError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	if !deleted {
+		apiError(w, r, http.StatusNotFound, errors.New("no such key"))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/c4pt0r/postboard/openapi"
+)
+
+func TestOpenAPISpecIsGenerated(t *testing.T) {
+	if !bytes.Equal(openapi.Spec, apiSpecJSON()) {
+		t.Error("openapi/openapi.json is out of date, run go generate ./openapi")
+	}
+}
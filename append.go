@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// encodedPrefix starts every value pb encodes before storing it: encrypted,
+// compressed, chunked or marked with rawMagic. Only values without it can
+// be appended to by the database, and only if they are long enough that
+// appending cannot make it.
+var encodedPrefix = []byte("pb:")
+
+// appendingStore is implemented by stores that can append to a value
+// without it leaving the server.
+type appendingStore interface {
+	// Append appends data to the value of key if it exists, is stored as
+	// is and stays small enough for one row, reporting whether it did.
+	Append(ctx context.Context, key string, data []byte) (bool, error)
+}
+
+func (s sqlStore) Append(ctx context.Context, key string, data []byte) (bool, error) {
+	err := inTx(ctx, func(ctx context.Context) error {
+		var old []byte
+		err := queryerFor(ctx).QueryRowContext(ctx, `SELECT v FROM `+kvTable+` WHERE k = ? AND `+notExpired(), key).Scan(&old)
+		if err == sql.ErrNoRows {
+			return errConflict
+		}
+		if err != nil {
+			return err
+		}
+		if len(old) < len(encodedPrefix) || bytes.HasPrefix(old, encodedPrefix) {
+			return errConflict
+		}
+		if err := s.auditPut(ctx, key, append(old, data...)); err != nil {
+			return err
+		}
+		if err := s.archive(ctx, key, nil); err != nil {
+			return err
+		}
+		res, err := queryerFor(ctx).ExecContext(ctx, `UPDATE `+kvTable+` SET v = `+concat("v", "?")+`, updated_at = `+currentTimestamp()+`, updated_by = ?
+WHERE k = ? AND `+notExpired()+` AND LENGTH(v) + ? <= ? AND LENGTH(v) >= ? AND SUBSTR(v, 1, ?) <> ?`,
+			data, updatedBy, key, len(data), chunkSize, len(encodedPrefix), len(encodedPrefix), encodedPrefix)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// roll back the history and audit written above
+			return errConflict
+		}
+		return nil
+	})
+	if err == errConflict {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// appendValue appends data to the value of key, creating it if it does not
+// exist. The database does it when the value is stored as is; otherwise
+// the value is read, extended and written back if nobody changed it since.
+func appendValue(ctx context.Context, key string, data []byte) error {
+	if s, ok := store.(appendingStore); ok && aead == nil && recipients == nil && compression == nil && !hasHooks(key) {
+		appended, err := s.Append(ctx, namespace+key, data)
+		if err != nil || appended {
+			cacheDelete(key)
+			return err
+		}
+	}
+	for range counterAttempts {
+		stored, err := store.Get(ctx, namespace+key)
+		var current []byte
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return err
+		default:
+			if current, err = decryptValue(stored); err != nil {
+				return err
+			}
+			// an empty value is not a missing one
+			current = append([]byte{}, current...)
+		}
+		err = putKeyValueIf(key, append(append([]byte{}, current...), data...), current, 0)
+		if !errors.Is(err, errConflict) {
+			return err
+		}
+	}
+	return fmt.Errorf("%s changed %d times while being appended to: %w", key, counterAttempts, errConflict)
+}
+
+func appendCommand() *gcli.Command {
+	var newline bool
+	return &gcli.Command{
+		Name: "append",
+		Desc: "Append data to a key, creating it if needed",
+		Help: `Data is read from stdin if not given:
+
+  echo "$(date) deployed $VERSION" | pb append deploys/web`,
+		Config: func(c *gcli.Command) {
+			c.BoolOpt(&newline, "newline", "l", false, "Append a newline after data given as an argument")
+			c.AddArg("key", "The key to append to", true)
+			c.AddArg("data", "The data to append", false)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			data := []byte(c.Arg("data").String())
+			if len(data) == 0 {
+				var err error
+				if data, err = readAll(); err != nil {
+					return err
+				}
+			} else if newline {
+				data = append(data, '\n')
+			}
+			if len(data) == 0 {
+				return nil
+			}
+			return appendValue(ctx, c.Arg("key").String(), data)
+		},
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// auditEntry is one write recorded in the audit table.
+type auditEntry struct {
+	At     time.Time `json:"at" yaml:"at"`
+	Action string    `json:"action" yaml:"action"`
+	Key    string    `json:"key" yaml:"key"`
+	// ValueHash is the SHA-256 of the value as stored, encrypted if it
+	// is, so that the log reveals nothing of secrets; empty for deletes.
+	ValueHash string `json:"value_hash,omitempty" yaml:"value_hash,omitempty"`
+	// OldVersion is the version in pb history of the value replaced, 0
+	// if the key did not exist.
+	OldVersion int    `json:"old_version,omitempty" yaml:"old_version,omitempty"`
+	By         string `json:"by,omitempty" yaml:"by,omitempty"`
+}
+
+// auditingStore is implemented by stores that record every write, see
+// pb audit.
+type auditingStore interface {
+	// Audit returns up to limit writes to the keys starting with prefix
+	// made in the last since, or ever if since is 0, newest first.
+	Audit(ctx context.Context, prefix string, since time.Duration, limit int) ([]auditEntry, error)
+}
+
+func auditTable() string {
+	return kvTable + "_audit"
+}
+
+// oldVersion is the version of the value of key, ? being key, before it
+// is archived: the one after the last in its history.
+func oldVersion() string {
+	return `(SELECT COALESCE(MAX(version), 0) + 1 FROM ` + historyTable() + ` WHERE k = ?)`
+}
+
+// auditPut records that value, as stored, is written to key. Like
+// archive, it must run before the write and in its transaction.
+func (sqlStore) auditPut(ctx context.Context, key string, value []byte) error {
+	value, err := readChunks(ctx, queryerFor(ctx), value)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(value)
+	_, err = queryerFor(ctx).ExecContext(ctx, `INSERT INTO `+auditTable()+` (k, action, value_hash, old_version, changed_by, changed_at)
+SELECT ?, 'set', ?, CASE WHEN EXISTS (SELECT 1 FROM `+kvTable+` WHERE k = ?) THEN `+oldVersion()+` END, ?, `+currentTimestamp(),
+		key, hex.EncodeToString(sum[:]), key, key, updatedBy)
+	return err
+}
+
+// auditDelete records that key is deleted, if it exists. Like archive, it
+// must run before the delete and in its transaction.
+func (sqlStore) auditDelete(ctx context.Context, key string) error {
+	_, err := queryerFor(ctx).ExecContext(ctx, `INSERT INTO `+auditTable()+` (k, action, value_hash, old_version, changed_by, changed_at)
+SELECT k, 'delete', NULL, `+oldVersion()+`, ?, `+currentTimestamp()+` FROM `+kvTable+` WHERE k = ?`,
+		key, updatedBy, key)
+	return err
+}
+
+func (sqlStore) Audit(ctx context.Context, prefix string, since time.Duration, limit int) ([]auditEntry, error) {
+	where, args := hasPrefix("k"), []any{likePrefix(prefix)}
+	if since > 0 {
+		where += " AND changed_at >= " + afterNow()
+		args = append(args, -since.Microseconds())
+	}
+	rows, err := queryerFor(ctx).QueryContext(ctx, `SELECT changed_at, action, k, value_hash, old_version, changed_by FROM `+auditTable()+`
+WHERE `+where+` ORDER BY changed_at DESC, id DESC LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []auditEntry
+	for rows.Next() {
+		var e auditEntry
+		var at string
+		var hash, by *string
+		var version *int
+		if err := rows.Scan(&at, &e.Action, &e.Key, &hash, &version, &by); err != nil {
+			return nil, err
+		}
+		if e.At, err = parseDBTime(at); err != nil {
+			return nil, err
+		}
+		if hash != nil {
+			e.ValueHash = *hash
+		}
+		if version != nil {
+			e.OldVersion = *version
+		}
+		if by != nil {
+			e.By = *by
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// parseSince parses --since: a duration back from now such as 24h, or a
+// date or time, in the local time zone unless it says otherwise.
+func parseSince(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateTime, "2006-01-02T15:04:05", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return time.Since(t), nil
+		}
+	}
+	return 0, fmt.Errorf("--since %q is neither a duration such as 24h nor a time such as 2006-01-02 15:04:05", s)
+}
+
+func auditCommand() *gcli.Command {
+	var since string
+	var limit int
+	return &gcli.Command{
+		Name: "audit",
+		Desc: "Show who set and deleted keys, and when",
+		Help: `Every write through pb is recorded in the audit table of the board,
+with a SHA-256 of the value written and the version in pb history of the
+value it replaced:
+
+  pb audit --since 24h app/prod/
+  pb audit --since 2024-06-01 --format json`,
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&since, "since", "", "", "Only show the writes since this long ago, e.g. 24h, or since this date or time")
+			c.IntOpt(&limit, "limit", "n", listPage, "Show at most this many writes, the newest")
+			formatOpt(c, "the writes", formatText)
+			c.AddArg("prefix", "Only show the writes to keys starting with this", false)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			var d time.Duration
+			if since != "" {
+				var err error
+				if d, err = parseSince(since); err != nil {
+					return err
+				}
+				if d <= 0 {
+					return fmt.Errorf("--since is in the future")
+				}
+			}
+			if limit <= 0 {
+				return fmt.Errorf("--limit must be positive")
+			}
+			if err := connect(); err != nil {
+				return err
+			}
+			s, ok := store.(auditingStore)
+			if !ok {
+				return fmt.Errorf("the %s driver keeps no audit log", sqlDriver)
+			}
+			prefix := strings.TrimSuffix(c.Arg("prefix").String(), "*")
+			entries, err := s.Audit(ctx, namespace+prefix, d, limit)
+			if err != nil {
+				return err
+			}
+			for i := range entries {
+				entries[i].Key = entries[i].Key[len(namespace):]
+			}
+			switch format := commandFormat(formatText); format {
+			case formatJSON, formatYAML:
+				if entries == nil {
+					entries = []auditEntry{}
+				}
+				return printStructured(format, entries)
+			case formatTable:
+				rows := make([][]string, len(entries))
+				for i, e := range entries {
+					rows[i] = []string{formatTime(&e.At), e.Action, e.Key, auditVersion(e), auditCell(e.By), auditCell(shortHash(e.ValueHash))}
+				}
+				return printTable([]string{"AT", "ACTION", "KEY", "OLD VERSION", "BY", "SHA-256"}, rows)
+			}
+			for _, e := range entries {
+				line := fmt.Sprintf("%s  %-6s %s", e.At.Local().Format(time.DateTime), e.Action, e.Key)
+				if e.OldVersion != 0 {
+					line += fmt.Sprintf(" (was version %d)", e.OldVersion)
+				}
+				if e.By != "" {
+					line += " by " + e.By
+				}
+				if e.ValueHash != "" {
+					line += "  sha256:" + shortHash(e.ValueHash)
+				}
+				fmt.Println(line)
+			}
+			return nil
+		},
+	}
+}
+
+// auditVersion prints the old version of e in a table.
+func auditVersion(e auditEntry) string {
+	if e.OldVersion == 0 {
+		return "-"
+	}
+	return strconv.Itoa(e.OldVersion)
+}
+
+// auditCell prints an optional field in a table.
+func auditCell(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// shortHash abbreviates a hash as git does, enough to tell values apart.
+func shortHash(hash string) string {
+	return hash[:min(len(hash), 12)]
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/gookit/gcli/v3"
+)
+
+// ssmDeleteBatch is the most parameters DeleteParameters takes at once.
+const ssmDeleteBatch = 10
+
+// awsValue is a parameter or secret as pb aws compares and stores it.
+type awsValue struct {
+	value  string
+	secret bool
+}
+
+// loadAWSConfig loads the default credential chain, in region if given.
+func loadAWSConfig(region string) (aws.Config, error) {
+	var opts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	return awsconfig.LoadDefaultConfig(ctx, opts...)
+}
+
+// ssmDir turns an SSM parameter path into the prefix of the names under it.
+func ssmDir(path string) string {
+	return strings.TrimSuffix(path, "/") + "/"
+}
+
+// ssmParameters reads the parameters under path, decrypted, by their names
+// without it.
+func ssmParameters(client *ssm.Client, path string) (map[string]awsValue, error) {
+	dir := ssmDir(path)
+	params := map[string]awsValue{}
+	pages := ssm.NewGetParametersByPathPaginator(client, &ssm.GetParametersByPathInput{
+		Path:           aws.String(cmp.Or(strings.TrimSuffix(dir, "/"), "/")),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range page.Parameters {
+			name := strings.TrimPrefix(aws.ToString(p.Name), dir)
+			params[name] = awsValue{aws.ToString(p.Value), p.Type == ssmtypes.ParameterTypeSecureString}
+		}
+	}
+	return params, nil
+}
+
+// awsSecretsBase splits a pattern of secret names, as pb get takes keys:
+// a name, or a prefix followed by *. It returns the folder of the names
+// that keys are relative to, and whether name matches.
+func awsSecretsBase(pattern string) (base string, match func(name string) bool) {
+	literal, isPrefix := strings.CutSuffix(pattern, "*")
+	base = literal[:strings.LastIndex(literal, "/")+1]
+	if isPrefix {
+		return base, func(name string) bool { return strings.HasPrefix(name, literal) }
+	}
+	return base, func(name string) bool { return name == literal }
+}
+
+// awsSecrets reads the secrets matching pattern, by their names without
+// its base, with their descriptions.
+func awsSecrets(client *secretsmanager.Client, pattern string) (map[string]awsValue, map[string]string, error) {
+	base, match := awsSecretsBase(pattern)
+	in := &secretsmanager.ListSecretsInput{}
+	if literal := strings.TrimSuffix(pattern, "*"); literal != "" {
+		// the name filter matches prefixes, and in words too; match below
+		in.Filters = []smtypes.Filter{{Key: smtypes.FilterNameStringTypeName, Values: []string{literal}}}
+	}
+	secrets, notes := map[string]awsValue{}, map[string]string{}
+	pages := secretsmanager.NewListSecretsPaginator(client, in)
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, s := range page.SecretList {
+			name := aws.ToString(s.Name)
+			if !match(name) {
+				continue
+			}
+			out, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: s.ARN})
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", name, err)
+			}
+			value := aws.ToString(out.SecretString)
+			if out.SecretString == nil {
+				value = string(out.SecretBinary)
+			}
+			rel := strings.TrimPrefix(name, base)
+			secrets[rel] = awsValue{value, true}
+			notes[rel] = aws.ToString(s.Description)
+		}
+	}
+	return secrets, notes, nil
+}
+
+// awsPushKeys reads the keys under prefix, by their names without it,
+// marked secret unless their metadata says plain, as pb aws pull stores
+// String parameters.
+func awsPushKeys(prefix string) (map[string]awsValue, error) {
+	keys, err := listKeysWithPrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]awsValue{}
+	for _, key := range keys {
+		value, err := getKey(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		meta, err := getMeta(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		values[strings.TrimPrefix(key, prefix)] = awsValue{string(value), meta["type"] != "plain"}
+	}
+	return values, nil
+}
+
+// pushSSM puts values to the parameters under path, as SecureString
+// encrypted with kmsKey, or the account's aws/ssm key, unless plain.
+// Parameters that already have the value and type are left alone.
+func pushSSM(client *ssm.Client, path, kmsKey string, values map[string]awsValue, dryRun, del bool) error {
+	dir := ssmDir(path)
+	current, err := ssmParameters(client, path)
+	if err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		v := values[name]
+		if current[name] == v {
+			continue
+		}
+		if !utf8.ValidString(v.value) {
+			return fmt.Errorf("%s: SSM parameters are text, the value is binary", name)
+		}
+		fmt.Printf("push %s -> %s\n", name, dir+name)
+		if dryRun {
+			continue
+		}
+		in := &ssm.PutParameterInput{Name: aws.String(dir + name), Value: aws.String(v.value), Type: ssmtypes.ParameterTypeString, Overwrite: aws.Bool(true)}
+		if v.secret {
+			in.Type = ssmtypes.ParameterTypeSecureString
+			if kmsKey != "" {
+				in.KeyId = aws.String(kmsKey)
+			}
+		}
+		if _, err := client.PutParameter(ctx, in); err != nil {
+			return fmt.Errorf("%s: %w", dir+name, err)
+		}
+	}
+	if !del {
+		return nil
+	}
+	var stale []string
+	for _, name := range slices.Sorted(maps.Keys(current)) {
+		if _, ok := values[name]; !ok {
+			fmt.Printf("delete %s\n", dir+name)
+			stale = append(stale, dir+name)
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	for batch := range slices.Chunk(stale, ssmDeleteBatch) {
+		if _, err := client.DeleteParameters(ctx, &ssm.DeleteParametersInput{Names: batch}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pushSecrets puts values to the secrets named by pattern, creating those
+// missing with kmsKey, or the account's aws/secretsmanager key. Secrets
+// that already have the value are left alone, and deleted ones can be
+// restored for 30 days.
+func pushSecrets(client *secretsmanager.Client, pattern, kmsKey string, values map[string]awsValue, dryRun, del bool) error {
+	base, match := awsSecretsBase(pattern)
+	for name := range values {
+		if !match(base + name) {
+			return fmt.Errorf("%s is not matched by %s, the secret it would be", base+name, pattern)
+		}
+	}
+	current, _, err := awsSecrets(client, pattern)
+	if err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		value := values[name].value
+		old, ok := current[name]
+		if ok && old.value == value {
+			continue
+		}
+		fmt.Printf("push %s -> %s\n", name, base+name)
+		if dryRun {
+			continue
+		}
+		var str *string
+		var bin []byte
+		if utf8.ValidString(value) {
+			str = aws.String(value)
+		} else {
+			bin = []byte(value)
+		}
+		if ok {
+			_, err = client.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{SecretId: aws.String(base + name), SecretString: str, SecretBinary: bin})
+		} else {
+			in := &secretsmanager.CreateSecretInput{Name: aws.String(base + name), SecretString: str, SecretBinary: bin}
+			if kmsKey != "" {
+				in.KmsKeyId = aws.String(kmsKey)
+			}
+			_, err = client.CreateSecret(ctx, in)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", base+name, err)
+		}
+	}
+	if !del {
+		return nil
+	}
+	for _, name := range slices.Sorted(maps.Keys(current)) {
+		if _, ok := values[name]; ok {
+			continue
+		}
+		fmt.Printf("delete %s\n", base+name)
+		if dryRun {
+			continue
+		}
+		if _, err := client.DeleteSecret(ctx, &secretsmanager.DeleteSecretInput{SecretId: aws.String(base + name)}); err != nil {
+			return fmt.Errorf("%s: %w", base+name, err)
+		}
+	}
+	return nil
+}
+
+func awsCommand() *gcli.Command {
+	var ssmPath, secretsPattern, prefix, region, kmsKey string
+	var dryRun, del bool
+	config := func(c *gcli.Command) {
+		c.StrOpt(&ssmPath, "ssm", "", "", "The SSM Parameter Store path, e.g. /app/prod/")
+		c.StrOpt(&secretsPattern, "secrets", "", "", "The Secrets Manager secret, or names ending in *, e.g. 'app/prod/*'")
+		c.StrOpt(&prefix, "prefix", "p", "", "The key prefix holding the parameters or secrets, e.g. app/prod/")
+		c.StrOpt(&region, "region", "", "", "The AWS region, by default that of the AWS config")
+		c.BoolOpt(&del, "delete", "", false, "Delete the keys, parameters or secrets that the other side does not have")
+		c.BoolOpt(&dryRun, "dry-run", "n", false, "Print what would be done without changing anything")
+	}
+	source := func() error {
+		if (ssmPath == "") == (secretsPattern == "") {
+			return errors.New("give one of --ssm or --secrets")
+		}
+		if ssmPath != "" && !strings.HasPrefix(ssmPath, "/") {
+			return fmt.Errorf("--ssm %s: parameter paths start with /", ssmPath)
+		}
+		return nil
+	}
+	return &gcli.Command{
+		Name: "aws",
+		Desc: "Copy SSM parameters or Secrets Manager secrets to or from a key prefix",
+		Help: `The parameters under an SSM path, or the secrets whose names start
+with a prefix, are the keys under a key prefix, by their names after the
+path or the last / of the prefix:
+
+  pb aws pull --ssm /app/prod/ --prefix app/prod/
+  pb aws push --delete --secrets 'app/prod/*' --prefix app/prod/
+
+Credentials and region come from the AWS config and environment, as for
+the aws CLI, and KMS encryption is done by AWS. SecureString parameters
+are stored as secret keys and String ones as plain, and push writes keys
+back as such, with --kms-key for SecureString and new secrets. push only
+writes what differs.`,
+		Subs: []*gcli.Command{
+			{
+				Name:   "pull",
+				Desc:   "Store SSM parameters or Secrets Manager secrets as keys",
+				Config: config,
+				Func: func(c *gcli.Command, args []string) error {
+					if err := source(); err != nil {
+						return err
+					}
+					if del && prefix == "" {
+						return fmt.Errorf("--prefix is required with --delete, mirroring onto the whole board is never intended")
+					}
+					awsCfg, err := loadAWSConfig(region)
+					if err != nil {
+						return err
+					}
+					var values map[string]awsValue
+					var notes map[string]string
+					if ssmPath != "" {
+						values, err = ssmParameters(ssm.NewFromConfig(awsCfg), ssmPath)
+					} else {
+						values, notes, err = awsSecrets(secretsmanager.NewFromConfig(awsCfg), secretsPattern)
+					}
+					if err != nil {
+						return err
+					}
+					var secrets []pulledSecret
+					for _, name := range slices.Sorted(maps.Keys(values)) {
+						secrets = append(secrets, pulledSecret{Name: name, Value: values[name].value, Secret: values[name].secret, Note: notes[name]})
+					}
+					return storeSecrets(prefix, secrets, dryRun, del)
+				},
+			},
+			{
+				Name: "push",
+				Desc: "Set SSM parameters or Secrets Manager secrets from the keys under a prefix",
+				Config: func(c *gcli.Command) {
+					config(c)
+					c.StrOpt(&kmsKey, "kms-key", "", "", "The KMS key to encrypt with, by default the AWS managed one")
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					if err := source(); err != nil {
+						return err
+					}
+					if prefix == "" {
+						return fmt.Errorf("--prefix is required, pushing the whole board is never intended")
+					}
+					if err := connect(); err != nil {
+						return err
+					}
+					dryRun = dryRun || dryRunWrites
+					values, err := awsPushKeys(prefix)
+					if err != nil {
+						return err
+					}
+					awsCfg, err := loadAWSConfig(region)
+					if err != nil {
+						return err
+					}
+					if ssmPath != "" {
+						return pushSSM(ssm.NewFromConfig(awsCfg), ssmPath, kmsKey, values, dryRun, del)
+					}
+					return pushSecrets(secretsmanager.NewFromConfig(awsCfg), secretsPattern, kmsKey, values, dryRun, del)
+				},
+			},
+		},
+	}
+}
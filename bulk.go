@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gookit/gcli/v3"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+)
+
+// bulkFlags throttle commands that read or write many keys, so that a
+// large job does not saturate a database shared with production traffic.
+type bulkFlags struct {
+	concurrency int
+	rate        float64
+	limiter     *rate.Limiter
+}
+
+func (b *bulkFlags) register(c *gcli.Command) {
+	c.IntOpt(&b.concurrency, "concurrency", "", 4, "How many keys to process at once")
+	c.Float64Opt(&b.rate, "rate", "", 0, "The most keys to process per second, 0 for no limit")
+}
+
+func (b *bulkFlags) validate() error {
+	if b.concurrency < 1 {
+		return fmt.Errorf("--concurrency must be at least 1")
+	}
+	if b.rate < 0 {
+		return fmt.Errorf("--rate must not be negative")
+	}
+	return nil
+}
+
+// each calls fn for every i in [0, n), at most concurrency at a time and
+// no faster than rate, stopping at the first error. The rate is shared by
+// all calls to each.
+func (b *bulkFlags) each(n int, fn func(i int) error) error {
+	if b.limiter == nil {
+		b.limiter = rate.NewLimiter(rate.Inf, 1)
+		if b.rate > 0 {
+			b.limiter = rate.NewLimiter(rate.Limit(b.rate), 1)
+		}
+	}
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(b.concurrency)
+	for i := range n {
+		if b.limiter.Wait(gctx) != nil {
+			break
+		}
+		g.Go(func() error { return fn(i) })
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// eachBatch calls fn for [start, end) of each batch of up to size of n
+// keys, as each does for keys. A batch counts as its keys towards the
+// rate.
+func (b *bulkFlags) eachBatch(n, size int, fn func(start, end int) error) error {
+	limiter := rate.NewLimiter(rate.Inf, size)
+	if b.rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(b.rate), size)
+	}
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(b.concurrency)
+	for start := 0; start < n; start += size {
+		end := min(start+size, n)
+		if limiter.WaitN(gctx, end-start) != nil {
+			break
+		}
+		g.Go(func() error { return fn(start, end) })
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheDir and cacheTTL are set by setupCache; caching is off while
+// cacheDir is empty. The cache is also bypassed inside transactions, which
+// must see their own writes and may still roll back.
+var (
+	cacheDir string
+	cacheTTL time.Duration
+)
+
+// setupCache enables the on-disk value cache if the config asks for it.
+// Each board gets its own directory so profiles never see each other's
+// values. Values are cached as stored, i.e. still encrypted if encryption
+// is on.
+func setupCache(cfg *Config) {
+	if cfg.Cache == nil {
+		return
+	}
+	board := sha256.Sum256([]byte(cfg.DSN + "\x00" + cfg.Table + "\x00" + cfg.Namespace))
+	cacheDir = filepath.Join(cfg.Cache.Dir, hex.EncodeToString(board[:8]))
+	cacheTTL = cfg.Cache.TTL.Duration
+}
+
+func cachePath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(cacheDir, hex.EncodeToString(sum[:]))
+}
+
+// cacheGet returns the cached value of key if it is younger than the TTL.
+func cacheGet(ctx context.Context, key string) ([]byte, bool) {
+	if cacheDir == "" || txFrom(ctx) != nil {
+		return nil, false
+	}
+	path := cachePath(key)
+	fi, err := os.Stat(path)
+	if err != nil || time.Since(fi.ModTime()) > cacheTTL {
+		return nil, false
+	}
+	value, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// cachePut records value as the latest known value of key. The cache is
+// best effort: failing to write it never fails the command.
+func cachePut(ctx context.Context, key string, value []byte) {
+	if txFrom(ctx) != nil {
+		cacheDelete(key)
+		return
+	}
+	if cacheDir == "" {
+		return
+	}
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return
+	}
+	os.WriteFile(cachePath(key), value, 0600)
+}
+
+// cacheDelete forgets key. Unlike cachePut it also runs inside
+// transactions, since a stale entry is worse than a missing one.
+func cacheDelete(key string) {
+	if cacheDir == "" {
+		return
+	}
+	os.Remove(cachePath(key))
+}
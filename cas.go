@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errConflict is returned by a conditional write whose condition did not
+// hold.
+var errConflict = errors.New("conflict")
+
+// conditionalStore is implemented by stores that can write a key only if
+// its current value passes a check, atomically.
+type conditionalStore interface {
+	// PutIf writes value, expiring after ttl unless it is 0, if match
+	// accepts the current value of key, which is nil if key does not
+	// exist. It reports whether it wrote, which is false too if key
+	// changed after match was called.
+	PutIf(ctx context.Context, key string, value []byte, ttl time.Duration, match func(current []byte) (bool, error)) (bool, error)
+	// DeleteIf deletes key if match accepts its current value, reporting
+	// whether it did. Unlike Delete it keeps no history, as it is meant
+	// for coordination keys such as locks.
+	DeleteIf(ctx context.Context, key string, match func(current []byte) (bool, error)) (bool, error)
+}
+
+func (s sqlStore) PutIf(ctx context.Context, key string, value []byte, ttl time.Duration, match func(current []byte) (bool, error)) (bool, error) {
+	written := false
+	err := inTx(ctx, func(ctx context.Context) error {
+		var old []byte
+		err := queryerFor(ctx).QueryRowContext(ctx, `SELECT v FROM `+kvTable+` WHERE k = ? AND `+notExpired(), key).Scan(&old)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		exists := err == nil
+		var current []byte
+		if exists {
+			if old == nil {
+				old = []byte{}
+			}
+			if current, err = readChunks(ctx, queryerFor(ctx), old); err != nil {
+				return err
+			}
+			// an empty value is not a missing one
+			current = append([]byte{}, current...)
+		}
+		if ok, err := match(current); !ok || err != nil {
+			return err
+		}
+		if len(value) > chunkSize {
+			if value, err = putChunks(ctx, queryerFor(ctx), value); err != nil {
+				return err
+			}
+		}
+		expires, args := "NULL", []any{value}
+		if ttl != 0 {
+			expires, args = afterNow(), append(args, ttl.Microseconds())
+		}
+		var stmt string
+		if exists {
+			if err := s.auditPut(ctx, key, value); err != nil {
+				return err
+			}
+			if err := s.archive(ctx, key, value); err != nil {
+				return err
+			}
+			// compares what was read, so that a concurrent write fails this one
+			stmt = `UPDATE ` + kvTable + ` SET v = ?, updated_at = ` + currentTimestamp() + `, expires_at = ` + expires + `, updated_by = ?
+WHERE k = ? AND v = ? AND ` + notExpired()
+			args = append(args, updatedBy, key, old)
+		} else {
+			// an expired row would be in the way
+			if _, err := queryerFor(ctx).ExecContext(ctx, `DELETE FROM `+kvTable+` WHERE k = ? AND NOT `+notExpired(), key); err != nil {
+				return err
+			}
+			if err := s.auditPut(ctx, key, value); err != nil {
+				return err
+			}
+			stmt = `INSERT INTO ` + kvTable + ` (v, expires_at, updated_by, k, updated_at) VALUES (?, ` + expires + `, ?, ?, ` + currentTimestamp() + `)` + ignoreConflict("k")
+			args = append(args, updatedBy, key)
+		}
+		res, err := queryerFor(ctx).ExecContext(ctx, stmt, args...)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// roll back the chunks, history and audit written above
+			return errConflict
+		}
+		written = true
+		return nil
+	})
+	if err == errConflict {
+		return false, nil
+	}
+	return written, err
+}
+
+func (s sqlStore) DeleteIf(ctx context.Context, key string, match func(current []byte) (bool, error)) (bool, error) {
+	var old []byte
+	err := queryerFor(ctx).QueryRowContext(ctx, `SELECT v FROM `+kvTable+` WHERE k = ? AND `+notExpired(), key).Scan(&old)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	current, err := readChunks(ctx, queryerFor(ctx), old)
+	if err != nil {
+		return false, err
+	}
+	if ok, err := match(append([]byte{}, current...)); !ok || err != nil {
+		return false, err
+	}
+	if old == nil {
+		old = []byte{}
+	}
+	err = inTx(ctx, func(ctx context.Context) error {
+		if err := s.auditDelete(ctx, key); err != nil {
+			return err
+		}
+		res, err := queryerFor(ctx).ExecContext(ctx, `DELETE FROM `+kvTable+` WHERE k = ? AND v = ?`, key, old)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			// roll back the audit written above
+			return errConflict
+		}
+		return nil
+	})
+	if err == errConflict {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// putKeyValueIf is putKeyValueTTL, writing only if the current value of
+// key is expected, or if key does not exist when expected is nil.
+// Otherwise it returns errConflict.
+func putKeyValueIf(key string, value, expected []byte, ttl time.Duration) error {
+	s, ok := store.(conditionalStore)
+	if !ok {
+		return fmt.Errorf("the %s driver does not support conditional writes", sqlDriver)
+	}
+	stored, err := storedValue(key, value)
+	if err != nil {
+		return err
+	}
+	written, err := s.PutIf(ctx, namespace+key, stored, ttl, func(current []byte) (bool, error) {
+		if current == nil || expected == nil {
+			return current == nil && expected == nil, nil
+		}
+		current, err := decryptValue(current)
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(current, expected), nil
+	})
+	// the cache may hold a value older than the one found
+	cacheDelete(key)
+	if err != nil {
+		return err
+	}
+	if !written {
+		return fmt.Errorf("%s was not written, its current value does not match: %w", key, errConflict)
+	}
+	return nil
+}
+
+// ifValue is the argument of pb set --if-value, which may be empty.
+type ifValue struct {
+	value []byte
+	set   bool
+}
+
+func (v *ifValue) String() string {
+	return string(v.value)
+}
+
+func (v *ifValue) Set(s string) error {
+	v.value, v.set = []byte(s), true
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+)
+
+// chunkSize is the largest value SQL drivers store in a single row. Larger
+// values are split into rows of the chunks table, so that no statement
+// exceeds max_allowed_packet or the TiDB entry size limit, and the key/value
+// row holds a reference to them instead.
+const chunkSize = 1 << 20
+
+// chunkedMagic prefixes the references to chunked values. The whole
+// reference is the id of the chunks, which are never modified: replacing
+// a chunked value writes new chunks, and the old ones stay for pb history
+// until pb gc finds nothing refers to them.
+var chunkedMagic = []byte("pb:chunks:")
+
+func chunksTable() string {
+	return kvTable + "_chunks"
+}
+
+// putChunks writes value in chunks and returns the reference to store in
+// its place. It must run in tx, the transaction of the write of the reference.
+func putChunks(ctx context.Context, tx queryer, value []byte) ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	ref := append(append([]byte{}, chunkedMagic...), hex.EncodeToString(id)...)
+	seq := 0
+	for chunk := range slices.Chunk(value, chunkSize) {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO "+chunksTable()+" (id, seq, v) VALUES (?, ?, ?)", ref, seq, chunk); err != nil {
+			return nil, err
+		}
+		seq++
+	}
+	return ref, nil
+}
+
+// readChunks returns the value value refers to, reading its chunks one row
+// at a time, or value itself if it is not a reference.
+func readChunks(ctx context.Context, from queryer, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, chunkedMagic) {
+		return value, nil
+	}
+	rows, err := from.QueryContext(ctx, "SELECT v FROM "+chunksTable()+" WHERE id = ? ORDER BY seq", value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var buf bytes.Buffer
+	found := false
+	for rows.Next() {
+		var chunk []byte
+		if err := rows.Scan(&chunk); err != nil {
+			return nil, err
+		}
+		buf.Write(chunk)
+		found = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("the chunks of value %s are missing", value)
+	}
+	return buf.Bytes(), nil
+}
+
+// copyChunks copies the chunks value refers to, if it is a reference, for
+// pb mirror.
+func copyChunks(ctx context.Context, from, to queryer, value []byte) error {
+	if !bytes.HasPrefix(value, chunkedMagic) {
+		return nil
+	}
+	rows, err := from.QueryContext(ctx, "SELECT seq, v FROM "+chunksTable()+" WHERE id = ?", value)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var seq int
+		var chunk []byte
+		if err := rows.Scan(&seq, &chunk); err != nil {
+			return err
+		}
+		// chunks never change, so one already copied is the same
+		_, err := to.ExecContext(ctx, "INSERT INTO "+chunksTable()+" (id, seq, v) VALUES (?, ?, ?)"+
+			onConflict("id, seq", "v = "+inserted("v")), value, seq, chunk)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// gcChunks deletes chunks that neither a value nor its history refers to,
+// in any namespace. Nothing can start referring to chunks once they are
+// orphaned, as references are only ever copied from existing rows.
+func gcChunks(dryRun bool) (n int, bytes int64, err error) {
+	if ok, err := sqlTables(); !ok {
+		return 0, 0, err
+	}
+	rows, err := queryerFor(ctx).QueryContext(ctx, "SELECT id, LENGTH(v) FROM "+chunksTable()+" ORDER BY id")
+	if err != nil {
+		return 0, 0, err
+	}
+	type chunks struct {
+		id   []byte
+		size int64
+	}
+	var all []chunks
+	for rows.Next() {
+		var id []byte
+		var size int64
+		if err := rows.Scan(&id, &size); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		if len(all) > 0 && string(all[len(all)-1].id) == string(id) {
+			all[len(all)-1].size += size
+			continue
+		}
+		all = append(all, chunks{id, size})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if len(all) == 0 {
+		return 0, 0, nil
+	}
+	// one scan of the references, which are the only values of their
+	// length that start with chunkedMagic
+	refLen := len(chunkedMagic) + 32
+	cond := " WHERE LENGTH(v) = ? AND SUBSTR(v, 1, ?) = ?"
+	rows, err = queryerFor(ctx).QueryContext(ctx, "SELECT v FROM "+kvTable+cond+" UNION ALL SELECT v FROM "+historyTable()+cond,
+		refLen, len(chunkedMagic), chunkedMagic, refLen, len(chunkedMagic), chunkedMagic)
+	if err != nil {
+		return 0, 0, err
+	}
+	referenced := map[string]bool{}
+	for rows.Next() {
+		var ref []byte
+		if err := rows.Scan(&ref); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		referenced[string(ref)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	for _, c := range all {
+		if referenced[string(c.id)] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return n, bytes, err
+		}
+		if !dryRun {
+			if _, err := queryerFor(ctx).ExecContext(ctx, "DELETE FROM "+chunksTable()+" WHERE id = ?", c.id); err != nil {
+				return n, bytes, err
+			}
+		}
+		n++
+		bytes += c.size
+	}
+	return n, bytes, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gookit/color"
+)
+
+// ciMode is set by --ci, or automatically on GitHub Actions and GitLab CI.
+// It turns off prompts and colors and masks secret values in job logs.
+var ciMode bool
+
+// masked remembers which keys were already masked in this run.
+var (
+	maskedMu sync.Mutex
+	masked   = map[string]bool{}
+)
+
+func detectCI() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true" || os.Getenv("GITLAB_CI") == "true"
+}
+
+func setupCI() {
+	if !ciMode {
+		return
+	}
+	color.Enable = false
+}
+
+// isSecret reports whether key was marked as a secret with `pb set --secret`.
+func isSecret(key string) (bool, error) {
+	meta, err := getMeta(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	return meta["type"] == "secret", nil
+}
+
+// maskSecret tells GitHub Actions to redact value from the job log before
+// it is printed, if key is a secret. GitLab has no equivalent at run time:
+// mark the variable as masked in the project settings instead.
+func maskSecret(key string, value []byte) error {
+	if !ciMode || os.Getenv("GITHUB_ACTIONS") != "true" {
+		return nil
+	}
+	secret, err := isSecret(key)
+	if err != nil || !secret {
+		return err
+	}
+	maskedMu.Lock()
+	defer maskedMu.Unlock()
+	if masked[key] {
+		return nil
+	}
+	masked[key] = true
+	// masks are matched per line
+	for _, line := range strings.Split(string(value), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			fmt.Printf("::add-mask::%s\n", line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// cache holds the values read by Get for WithCache. A nil *cache caches
+// nothing.
+type cache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	// sweepAt is the size at which put next drops the expired entries
+	sweepAt int
+}
+
+type cacheEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// minSweep is the smallest sweepAt, so that small caches are not swept
+// on every put.
+const minSweep = 64
+
+func newCache(ttl time.Duration) *cache {
+	return &cache{ttl: ttl, entries: map[string]cacheEntry{}, sweepAt: minSweep}
+}
+
+func (c *cache) get(key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return nil, false
+	}
+	return bytes.Clone(e.value), true
+}
+
+func (c *cache) put(key string, value []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= c.sweepAt {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.sweepAt = max(2*len(c.entries), minSweep)
+	}
+	if value == nil {
+		// an empty value is not a missing one
+		value = []byte{}
+	}
+	c.entries[key] = cacheEntry{bytes.Clone(value), now.Add(c.ttl)}
+}
+
+func (c *cache) forget(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
@@ -0,0 +1,341 @@
+// Package client reads and writes a postboard board from Go programs,
+// talking to its database directly instead of running the pb binary.
+//
+//	c, err := client.New(os.Getenv("POSTBOARD_DSN"))
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	token, err := c.Get(ctx, "deploy/token")
+//
+// Options tune the client for the service embedding it, e.g.
+//
+//	client.New(dsn, client.WithTimeout(2*time.Second), client.WithMaxRetries(3),
+//		client.WithPool(10, 5, time.Hour), client.WithCache(30*time.Second))
+//
+// Boards on MySQL (and TiDB) and postgres are supported. The tables must
+// already exist, which running any pb command against the database takes
+// care of. Writes are recorded in pb history and pb audit as pb's own are,
+// and large values are chunked as pb does, but hooks do not run. Values
+// are read as pb reads them, except encrypted ones: the client has no
+// key, so Get returns ErrEncrypted for them, and Set writes values
+// unencrypted and uncompressed whatever the config of pb.
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// DefaultTable is the table pb uses when the config sets none.
+const DefaultTable = "postboard_kvs"
+
+// notExpired skips keys whose pb set --ttl has passed.
+const notExpired = "(expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP(6))"
+
+// likeEscaper quotes the characters LIKE treats as patterns, with !.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// ErrNotFound is returned by Get for a key that has no value, or whose
+// expiry has passed.
+var ErrNotFound = errors.New("postboard: key not found")
+
+// Client is a connection to one board. It is safe for concurrent use.
+type Client struct {
+	db         *sql.DB
+	postgres   bool
+	table      string
+	namespace  string
+	user       string
+	maxRetries int
+	timeout    time.Duration
+	pool       *pool
+	cache      *cache
+}
+
+// pool is the connection pool settings of WithPool.
+type pool struct {
+	maxOpen, maxIdle int
+	maxLifetime      time.Duration
+}
+
+// Option configures a Client, see New.
+type Option func(*Client)
+
+// WithTable selects the table of the board, as Table in the pb config.
+func WithTable(table string) Option {
+	return func(c *Client) { c.table = table }
+}
+
+// WithNamespace prepends namespace to every key, as Namespace in the pb
+// config, with a / added if it does not end with one.
+func WithNamespace(namespace string) Option {
+	if namespace != "" && !strings.HasSuffix(namespace, "/") {
+		namespace += "/"
+	}
+	return func(c *Client) { c.namespace = namespace }
+}
+
+// WithUser records user as who wrote the values set, as User in the pb
+// config. It defaults to the OS user@hostname.
+func WithUser(user string) Option {
+	return func(c *Client) { c.user = user }
+}
+
+// WithMaxRetries tries a call up to n more times when it fails with an
+// error that may go away, such as a dropped connection or a deadlock,
+// waiting longer after each attempt. Calls are not retried by default.
+func WithMaxRetries(n int) Option {
+	return func(c *Client) { c.maxRetries = n }
+}
+
+// WithTimeout bounds every call, retries included, to d on top of the
+// deadline of its context.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithPool sizes the pool of database connections, as MaxOpenConns,
+// MaxIdleConns and ConnMaxLifetime in the pb config. Zero keeps the
+// database/sql default of each.
+func WithPool(maxOpen, maxIdle int, maxLifetime time.Duration) Option {
+	return func(c *Client) { c.pool = &pool{maxOpen, maxIdle, maxLifetime} }
+}
+
+// WithCache keeps the values read by Get in memory for ttl, so that a key
+// read often costs one query per ttl. Set and Delete drop the key from the
+// cache, but writes by others show up only once it expires.
+func WithCache(ttl time.Duration) Option {
+	return func(c *Client) { c.cache = newCache(ttl) }
+}
+
+// New connects to the board in the database at dsn, a MySQL DSN such as
+// user:pass@tcp(host:4000)/test or a postgres:// URL. It does not check
+// that the database is reachable, the first call does.
+func New(dsn string, opts ...Option) (*Client, error) {
+	c := &Client{table: DefaultTable, user: "unknown"}
+	if u, err := user.Current(); err == nil {
+		c.user = u.Username
+	}
+	host, _ := os.Hostname()
+	c.user += "@" + host
+	for _, opt := range opts {
+		opt(c)
+	}
+	var err error
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		c.postgres = true
+		c.db, err = sql.Open("pgx", dsn)
+	} else {
+		if _, err = mysql.ParseDSN(dsn); err != nil {
+			return nil, err
+		}
+		c.db, err = sql.Open("mysql", dsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p := c.pool; p != nil {
+		if p.maxOpen != 0 {
+			c.db.SetMaxOpenConns(p.maxOpen)
+		}
+		if p.maxIdle != 0 {
+			c.db.SetMaxIdleConns(p.maxIdle)
+		}
+		if p.maxLifetime != 0 {
+			c.db.SetConnMaxLifetime(p.maxLifetime)
+		}
+	}
+	return c, nil
+}
+
+// Close closes the connections to the database.
+func (c *Client) Close() error {
+	return c.db.Close()
+}
+
+// query adapts a statement written with ? placeholders to the database.
+func (c *Client) query(stmt string) string {
+	if !c.postgres {
+		return stmt
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range stmt {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Get returns the value of key, ErrNotFound, or ErrEncrypted if pb
+// encrypted it.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	if value, ok := c.cache.get(key); ok {
+		return value, nil
+	}
+	var value []byte
+	err := c.do(ctx, func(ctx context.Context) error {
+		err := c.db.QueryRowContext(ctx, c.query("SELECT v FROM "+c.table+" WHERE k = ? AND "+notExpired), c.namespace+key).Scan(&value)
+		if err != nil {
+			return err
+		}
+		value, err = c.readChunks(ctx, c.db, value)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if value, err = decodeValue(value); err != nil {
+		return nil, err
+	}
+	c.cache.put(key, value)
+	return value, nil
+}
+
+// Set creates or replaces the value of key, keeping the value it replaces
+// in pb history.
+func (c *Client) Set(ctx context.Context, key string, value []byte) error {
+	upsert := " ON DUPLICATE KEY UPDATE v = VALUES(v), updated_at = CURRENT_TIMESTAMP(6), updated_by = VALUES(updated_by), expires_at = NULL"
+	if c.postgres {
+		upsert = " ON CONFLICT (k) DO UPDATE SET v = excluded.v, updated_at = CURRENT_TIMESTAMP(6), updated_by = excluded.updated_by, expires_at = NULL"
+	}
+	defer c.cache.forget(key)
+	value = encodeValue(value)
+	sum := sha256.Sum256(value)
+	return c.inTx(ctx, func(tx *sql.Tx) error {
+		stored := value
+		if len(value) > chunkSize {
+			var err error
+			if stored, err = c.putChunks(ctx, tx, value); err != nil {
+				return err
+			}
+		}
+		_, err := tx.ExecContext(ctx, c.query(`INSERT INTO `+c.table+`_audit (k, action, value_hash, old_version, changed_by, changed_at)
+SELECT ?, 'set', ?, CASE WHEN EXISTS (SELECT 1 FROM `+c.table+` WHERE k = ?) THEN `+c.oldVersion()+` END, ?, CURRENT_TIMESTAMP(6)`),
+			c.namespace+key, hex.EncodeToString(sum[:]), c.namespace+key, c.namespace+key, c.user)
+		if err != nil {
+			return err
+		}
+		if err := c.archive(ctx, tx, key, stored); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, c.query("INSERT INTO "+c.table+" (k, v, updated_at, updated_by) VALUES (?, ?, CURRENT_TIMESTAMP(6), ?)"+upsert),
+			c.namespace+key, stored, c.user)
+		return err
+	})
+}
+
+// List returns the keys starting with prefix, at most limit of them.
+func (c *Client) List(ctx context.Context, prefix string, limit int) ([]string, error) {
+	var keys []string
+	err := c.do(ctx, func(ctx context.Context) error {
+		keys = nil
+		rows, err := c.db.QueryContext(ctx, c.query("SELECT k FROM "+c.table+" WHERE k LIKE ? ESCAPE '!' AND "+notExpired+" ORDER BY k LIMIT ?"),
+			likeEscaper.Replace(c.namespace+prefix)+"%", limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				return err
+			}
+			keys = append(keys, strings.TrimPrefix(key, c.namespace))
+		}
+		return rows.Err()
+	})
+	return keys, err
+}
+
+// Delete removes key and its metadata and reports whether it existed. Its
+// value is kept in pb history.
+func (c *Client) Delete(ctx context.Context, key string) (bool, error) {
+	defer c.cache.forget(key)
+	deleted := false
+	err := c.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, c.query(`INSERT INTO `+c.table+`_audit (k, action, value_hash, old_version, changed_by, changed_at)
+SELECT k, 'delete', NULL, `+c.oldVersion()+`, ?, CURRENT_TIMESTAMP(6) FROM `+c.table+` WHERE k = ?`),
+			c.namespace+key, c.user, c.namespace+key)
+		if err != nil {
+			return err
+		}
+		if err := c.archive(ctx, tx, key, nil); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, c.query("DELETE FROM "+c.table+" WHERE k = ?"), c.namespace+key)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, c.query("DELETE FROM "+c.table+"_meta WHERE k = ?"), c.namespace+key); err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		deleted = n > 0
+		return err
+	})
+	return deleted, err
+}
+
+// inTx runs fn in a transaction, all of it again when do retries, so
+// that a write and the history and audit rows pb keeps of it are made
+// together.
+func (c *Client) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return c.do(ctx, func(ctx context.Context) error {
+		tx, err := c.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// oldVersion is the version in pb history that the current value of the
+// key, ? being the key, gets when it is archived.
+func (c *Client) oldVersion() string {
+	return `(SELECT COALESCE(MAX(version), 0) + 1 FROM ` + c.table + `_history WHERE k = ?)`
+}
+
+// archive copies the current value of key to pb history before it is
+// replaced by value, or deleted if value is nil, as pb does. Locking the
+// row numbers the versions of concurrent writers one after the other.
+func (c *Client) archive(ctx context.Context, tx *sql.Tx, key string, value []byte) error {
+	var locked string
+	err := tx.QueryRowContext(ctx, c.query("SELECT k FROM "+c.table+" WHERE k = ? FOR UPDATE"), c.namespace+key).Scan(&locked)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	stmt := `INSERT INTO ` + c.table + `_history (k, version, v, updated_at)
+SELECT k, ` + c.oldVersion() + `, v, updated_at FROM ` + c.table + ` WHERE k = ?`
+	args := []any{c.namespace + key, c.namespace + key}
+	if value != nil {
+		// rewriting the same value adds no version
+		stmt += " AND v <> ?"
+		args = append(args, value)
+	}
+	_, err = tx.ExecContext(ctx, c.query(stmt), args...)
+	return err
+}
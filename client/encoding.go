@@ -0,0 +1,136 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The prefixes pb gives values as stored, see compress.go, crypto.go,
+// recipients.go and chunk.go in pb. Any value starting with encodedPrefix
+// has one of them.
+var (
+	encodedPrefix   = []byte("pb:")
+	rawMagic        = []byte("pb:raw:")
+	compressedMagic = []byte("pb:z")
+	chunkedMagic    = []byte("pb:chunks:")
+	encryptedMagics = [][]byte{[]byte("pb:aesgcm:"), []byte("pb:age:"), []byte("pb:gpg:")}
+)
+
+// chunkSize is the largest value stored in a single row, as in pb. Larger
+// values are split into rows of the chunks table.
+const chunkSize = 1 << 20
+
+// ErrEncrypted is returned by Get for a value that pb encrypted, which
+// only pb with the key can read.
+var ErrEncrypted = errors.New("postboard: value is encrypted, read it with pb")
+
+var zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) { return zstd.NewReader(nil) })
+
+// decodeValue undoes the compression and raw marker of a value as stored,
+// after its chunks are read.
+func decodeValue(value []byte) ([]byte, error) {
+	if plain, ok := bytes.CutPrefix(value, rawMagic); ok {
+		return plain, nil
+	}
+	for _, magic := range encryptedMagics {
+		if bytes.HasPrefix(value, magic) {
+			return nil, ErrEncrypted
+		}
+	}
+	if !bytes.HasPrefix(value, compressedMagic) || len(value) == len(compressedMagic) {
+		return value, nil
+	}
+	data := value[len(compressedMagic)+1:]
+	switch value[len(compressedMagic)] {
+	case 'g':
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("postboard: cannot decompress value: %w", err)
+		}
+		return io.ReadAll(r)
+	case 'z':
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		plain, err := dec.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("postboard: cannot decompress value: %w", err)
+		}
+		return plain, nil
+	}
+	return value, nil
+}
+
+// encodeValue marks value as raw if pb would otherwise read it as encoded.
+// Values are not compressed, which pb reads all the same.
+func encodeValue(value []byte) []byte {
+	if !bytes.HasPrefix(value, encodedPrefix) {
+		return value
+	}
+	return append(slices.Clone(rawMagic), value...)
+}
+
+// readChunks returns the value value refers to if it is a reference to
+// chunks, and value otherwise.
+func (c *Client) readChunks(ctx context.Context, q queryer, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, chunkedMagic) {
+		return value, nil
+	}
+	rows, err := q.QueryContext(ctx, c.query("SELECT v FROM "+c.table+"_chunks WHERE id = ? ORDER BY seq"), value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var buf bytes.Buffer
+	found := false
+	for rows.Next() {
+		var chunk []byte
+		if err := rows.Scan(&chunk); err != nil {
+			return nil, err
+		}
+		buf.Write(chunk)
+		found = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("postboard: the chunks of value %s are missing", value)
+	}
+	return buf.Bytes(), nil
+}
+
+// putChunks writes value in chunks in tx and returns the reference to
+// store in its place.
+func (c *Client) putChunks(ctx context.Context, tx *sql.Tx, value []byte) ([]byte, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	ref := append(slices.Clone(chunkedMagic), hex.EncodeToString(id)...)
+	seq := 0
+	for chunk := range slices.Chunk(value, chunkSize) {
+		if _, err := tx.ExecContext(ctx, c.query("INSERT INTO "+c.table+"_chunks (id, seq, v) VALUES (?, ?, ?)"), ref, seq, chunk); err != nil {
+			return nil, err
+		}
+		seq++
+	}
+	return ref, nil
+}
+
+// queryer is what readChunks reads with, the database or a transaction.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
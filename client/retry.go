@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// retryDelay is the wait before the first retry, doubled for each one
+// after it up to maxRetryDelay.
+const (
+	retryDelay    = 50 * time.Millisecond
+	maxRetryDelay = 2 * time.Second
+)
+
+// do runs fn under the timeout of WithTimeout, trying again after
+// transient errors as many times as WithMaxRetries allows.
+func (c *Client) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || attempt >= c.maxRetries || !transient(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, maxRetryDelay)
+	}
+}
+
+// transient reports whether err may not happen again: a broken or timed
+// out connection, or a transaction that lost a deadlock or a lock wait.
+func transient(err error) bool {
+	var netErr net.Error
+	var myErr *mysql.MySQLError
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, mysql.ErrInvalidConn), errors.As(err, &netErr):
+		return true
+	case errors.As(err, &myErr):
+		// lock wait timeout, deadlock
+		return myErr.Number == 1205 || myErr.Number == 1213
+	case errors.As(err, &pgErr):
+		// serialization failure, deadlock
+		return pgErr.Code == "40001" || pgErr.Code == "40P01"
+	}
+	return false
+}
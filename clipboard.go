@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// clipboardTools returns the commands that copy stdin to the clipboard and
+// print the clipboard, for the current platform.
+func clipboardTools() (copy, paste []string, err error) {
+	switch runtime.GOOS {
+	case "windows":
+		// PowerShell rather than clip.exe, which mangles UTF-8
+		return []string{"powershell", "-NoProfile", "-Command",
+				"[Console]::InputEncoding = [Text.Encoding]::UTF8; Set-Clipboard -Value ([Console]::In.ReadToEnd())"},
+			[]string{"powershell", "-NoProfile", "-Command",
+				"[Console]::OutputEncoding = [Text.Encoding]::UTF8; Get-Clipboard -Raw"},
+			nil
+	case "darwin":
+		return []string{"pbcopy"}, []string{"pbpaste"}, nil
+	}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		if _, err := exec.LookPath("wl-copy"); err == nil {
+			return []string{"wl-copy"}, []string{"wl-paste", "--no-newline"}, nil
+		}
+	}
+	if _, err := exec.LookPath("xclip"); err == nil {
+		return []string{"xclip", "-selection", "clipboard"}, []string{"xclip", "-selection", "clipboard", "-o"}, nil
+	}
+	if _, err := exec.LookPath("xsel"); err == nil {
+		return []string{"xsel", "--clipboard", "--input"}, []string{"xsel", "--clipboard", "--output"}, nil
+	}
+	return nil, nil, errors.New("no clipboard tool found, install wl-clipboard, xclip or xsel")
+}
+
+func copyToClipboard(value []byte) error {
+	tool, _, err := clipboardTools()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(tool[0], tool[1:]...)
+	cmd.Stdin = bytes.NewReader(value)
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func pasteFromClipboard() ([]byte, error) {
+	_, tool, err := clipboardTools()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(tool[0], tool[1:]...)
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
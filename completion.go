@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// keyCommands are the commands whose arguments are keys, which the
+// completion scripts complete from the board.
+var keyCommands = []string{
+	"get", "set", "del", "exists", "stat", "edit", "append", "incr", "decr",
+	"history", "rollback", "audit", "watch", "refresh", "mget", "cp", "mv", "export",
+	"env", "exec", "ui", "share",
+}
+
+// completeKeys prints the keys starting with prefix, up to the next / so
+// that a prefix with many keys under it completes a level at a time.
+func completeKeys(prefix string) error {
+	keys, err := listKeysPage(ctx, prefix, "", listPage)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if i := strings.Index(key[len(prefix):], "/"); i >= 0 {
+			key = key[:len(prefix)+i+1]
+		}
+		if !seen[key] {
+			seen[key] = true
+			fmt.Println(key)
+		}
+	}
+	return nil
+}
+
+const bashCompletion = `# bash completion for pb, from pb completion bash
+_pb() {
+	local cur=${COMP_WORDS[COMP_CWORD]} cmd= i
+	local -a globals=()
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		case ${COMP_WORDS[i]} in
+		-n|--namespace|--profile) globals+=("${COMP_WORDS[i]}" "${COMP_WORDS[i+1]}"); ((i++)) ;;
+		-n=*|--namespace=*|--profile=*) globals+=("${COMP_WORDS[i]}") ;;
+		-*) ;;
+		*) cmd=${COMP_WORDS[i]}; break ;;
+		esac
+	done
+	case $cmd in
+	'')
+		COMPREPLY=($(compgen -W "@COMMANDS@" -- "$cur"))
+		;;
+	@KEYCOMMANDS@)
+		[[ $cur == -* ]] && return
+		local IFS=$'\n'
+		COMPREPLY=($(pb "${globals[@]}" complete-keys "$cur" 2>/dev/null))
+		[[ ${#COMPREPLY[@]} -eq 1 && ${COMPREPLY[0]} == */ ]] && compopt -o nospace
+		;;
+@SUBCOMMANDS@	esac
+}
+complete -F _pb pb
+`
+
+const zshCompletion = `#compdef pb
+# zsh completion for pb, from pb completion zsh
+_pb() {
+	local i cmd
+	local -a globals commands keys
+	for ((i = 2; i < CURRENT; i++)); do
+		case $words[i] in
+		-n|--namespace|--profile) globals+=($words[i] $words[i+1]); ((i++)) ;;
+		-n=*|--namespace=*|--profile=*) globals+=($words[i]) ;;
+		-*) ;;
+		*) cmd=$words[i]; break ;;
+		esac
+	done
+	case $cmd in
+	'')
+		commands=(
+@DESCRIBED@		)
+		_describe command commands
+		;;
+	@KEYCOMMANDS@)
+		[[ $PREFIX == -* ]] && return
+		keys=(${(f)"$(pb $globals complete-keys $PREFIX 2>/dev/null)"})
+		compadd -S '' -- ${(M)keys:#*/}
+		compadd -- ${keys:#*/}
+		;;
+@SUBCOMMANDS@	esac
+}
+compdef _pb pb
+`
+
+const fishCompletion = `# fish completion for pb, from pb completion fish
+function __pb_command
+	set -l words (commandline -opc)
+	set -e words[1]
+	while set -q words[1]
+		switch $words[1]
+			case -n --namespace --profile
+				set -e words[1]
+			case '-*'
+			case '*'
+				echo $words[1]
+				return 0
+		end
+		set -e words[1]
+	end
+	return 1
+end
+
+function __pb_keys
+	set -l words (commandline -opc)
+	set -l globals
+	for i in (seq 2 (count $words))
+		switch $words[$i]
+			case -n --namespace --profile
+				set -a globals $words[$i] $words[(math $i + 1)]
+			case '-n=*' '--namespace=*' '--profile=*'
+				set -a globals $words[$i]
+		end
+	end
+	pb $globals complete-keys (commandline -ct) 2>/dev/null
+end
+
+complete -c pb -f
+@DESCRIBED@complete -c pb -n 'contains -- (__pb_command) @KEYCOMMANDS@; and not string match -q -- "-*" (commandline -ct)' -a '(__pb_keys)'
+@SUBCOMMANDS@`
+
+// completionScript returns the completion script for shell, listing the
+// commands of app.
+func completionScript(app *gcli.App, shell string) (string, error) {
+	var names []string
+	for name, c := range app.Commands() {
+		if c.Visible() {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	var described, subcommands strings.Builder
+	for _, name := range names {
+		c := app.GetCommand(name)
+		desc := strings.ReplaceAll(c.Desc, "'", `'\''`)
+		var subs []string
+		for sub, sc := range c.Commands() {
+			if sc.Visible() {
+				subs = append(subs, sub)
+			}
+		}
+		slices.Sort(subs)
+		switch shell {
+		case "bash":
+			if len(subs) > 0 {
+				fmt.Fprintf(&subcommands, "\t%s)\n\t\t((i + 1 == COMP_CWORD)) && COMPREPLY=($(compgen -W %q -- \"$cur\"))\n\t\t;;\n", name, strings.Join(subs, " "))
+			}
+		case "zsh":
+			fmt.Fprintf(&described, "\t\t\t'%s:%s'\n", name, desc)
+			if len(subs) > 0 {
+				fmt.Fprintf(&subcommands, "\t%s)\n\t\t((i + 1 == CURRENT)) && compadd -- %s\n\t\t;;\n", name, strings.Join(subs, " "))
+			}
+		case "fish":
+			fmt.Fprintf(&described, "complete -c pb -n 'not __pb_command >/dev/null' -a %s -d '%s'\n", name, desc)
+			if len(subs) > 0 {
+				fmt.Fprintf(&subcommands, "complete -c pb -n 'test (__pb_command) = %s; and test (count (commandline -opc)) -le 2' -a '%s'\n", name, strings.Join(subs, " "))
+			}
+		}
+	}
+	var script string
+	keyPattern := strings.Join(keyCommands, "|")
+	switch shell {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script, keyPattern = fishCompletion, strings.Join(keyCommands, " ")
+	default:
+		return "", fmt.Errorf("unknown shell %q, want bash, zsh or fish", shell)
+	}
+	return strings.NewReplacer(
+		"@COMMANDS@", strings.Join(names, " "),
+		"@DESCRIBED@", described.String(),
+		"@KEYCOMMANDS@", keyPattern,
+		"@SUBCOMMANDS@", subcommands.String(),
+	).Replace(script), nil
+}
+
+func completionCommand() *gcli.Command {
+	return &gcli.Command{
+		Name: "completion",
+		Desc: "Print the shell completion script for bash, zsh or fish",
+		Help: `Commands are completed from pb itself and keys from the board, a level
+of / at a time:
+
+  source <(pb completion bash)           # in ~/.bashrc
+  source <(pb completion zsh)            # in ~/.zshrc
+  pb completion fish > ~/.config/fish/completions/pb.fish`,
+		Config: func(c *gcli.Command) {
+			c.AddArg("shell", "bash, zsh or fish", true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			script, err := completionScript(c.App(), c.Arg("shell").String())
+			if err != nil {
+				return err
+			}
+			fmt.Print(script)
+			return nil
+		},
+	}
+}
+
+// completeKeysCommand is what the completion scripts run to complete keys.
+func completeKeysCommand() *gcli.Command {
+	return &gcli.Command{
+		Name:   "complete-keys",
+		Desc:   "Print the keys completing a prefix, for the completion scripts",
+		Hidden: true,
+		Config: func(c *gcli.Command) {
+			c.AddArg("prefix", "The beginning of the key", false)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			return completeKeys(c.Arg("prefix").String())
+		},
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressedMagic prefixes compressed values, followed by one byte naming
+// the algorithm. Values without it are read as they are, so turning
+// compression on or off does not affect values already stored.
+var compressedMagic = []byte("pb:z")
+
+// rawMagic prefixes the values stored as they are that start with
+// encodedPrefix themselves, so that they are not read as compressed,
+// encrypted or chunked.
+var rawMagic = []byte("pb:raw:")
+
+const (
+	compressedGzip byte = 'g'
+	compressedZstd byte = 'z'
+)
+
+// defaultCompressMinSize is CompressionConfig.MinSize when it is not set.
+const defaultCompressMinSize = 1024
+
+// CompressionConfig compresses values of at least MinSize bytes before they
+// are encrypted and stored. Values that do not shrink are stored as they
+// are.
+type CompressionConfig struct {
+	// Algorithm is zstd, the default, or gzip.
+	Algorithm string `json:"Algorithm,omitempty"`
+	// MinSize defaults to 1024 bytes.
+	MinSize int `json:"MinSize,omitempty"`
+}
+
+var compression *CompressionConfig
+
+func setupCompression(cfg *Config) {
+	compression = cfg.Compression
+}
+
+func (c *CompressionConfig) validate(field string) error {
+	switch c.Algorithm {
+	case "", "zstd", "gzip":
+	default:
+		return fieldErrorf(field+".Algorithm", "must be zstd or gzip")
+	}
+	if c.MinSize < 0 {
+		return fieldErrorf(field+".MinSize", "must not be negative")
+	}
+	return nil
+}
+
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) { return zstd.NewWriter(nil) })
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) { return zstd.NewReader(nil) })
+)
+
+// compressValue compresses value as configured, or marks it with rawMagic
+// if it needs to be.
+func compressValue(value []byte) ([]byte, error) {
+	if compression == nil {
+		return rawValue(value), nil
+	}
+	minSize := compression.MinSize
+	if minSize == 0 {
+		minSize = defaultCompressMinSize
+	}
+	if len(value) < minSize {
+		return rawValue(value), nil
+	}
+	out := append([]byte{}, compressedMagic...)
+	if compression.Algorithm == "gzip" {
+		var buf bytes.Buffer
+		buf.Write(append(out, compressedGzip))
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		out = buf.Bytes()
+	} else {
+		enc, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		out = enc.EncodeAll(value, append(out, compressedZstd))
+	}
+	if len(out) >= len(value) {
+		return rawValue(value), nil
+	}
+	return out, nil
+}
+
+// rawValue prefixes value with rawMagic if it starts with encodedPrefix.
+func rawValue(value []byte) []byte {
+	if !bytes.HasPrefix(value, encodedPrefix) {
+		return value
+	}
+	return append(append([]byte{}, rawMagic...), value...)
+}
+
+// decompressValue undoes compressValue and passes anything else through
+// unchanged.
+func decompressValue(value []byte) ([]byte, error) {
+	if plain, ok := bytes.CutPrefix(value, rawMagic); ok {
+		return plain, nil
+	}
+	if !bytes.HasPrefix(value, compressedMagic) || len(value) == len(compressedMagic) {
+		return value, nil
+	}
+	data := value[len(compressedMagic)+1:]
+	switch value[len(compressedMagic)] {
+	case compressedGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress value: %w", err)
+		}
+		return io.ReadAll(r)
+	case compressedZstd:
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		plain, err := dec.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress value: %w", err)
+		}
+		return plain, nil
+	}
+	// not written by compressValue
+	return value, nil
+}
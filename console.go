@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/gookit/color"
+	"golang.org/x/term"
+)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// readLine reads a line from stdin without its line ending, which is \r\n
+// when typed in a Windows console.
+func readLine() (string, error) {
+	line, err := stdin.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// readAll reads stdin to its end, byte for byte. At a terminal it first
+// says how to end the input.
+func readAll() ([]byte, error) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprintln(os.Stderr, "Reading the value from stdin, end it with Ctrl-D (Ctrl-Z Enter on Windows)")
+	}
+	return io.ReadAll(stdin)
+}
+
+// readPassword reads a line from the terminal without echoing it, or a
+// plain line if stdin is not a terminal.
+func readPassword() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return readLine()
+	}
+	b, err := term.ReadPassword(fd)
+	os.Stdout.WriteString("\n")
+	return string(b), err
+}
+
+// setupConsole turns colors off when they would show up as escape codes:
+// when output is redirected, or when NO_COLOR is set. Windows consoles that
+// support escape codes have them enabled by the color package.
+func setupConsole() {
+	if os.Getenv("NO_COLOR") != "" || !term.IsTerminal(int(os.Stdout.Fd())) {
+		color.Enable = false
+	}
+}
+
+// confirm asks a yes/no question on the console, defaulting to no. It
+// refuses in CI mode, where nobody can answer.
+func confirm(question string) (bool, error) {
+	if ciMode {
+		return false, fmt.Errorf("%s: prompts are disabled in CI mode, pass --yes", question)
+	}
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := readLine()
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// consulClient talks to the KV API of a Consul agent.
+type consulClient struct {
+	addr  string
+	token string
+	// namespace is the Consul Enterprise namespace, if any
+	namespace string
+}
+
+// consulPair is a key as the KV API lists it, with its value in base64.
+type consulPair struct {
+	Key   string `json:"Key"`
+	Value []byte `json:"Value"`
+}
+
+// newConsulClient reaches addr, or CONSUL_HTTP_ADDR as the consul CLI
+// does, with the ACL token in CONSUL_HTTP_TOKEN, if any.
+func newConsulClient(addr string) *consulClient {
+	if addr == "" {
+		addr = "127.0.0.1:8500"
+	}
+	if !strings.Contains(addr, "://") {
+		scheme := "http://"
+		if os.Getenv("CONSUL_HTTP_SSL") == "true" {
+			scheme = "https://"
+		}
+		addr = scheme + addr
+	}
+	return &consulClient{addr: strings.TrimSuffix(addr, "/"), token: os.Getenv("CONSUL_HTTP_TOKEN"), namespace: os.Getenv("CONSUL_NAMESPACE")}
+}
+
+func (c *consulClient) do(method, key, query string, body []byte, out any) error {
+	u := c.addr + "/v1/kv/" + (&url.URL{Path: key}).EscapedPath()
+	if query != "" {
+		u += "?" + query
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	if c.namespace != "" {
+		req.Header.Set("X-Consul-Namespace", c.namespace)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && method == "GET" {
+		// nothing under the prefix
+		return nil
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("consul %s %s: %s: %s", method, key, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// list returns the values of the keys under path, by their names without
+// it. The keys ending in /, which Consul UIs make as folders, are left out.
+func (c *consulClient) list(path string) (map[string][]byte, error) {
+	var pairs []consulPair
+	if err := c.do("GET", path, "recurse=true", nil, &pairs); err != nil {
+		return nil, err
+	}
+	values := map[string][]byte{}
+	for _, p := range pairs {
+		if strings.HasSuffix(p.Key, "/") {
+			continue
+		}
+		values[strings.TrimPrefix(p.Key, path)] = p.Value
+	}
+	return values, nil
+}
+
+func consulCommand() *gcli.Command {
+	var addr, prefix string
+	var dryRun, del bool
+	config := func(c *gcli.Command) {
+		c.StrOpt(&addr, "addr", "", os.Getenv("CONSUL_HTTP_ADDR"), "The Consul agent (default $CONSUL_HTTP_ADDR, else 127.0.0.1:8500)")
+		c.StrOpt(&prefix, "prefix", "p", "", "The key prefix to copy the keys to or from, e.g. app/")
+		c.BoolOpt(&del, "delete", "", false, "Delete the keys that the other side does not have")
+		c.BoolOpt(&dryRun, "dry-run", "n", false, "Print what would be done without changing anything")
+		c.AddArg("path", "The Consul key prefix, e.g. config/app/", true)
+	}
+	return &gcli.Command{
+		Name: "consul",
+		Desc: "Copy the keys under a Consul KV prefix to or from a key prefix",
+		Help: `The key path+NAME in Consul is the key prefix+NAME, so the hierarchy
+is kept both ways:
+
+  pb consul pull --prefix app/ config/app/
+  pb consul push --delete --prefix app/ config/app/
+
+Authenticates with the ACL token in CONSUL_HTTP_TOKEN, if set.
+CONSUL_NAMESPACE is honoured. push only writes the keys that differ.`,
+		Subs: []*gcli.Command{
+			{
+				Name:   "pull",
+				Desc:   "Store the keys under a Consul prefix",
+				Config: config,
+				Func: func(c *gcli.Command, args []string) error {
+					path := c.Arg("path").String()
+					if del && prefix == "" {
+						return fmt.Errorf("--prefix is required with --delete, mirroring onto the whole board is never intended")
+					}
+					values, err := newConsulClient(addr).list(path)
+					if err != nil {
+						return err
+					}
+					var keys []pulledSecret
+					for _, name := range slices.Sorted(maps.Keys(values)) {
+						keys = append(keys, pulledSecret{Name: name, Value: string(values[name])})
+					}
+					return storeSecrets(prefix, keys, dryRun, del)
+				},
+			},
+			{
+				Name:   "push",
+				Desc:   "Set the keys under a Consul prefix from those under a key prefix",
+				Config: config,
+				Func: func(c *gcli.Command, args []string) error {
+					path := c.Arg("path").String()
+					if prefix == "" {
+						return fmt.Errorf("--prefix is required, pushing the whole board is never intended")
+					}
+					if err := connect(); err != nil {
+						return err
+					}
+					dryRun = dryRun || dryRunWrites
+					keys, err := listKeysWithPrefix(ctx, prefix)
+					if err != nil {
+						return err
+					}
+					consul := newConsulClient(addr)
+					current, err := consul.list(path)
+					if err != nil {
+						return err
+					}
+					pushed := map[string]bool{}
+					for _, key := range keys {
+						value, err := getKey(ctx, key)
+						if err != nil {
+							return err
+						}
+						name := strings.TrimPrefix(key, prefix)
+						pushed[name] = true
+						if old, ok := current[name]; ok && bytes.Equal(old, value) {
+							continue
+						}
+						fmt.Printf("push %s -> %s\n", key, path+name)
+						if dryRun {
+							continue
+						}
+						if err := consul.do("PUT", path+name, "", value, nil); err != nil {
+							return err
+						}
+					}
+					if !del {
+						return nil
+					}
+					for _, name := range slices.Sorted(maps.Keys(current)) {
+						if pushed[name] {
+							continue
+						}
+						fmt.Printf("delete %s\n", path+name)
+						if dryRun {
+							continue
+						}
+						if err := consul.do("DELETE", path+name, "", nil, nil); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
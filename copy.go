@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// copyingStore is implemented by stores that can copy a key and its
+// metadata without the value leaving the server.
+type copyingStore interface {
+	// Copy replaces to with the value and metadata of from, reporting
+	// whether from exists.
+	Copy(ctx context.Context, from, to string) (bool, error)
+}
+
+func (s sqlStore) Copy(ctx context.Context, from, to string) (bool, error) {
+	found := false
+	err := inTx(ctx, func(ctx context.Context) error {
+		tx := queryerFor(ctx)
+		var value []byte
+		err := tx.QueryRowContext(ctx, "SELECT v FROM "+kvTable+" WHERE k = ? AND "+notExpired(), from).Scan(&value)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.auditPut(ctx, to, value); err != nil {
+			return err
+		}
+		if err := s.archive(ctx, to, nil); err != nil {
+			return err
+		}
+		// in a transaction, the destination can be replaced rather than
+		// upserted
+		for _, table := range []string{kvTable, metaTable()} {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE k = ?", to); err != nil {
+				return err
+			}
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO "+kvTable+" (k, v, updated_at, updated_by, expires_at) SELECT ?, v, "+currentTimestamp()+", ?, expires_at FROM "+kvTable+" WHERE k = ?", to, updatedBy, from)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO "+metaTable()+" (k, name, v) SELECT ?, name, v FROM "+metaTable()+" WHERE k = ?", to, from)
+		found = err == nil
+		return err
+	})
+	return found, err
+}
+
+// copyKey replaces to with the value and metadata of from, as stored:
+// hooks do not run and encrypted values stay encrypted. It reports whether
+// from exists.
+func copyKey(ctx context.Context, from, to string) (bool, error) {
+	defer cacheDelete(to)
+	if s, ok := store.(copyingStore); ok {
+		return s.Copy(ctx, namespace+from, namespace+to)
+	}
+	value, err := store.Get(ctx, namespace+from)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := store.Put(ctx, namespace+to, value); err != nil {
+		return false, err
+	}
+	fields, err := getMeta(ctx, from)
+	if err != nil {
+		return false, err
+	}
+	if err := deleteMeta(ctx, to); err != nil {
+		return false, err
+	}
+	if len(fields) > 0 {
+		return true, setMeta(ctx, to, fields)
+	}
+	return true, nil
+}
+
+// copyKeys copies, or moves, from to to. A from ending in * copies every
+// key under that prefix, renaming the prefix to to.
+func copyKeys(from, to string, move bool) (n int, err error) {
+	copyOne := func(ctx context.Context, from, to string) error {
+		if from == to {
+			return fmt.Errorf("cannot copy %s onto itself", from)
+		}
+		found, err := copyKey(ctx, from, to)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%s: %w", from, sql.ErrNoRows)
+		}
+		if move {
+			_, err = deleteKey(ctx, from)
+		}
+		return err
+	}
+	prefix, ok := strings.CutSuffix(from, "*")
+	if !ok {
+		return 1, inTx(ctx, func(ctx context.Context) error { return copyOne(ctx, from, to) })
+	}
+	keys, err := listKeysWithPrefix(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	if len(keys) == 0 {
+		return 0, fmt.Errorf("no keys start with %q: %w", prefix, sql.ErrNoRows)
+	}
+	// one transaction is one connection, which bulkFlags could not spread
+	// keys over
+	err = inTx(ctx, func(ctx context.Context) error {
+		for _, key := range keys {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := copyOne(ctx, key, to+strings.TrimPrefix(key, prefix)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(keys), err
+}
+
+func copyCommand(name string, move bool) *gcli.Command {
+	desc, verb := "Copy a key, or every key under a prefix", "Copied"
+	if move {
+		desc, verb = "Rename a key, or every key under a prefix", "Moved"
+	}
+	return &gcli.Command{
+		Name: name,
+		Desc: desc,
+		Help: `pb ` + name + ` app/v1/* app/v2/ renames the prefix of every key under app/v1/.
+Values and metadata are copied as stored, on the server where the driver
+allows, in one transaction on SQL drivers. An existing destination is
+overwritten; its old value is kept in pb history.
+
+Unlike pb export and pb mirror, there is no --concurrency or --rate: the
+keys are copied one after the other in that transaction, so that readers
+see all of a prefix moved or none of it, and throttling would only hold
+its locks for longer.`,
+		Config: func(c *gcli.Command) {
+			c.AddArg("from", "The key, or prefix followed by *, to "+name, true)
+			c.AddArg("to", "The new key, or new prefix", true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			n, err := copyKeys(c.Arg("from").String(), c.Arg("to").String(), move)
+			if err != nil {
+				return err
+			}
+			if strings.HasSuffix(c.Arg("from").String(), "*") {
+				fmt.Printf("%s %d keys\n", verb, n)
+			}
+			return nil
+		},
+	}
+}
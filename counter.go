@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// counterAttempts bounds the retries of pb incr when other writers keep
+// changing the counter between its read and its write.
+const counterAttempts = 50
+
+// addToCounter adds delta to the integer value of key, which is created
+// at 0 if it does not exist, and returns the new value. The write is
+// conditional on the value read, so concurrent increments are never lost.
+func addToCounter(key string, delta int64) (int64, error) {
+	for range counterAttempts {
+		stored, err := store.Get(ctx, namespace+key)
+		var current []byte
+		var n int64
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return 0, err
+		default:
+			if current, err = decryptValue(stored); err != nil {
+				return 0, err
+			}
+			if n, err = strconv.ParseInt(strings.TrimSpace(string(current)), 10, 64); err != nil {
+				return 0, fmt.Errorf("%s is not an integer: %q", key, current)
+			}
+		}
+		next := n + delta
+		if (delta > 0 && next < n) || (delta < 0 && next > n) {
+			return 0, fmt.Errorf("%s would overflow", key)
+		}
+		err = putKeyValueIf(key, []byte(strconv.FormatInt(next, 10)), current, 0)
+		if !errors.Is(err, errConflict) {
+			return next, err
+		}
+	}
+	return 0, fmt.Errorf("%s changed %d times while being updated: %w", key, counterAttempts, errConflict)
+}
+
+func counterCommand(name string, sign int64) *gcli.Command {
+	var by int64
+	desc := "Increment an integer key atomically and print its new value"
+	if sign < 0 {
+		desc = "Decrement an integer key atomically and print its new value"
+	}
+	return &gcli.Command{
+		Name: name,
+		Desc: desc,
+		Help: `A missing key counts from 0:
+
+  BUILD=$(pb incr builds/web)`,
+		Config: func(c *gcli.Command) {
+			c.Int64Opt(&by, "by", "b", 1, "How much to change the value by")
+			c.AddArg("key", "The key of the counter", true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			n, err := addToCounter(c.Arg("key").String(), sign*by)
+			if err != nil {
+				return err
+			}
+			fmt.Println(n)
+			return nil
+		},
+	}
+}
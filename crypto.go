@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// encryptedMagic prefixes encrypted values so plaintext rows written before
+// encryption was turned on can still be read.
+var encryptedMagic = []byte("pb:aesgcm:")
+
+// aead is set by setupEncryption when the config has an Encryption section
+// or POSTBOARD_KEY is set.
+var aead cipher.AEAD
+
+func setupEncryption(cfg *Config) error {
+	var key []byte
+	var err error
+	if s := os.Getenv("POSTBOARD_KEY"); s != "" {
+		if key, err = parseKey(s); err != nil {
+			return fmt.Errorf("POSTBOARD_KEY %w", err)
+		}
+	} else if cfg.Encryption != nil && cfg.Encryption.KeyFile != "" {
+		if key, err = readKeyFile(cfg.Encryption.KeyFile); err != nil {
+			return err
+		}
+	}
+	if cfg.Encryption != nil {
+		identityFile = cfg.Encryption.IdentityFile
+	}
+	if key == nil {
+		return nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err = cipher.NewGCM(block)
+	return err
+}
+
+// encryptValue compresses value if configured, and then seals it.
+func encryptValue(value []byte) ([]byte, error) {
+	value, err := compressValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return sealValue(value)
+}
+
+// decryptValue opens a value written by encryptValue and passes anything
+// else through unchanged.
+func decryptValue(value []byte) ([]byte, error) {
+	value, err := openValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return decompressValue(value)
+}
+
+// sealValue seals value to the recipients of pb set -r, or with a random
+// nonce if encryption is on.
+func sealValue(value []byte) ([]byte, error) {
+	if recipients != nil {
+		return recipients.encrypt(value)
+	}
+	if aead == nil {
+		return value, nil
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte{}, encryptedMagic...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, value, nil), nil
+}
+
+// openValue opens a value written by sealValue and passes anything else
+// through unchanged.
+func openValue(value []byte) ([]byte, error) {
+	if plain, ok, err := decryptForRecipient(value); ok {
+		return plain, err
+	}
+	if !bytes.HasPrefix(value, encryptedMagic) {
+		return value, nil
+	}
+	if aead == nil {
+		return nil, errors.New("value is encrypted, set Encryption.KeyFile in the config or POSTBOARD_KEY to read it")
+	}
+	sealed := value[len(encryptedMagic):]
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted value is truncated")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("cannot decrypt value, wrong encryption key?")
+	}
+	return plain, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import "strings"
+
+// sqlDriver is the Driver of the current config, for the few statements
+// that are written differently for sqlite and postgres. Everything else is
+// plain SQL with ? placeholders, which postgres connections rewrite.
+var sqlDriver = "mysql"
+
+// onConflict ends an INSERT so that it updates the row with the same
+// primary key, whose columns sqlite and postgres need spelled out. set
+// refers to the inserted values with inserted.
+func onConflict(primaryKey, set string) string {
+	if sqlDriver != "mysql" {
+		return " ON CONFLICT (" + primaryKey + ") DO UPDATE SET " + set
+	}
+	return " ON DUPLICATE KEY UPDATE " + set
+}
+
+// ignoreConflict ends an INSERT so that it does nothing, affecting no rows,
+// if a row with the same primary key exists.
+func ignoreConflict(primaryKey string) string {
+	if sqlDriver != "mysql" {
+		return " ON CONFLICT (" + primaryKey + ") DO NOTHING"
+	}
+	return " ON DUPLICATE KEY UPDATE " + primaryKey + " = " + primaryKey
+}
+
+// concat appends b to the bytes of a.
+func concat(a, b string) string {
+	switch sqlDriver {
+	case "mysql":
+		return "CONCAT(" + a + ", " + b + ")"
+	case "sqlite":
+		// || makes text of blobs
+		return "CAST(" + a + " || " + b + " AS BLOB)"
+	}
+	return a + " || " + b
+}
+
+// inserted refers to the value of column being inserted by an upsert.
+func inserted(column string) string {
+	if sqlDriver != "mysql" {
+		return "excluded." + column
+	}
+	return "VALUES(" + column + ")"
+}
+
+// currentTimestamp is the time with microseconds in the format of
+// updated_at.
+func currentTimestamp() string {
+	if sqlDriver == "sqlite" {
+		return "strftime('%Y-%m-%d %H:%M:%f', 'now')"
+	}
+	return "CURRENT_TIMESTAMP(6)"
+}
+
+// forUpdate ends a SELECT so that it locks the rows it reads until the
+// end of the transaction. sqlite has no row locks: a write transaction
+// holds the whole database.
+func forUpdate() string {
+	if sqlDriver == "sqlite" {
+		return ""
+	}
+	return " FOR UPDATE"
+}
+
+// castInt converts a text column holding a number to an integer.
+func castInt(column string) string {
+	if sqlDriver == "postgres" {
+		return "CAST(" + column + " AS BIGINT)"
+	}
+	return "CAST(" + column + " AS UNSIGNED)"
+}
+
+// afterNow is the time a number of microseconds, given as a parameter,
+// from now, in the format of updated_at.
+func afterNow() string {
+	switch sqlDriver {
+	case "sqlite":
+		return "strftime('%Y-%m-%d %H:%M:%f', 'now', (? / 1000000.0) || ' seconds')"
+	case "postgres":
+		return "CURRENT_TIMESTAMP(6) + ? * INTERVAL '1 microsecond'"
+	}
+	return "CURRENT_TIMESTAMP(6) + INTERVAL ? MICROSECOND"
+}
+
+// hasPrefix is the condition on column for the keys starting with a
+// prefix, given as a parameter made by likePrefix.
+func hasPrefix(column string) string {
+	return column + " LIKE ? ESCAPE '!'"
+}
+
+// likePrefix is the pattern of hasPrefix for the keys starting with
+// prefix, in which % and _ stand for themselves.
+func likePrefix(prefix string) string {
+	return likeEscaper.Replace(prefix) + "%"
+}
+
+// likeEscaper quotes the characters LIKE treats as patterns. It quotes
+// with ! rather than \, which MySQL string literals would need doubled.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// notExpired is the condition on rows of kvTable that have no expiry or
+// have not reached it yet.
+func notExpired() string {
+	return "(expires_at IS NULL OR expires_at > " + currentTimestamp() + ")"
+}
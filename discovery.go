@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// discoveryNet is the network name pb registers with the mysql driver for
+// configs with Discovery set. Its dialer resolves the address on every new
+// connection, so when the database fails over, connections opened after
+// the failed ones go to the new primary.
+const discoveryNet = "pb-discovery"
+
+// discoverySchemes are the prefixes accepted in Config.Discovery.
+var discoverySchemes = []string{"srv:", "consul:"}
+
+// setupDiscovery makes dsn dial the addresses found through discovery
+// instead of its own address.
+func setupDiscovery(dsn *mysql.Config, discovery string) {
+	scheme, name, _ := strings.Cut(discovery, ":")
+	mysql.RegisterDialContext(discoveryNet, func(ctx context.Context, _ string) (net.Conn, error) {
+		addrs, err := discover(ctx, scheme, name)
+		if err != nil {
+			return nil, fmt.Errorf("discover %s: %w", discovery, err)
+		}
+		var errs []error
+		var d net.Dialer
+		for _, addr := range addrs {
+			conn, err := d.DialContext(ctx, "tcp", addr)
+			if err == nil {
+				return conn, nil
+			}
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	})
+	dsn.Net = discoveryNet
+	dsn.Addr = name
+	if dsn.TLS != nil && !dsn.TLS.InsecureSkipVerify && scheme == "srv" {
+		// verify against the domain the record is published under, e.g.
+		// db.example.com for _mysql._tcp.db.example.com
+		labels := strings.Split(name, ".")
+		for len(labels) > 1 && strings.HasPrefix(labels[0], "_") {
+			labels = labels[1:]
+		}
+		dsn.TLS.ServerName = strings.Join(labels, ".")
+	}
+}
+
+// discover returns the host:port addresses to try, best first.
+func discover(ctx context.Context, scheme, name string) ([]string, error) {
+	switch scheme {
+	case "srv":
+		// LookupSRV sorts by priority and randomizes by weight
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		var addrs []string
+		for _, r := range records {
+			addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port))))
+		}
+		return addrs, nil
+	case "consul":
+		return consulService(ctx, name)
+	}
+	return nil, fmt.Errorf("unknown discovery scheme %q", scheme)
+}
+
+// consulService returns the addresses of the healthy instances of a Consul
+// service, using the agent at CONSUL_HTTP_ADDR.
+func consulService(ctx context.Context, name string) ([]string, error) {
+	agent := os.Getenv("CONSUL_HTTP_ADDR")
+	if agent == "" {
+		agent = "http://127.0.0.1:8500"
+	} else if !strings.Contains(agent, "://") {
+		agent = "http://" + agent
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", agent+"/v1/health/service/"+url.PathEscape(name)+"?passing=true", nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	var entries []struct {
+		Node struct {
+			Address string `json:"Address"`
+		} `json:"Node"`
+		Service struct {
+			Address string `json:"Address"`
+			Port    int    `json:"Port"`
+		} `json:"Service"`
+	}
+	if err := doJSON(req, &entries); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no healthy instances of %s", name)
+	}
+	addrs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(e.Service.Port)))
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
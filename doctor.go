@@ -0,0 +1,395 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gookit/gcli/v3"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// maxClockSkew is how far the local clock may drift from the database
+// before doctor complains. Timestamps are assigned by the server, so skew
+// mostly confuses humans comparing created_at with local time.
+const maxClockSkew = 5 * time.Second
+
+// doctor collects the outcome of each check so a run can report every
+// problem at once instead of stopping at the first one.
+type doctor struct {
+	failed bool
+}
+
+func (d *doctor) ok(format string, args ...any) {
+	fmt.Printf("[ OK ] %s\n", fmt.Sprintf(format, args...))
+}
+
+func (d *doctor) warn(fix string, format string, args ...any) {
+	fmt.Printf("[WARN] %s\n", fmt.Sprintf(format, args...))
+	if fix != "" {
+		fmt.Printf("       fix: %s\n", fix)
+	}
+}
+
+func (d *doctor) fail(fix string, format string, args ...any) {
+	d.failed = true
+	fmt.Printf("[FAIL] %s\n", fmt.Sprintf(format, args...))
+	if fix != "" {
+		fmt.Printf("       fix: %s\n", fix)
+	}
+}
+
+// checkConfig validates the config file without prompting for a new one.
+func (d *doctor) checkConfig(path string) *Config {
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) && os.Getenv("POSTBOARD_DSN") != "" {
+		config := &Config{}
+		if err := config.validate(); err != nil {
+			d.fail("fix the connection string in POSTBOARD_DSN", "%v", err)
+			return nil
+		}
+		d.ok("no config file, using POSTBOARD_DSN")
+		return config.withDefaults()
+	}
+	if os.IsNotExist(err) {
+		d.fail("run `pb config` to create it", "config file %s does not exist", path)
+		return nil
+	}
+	if err != nil {
+		d.fail("check the path in POSTBOARD_CONFIG", "cannot stat config file %s: %v", path, err)
+		return nil
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		d.warn(fmt.Sprintf("chmod 600 %s", path),
+			"config file %s is accessible by other users (mode %04o) but contains credentials", path, fi.Mode().Perm())
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		d.fail(fmt.Sprintf("make %s readable by the current user", path), "cannot open config file: %v", err)
+		return nil
+	}
+	defer f.Close()
+	config, err := decodeConfig(f)
+	if err != nil {
+		var fieldErr *fieldError
+		if errors.As(err, &fieldErr) {
+			d.fail("edit the field or run `pb config` to rewrite the file", "config file %s: %v", path, err)
+		} else {
+			d.fail("fix the JSON syntax or run `pb config` to rewrite it", "config file %s is not valid: %v", path, err)
+		}
+		return nil
+	}
+	d.ok("config file %s is valid", path)
+	cfg := config.withDefaults()
+	if cfg.Profile != "" {
+		d.ok("using profile %s", cfg.Profile)
+	}
+	if cfg.Keychain {
+		if cfg.DSN, err = keychainDSN(cfg); err != nil {
+			d.fail("run `pb config --keychain` to save the password again", "cannot read the password of the DSN from the keychain: %v", err)
+			return nil
+		}
+		d.ok("read the password of the DSN from the keychain")
+	}
+	return cfg
+}
+
+// checkNetwork resolves the database host and opens a plain TCP connection
+// to it, which separates DNS and firewall problems from MySQL ones.
+func (d *doctor) checkNetwork(dsn *mysql.Config) bool {
+	if dsn.Net != "tcp" {
+		d.ok("using %s transport to %s, skipping DNS and TCP checks", dsn.Net, dsn.Addr)
+		return true
+	}
+	host, _, err := net.SplitHostPort(dsn.Addr)
+	if err != nil {
+		d.fail("use host:port in the DSN, e.g. tcp(db.example.com:4000)", "invalid address %q: %v", dsn.Addr, err)
+		return false
+	}
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		d.fail("check the host name in the DSN and your DNS settings", "cannot resolve %s: %v", host, err)
+		return false
+	}
+	d.ok("%s resolves to %v", host, addrs)
+
+	conn, err := net.DialTimeout("tcp", dsn.Addr, 5*time.Second)
+	if err != nil {
+		d.fail("check the port, firewall rules and IP allowlists of the database", "cannot connect to %s: %v", dsn.Addr, err)
+		return false
+	}
+	conn.Close()
+	d.ok("TCP connection to %s succeeded", dsn.Addr)
+	return true
+}
+
+// checkAuth logs in and verifies the session is encrypted when TLS was
+// requested. MySQL negotiates TLS inside its own protocol, so this is only
+// observable after the handshake.
+func (d *doctor) checkAuth(dsn *mysql.Config, conn *sql.DB) bool {
+	if err := conn.PingContext(ctx); err != nil {
+		var myErr *mysql.MySQLError
+		switch {
+		case errors.As(err, &myErr) && myErr.Number == 1045:
+			d.fail("check the user name and password in the DSN", "authentication failed: %v", err)
+		case errors.As(err, &myErr) && myErr.Number == 1049:
+			d.fail(fmt.Sprintf("create it with `CREATE DATABASE %s`", dsn.DBName), "database %q does not exist", dsn.DBName)
+		case dsn.TLSConfig == "" && dsn.TLS == nil:
+			d.fail("if the server requires TLS, add tls=true to the DSN", "cannot log in: %v", err)
+		default:
+			d.fail("", "cannot log in: %v", err)
+		}
+		return false
+	}
+	d.ok("logged in as %s", dsn.User)
+
+	var name, cipher string
+	err := conn.QueryRowContext(ctx, "SHOW STATUS LIKE 'Ssl_cipher'").Scan(&name, &cipher)
+	switch {
+	case err != nil:
+		d.warn("", "cannot determine whether the connection is encrypted: %v", err)
+	case cipher != "":
+		d.ok("connection is encrypted (%s)", cipher)
+	case dsn.TLSConfig != "" && dsn.TLSConfig != "false":
+		d.fail("check the tls parameter in the DSN", "tls=%s requested but the connection is not encrypted", dsn.TLSConfig)
+	default:
+		d.warn("add tls=true to the DSN if the database is reachable over an untrusted network", "connection is not encrypted")
+	}
+	return true
+}
+
+func (d *doctor) checkSchema() {
+	current, err := currentSchemaVersion()
+	if err != nil {
+		var myErr *mysql.MySQLError
+		var pgErr *pgconn.PgError
+		if errors.As(err, &myErr) && myErr.Number == 1146 || errors.As(err, &pgErr) && pgErr.Code == "42P01" {
+			d.warn("run any pb command such as `pb get foo` to create the tables", "board has not been initialized yet")
+			return
+		}
+		d.fail("", "cannot read schema version: %v", err)
+		return
+	}
+	switch {
+	case current < schemaVersion():
+		d.warn("run any pb command such as `pb get foo` to migrate it", "schema version is %d, this pb expects %d", current, schemaVersion())
+	case current > schemaVersion():
+		d.fail("upgrade pb on this machine", "schema version is %d, newer than the %d this pb understands", current, schemaVersion())
+	default:
+		d.ok("schema version %d is up to date", current)
+	}
+}
+
+func (d *doctor) checkCharset() {
+	var collation string
+	err := db.QueryRowContext(ctx, `SELECT TABLE_COLLATION FROM information_schema.TABLES
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?`, kvTable).Scan(&collation)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		d.warn("", "cannot read the collation of %s: %v", kvTable, err)
+		return
+	}
+	if !strings.HasPrefix(collation, "utf8mb4") {
+		d.warn("run any pb command such as `pb get foo` to migrate it", "table %s uses %s, keys with emoji may be mangled", kvTable, collation)
+		return
+	}
+	d.ok("table %s uses %s", kvTable, collation)
+}
+
+func (d *doctor) checkClock(conn *sql.DB) {
+	var raw string
+	if err := conn.QueryRowContext(ctx, "SELECT UTC_TIMESTAMP(6)").Scan(&raw); err != nil {
+		d.warn("", "cannot read database time: %v", err)
+		return
+	}
+	dbNow, err := time.Parse("2006-01-02 15:04:05.999999", raw)
+	if err != nil {
+		d.warn("", "cannot parse database time %q: %v", raw, err)
+		return
+	}
+	skew := time.Since(dbNow)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxClockSkew {
+		d.warn("enable NTP on this machine or the database host", "clock skew with the database is %s", skew.Round(time.Millisecond))
+		return
+	}
+	d.ok("clock skew with the database is %s", skew.Round(time.Millisecond))
+}
+
+// checkSQLite opens the database file of the sqlite driver, which has no
+// network, login or clock to check.
+func (d *doctor) checkSQLite(config *Config) {
+	kvTable, namespace, sqlDriver = config.Table, config.Namespace, config.Driver
+	path := sqlitePath(config)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		d.warn("run any pb command such as `pb get foo` to create it", "database file %s does not exist yet", path)
+		return
+	}
+	var err error
+	if db, err = openDatabase(config); err == nil {
+		err = db.PingContext(ctx)
+	}
+	if err != nil {
+		d.fail(fmt.Sprintf("check the permissions of %s", path), "cannot open database file: %v", err)
+		return
+	}
+	defer db.Close()
+	d.ok("opened database file %s", path)
+	d.checkSchema()
+}
+
+// checkPostgres logs in to a postgres server. The network checks are
+// left to the error pgx reports, which names the failing step.
+func (d *doctor) checkPostgres(config *Config) {
+	kvTable, namespace, sqlDriver = config.Table, config.Namespace, config.Driver
+	config.ConnectTimeout.Duration = 5 * time.Second
+	var err error
+	if db, err = openDatabase(config); err == nil {
+		err = db.PingContext(ctx)
+	}
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.As(err, &pgErr) && pgErr.Code == "3D000":
+		d.fail("create it with `CREATE DATABASE`", "database does not exist: %v", err)
+		return
+	case err != nil:
+		d.fail("check the host, user and password in the DSN", "cannot connect: %v", err)
+		return
+	}
+	defer db.Close()
+	var version string
+	if err := db.QueryRowContext(ctx, "SHOW server_version").Scan(&version); err == nil {
+		d.ok("connected to PostgreSQL %s", version)
+	}
+	var encoding string
+	if err := db.QueryRowContext(ctx, "SHOW server_encoding").Scan(&encoding); err == nil {
+		if encoding != "UTF8" {
+			d.warn("create the database with ENCODING 'UTF8'", "database encoding is %s, keys with emoji may be rejected", encoding)
+		} else {
+			d.ok("database encoding is %s", encoding)
+		}
+	}
+	d.checkSchema()
+}
+
+// checkTiKV connects to PD and reads a key, which also reaches a TiKV
+// node. There is no schema to check.
+func (d *doctor) checkTiKV(config *Config) {
+	kvTable, namespace = config.Table, config.Namespace
+	config.ConnectTimeout.Duration = 5 * time.Second
+	s, err := newTiKVStore(config)
+	if err != nil {
+		d.fail("check the PD addresses in the DSN and that PD is reachable", "cannot connect to PD: %v", err)
+		return
+	}
+	defer s.Close()
+	d.ok("connected to PD, cluster ID %d", s.ClusterID())
+	if _, err := s.Get(ctx, namespace+"doctor"); err != nil && err != sql.ErrNoRows {
+		d.fail("check that TiKV is running and reachable from here", "cannot read from TiKV: %v", err)
+		return
+	}
+	d.ok("read from TiKV succeeded")
+}
+
+// checkRedis pings the server. There is no schema to check.
+func (d *doctor) checkRedis(config *Config) {
+	config.ConnectTimeout.Duration = 5 * time.Second
+	s, err := newRedisStore(config)
+	if err == nil {
+		defer s.client.Close()
+		err = s.client.Ping(ctx).Err()
+	}
+	if err != nil {
+		d.fail("check the address and password in the DSN", "cannot connect to redis: %v", err)
+		return
+	}
+	d.ok("connected to redis at %s", s.client.Options().Addr)
+}
+
+// checkS3 lists the prefix, which needs both credentials and access to
+// the bucket.
+func (d *doctor) checkS3(config *Config) {
+	namespace = config.Namespace
+	s, err := newS3Store(config)
+	if err != nil {
+		d.fail("configure AWS credentials, e.g. with `aws configure`", "cannot set up the S3 client: %v", err)
+		return
+	}
+	if _, err := s.List(ctx, namespace, "", 1); err != nil {
+		d.fail("check the bucket name, region and the s3:ListBucket permission", "cannot list s3://%s/%s: %v", s.bucket, s.prefix, err)
+		return
+	}
+	d.ok("can list s3://%s/%s", s.bucket, s.prefix)
+}
+
+func (d *doctor) run(path string) {
+	config := d.checkConfig(path)
+	if config == nil {
+		return
+	}
+	switch config.Driver {
+	case "sqlite":
+		d.checkSQLite(config)
+		return
+	case "postgres":
+		d.checkPostgres(config)
+		return
+	case "tikv":
+		d.checkTiKV(config)
+		return
+	case "redis":
+		d.checkRedis(config)
+		return
+	case "s3":
+		d.checkS3(config)
+		return
+	}
+	dsn, err := mysql.ParseDSN(config.DSN)
+	if err != nil {
+		d.fail("see https://github.com/go-sql-driver/mysql#dsn-data-source-name for the format", "invalid DSN: %v", err)
+		return
+	}
+	if !d.checkNetwork(dsn) {
+		return
+	}
+	kvTable, namespace = config.Table, config.Namespace
+	config.ConnectTimeout.Duration = 5 * time.Second
+	db, err = openDatabase(config)
+	if err != nil {
+		d.fail("", "invalid DSN: %v", err)
+		return
+	}
+	defer db.Close()
+	if !d.checkAuth(dsn, db) {
+		return
+	}
+	d.checkSchema()
+	d.checkCharset()
+	d.checkClock(db)
+}
+
+func doctorCommand() *gcli.Command {
+	return &gcli.Command{
+		Name: "doctor",
+		Desc: "Diagnose configuration and connectivity problems",
+		Func: func(c *gcli.Command, args []string) error {
+			d := &doctor{}
+			d.run(configFilePath)
+			if d.failed {
+				return fmt.Errorf("some checks failed")
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// dopplerAPI is the API host; DOPPLER_API_HOST overrides it as it does for
+// the Doppler CLI.
+func dopplerAPI() string {
+	if host := os.Getenv("DOPPLER_API_HOST"); host != "" {
+		return strings.TrimSuffix(host, "/")
+	}
+	return "https://api.doppler.com"
+}
+
+// dopplerSecrets fetches the secrets of one config of a Doppler project.
+// Secrets whose visibility is "unmasked" are not marked secret.
+func dopplerSecrets(token, project, config string) ([]pulledSecret, error) {
+	var resp struct {
+		Secrets map[string]struct {
+			Computed           string `json:"computed"`
+			ComputedVisibility string `json:"computedVisibility"`
+			Note               string `json:"note"`
+		} `json:"secrets"`
+	}
+	params := url.Values{}
+	if project != "" {
+		params.Set("project", project)
+		params.Set("config", config)
+	}
+	u := dopplerAPI() + "/v3/configs/config/secrets?" + params.Encode()
+	if err := fetchJSON("GET", u, token, nil, &resp); err != nil {
+		return nil, err
+	}
+	var secrets []pulledSecret
+	for _, name := range slices.Sorted(maps.Keys(resp.Secrets)) {
+		s := resp.Secrets[name]
+		// Doppler adds DOPPLER_PROJECT, DOPPLER_CONFIG and DOPPLER_ENVIRONMENT
+		if name == "DOPPLER_PROJECT" || name == "DOPPLER_CONFIG" || name == "DOPPLER_ENVIRONMENT" {
+			continue
+		}
+		secrets = append(secrets, pulledSecret{
+			Name:   name,
+			Value:  s.Computed,
+			Secret: s.ComputedVisibility != "unmasked",
+			Note:   s.Note,
+		})
+	}
+	return secrets, nil
+}
+
+func dopplerCommand() *gcli.Command {
+	var project, config, prefix string
+	var dryRun bool
+	return &gcli.Command{
+		Name: "doppler",
+		Desc: "Import secrets from Doppler",
+		Help: `Authenticates with the service or personal token in DOPPLER_TOKEN.`,
+		Subs: []*gcli.Command{
+			{
+				Name: "pull",
+				Desc: "Store the secrets of a Doppler config under a prefix",
+				Config: func(c *gcli.Command) {
+					c.StrOpt(&project, "project", "", os.Getenv("DOPPLER_PROJECT"), "The Doppler project (default $DOPPLER_PROJECT)")
+					c.StrOpt(&config, "config", "c", os.Getenv("DOPPLER_CONFIG"), "The config, e.g. prd (default $DOPPLER_CONFIG)")
+					c.StrOpt(&prefix, "prefix", "p", "", "The key prefix to store the secrets under, e.g. app/")
+					c.BoolOpt(&dryRun, "dry-run", "n", false, "Print what would be done without changing anything")
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					token := os.Getenv("DOPPLER_TOKEN")
+					if token == "" {
+						return fmt.Errorf("set DOPPLER_TOKEN to a Doppler service or personal token")
+					}
+					// service tokens are scoped to one config and need neither
+					if (project == "") != (config == "") {
+						return fmt.Errorf("--project and --config go together")
+					}
+					secrets, err := dopplerSecrets(token, project, config)
+					if err != nil {
+						return err
+					}
+					return storeSecrets(prefix, secrets, dryRun, false)
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// commonDotfiles are tracked by `pb dotfiles init` when they exist.
+var commonDotfiles = []string{
+	".bashrc", ".bash_profile", ".profile", ".zshrc", ".gitconfig",
+	".vimrc", ".tmux.conf", ".inputrc", ".editorconfig", ".ssh/config",
+}
+
+// dotfilesPrefix is where the dotfiles of name are stored.
+func dotfilesPrefix(name string) (string, error) {
+	if name == "" {
+		u, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		name = u.Username
+	}
+	return "dotfiles/" + name + "/", nil
+}
+
+// homeRel returns path relative to the home directory, which is how
+// dotfiles are keyed so they can be applied under a different $HOME.
+func homeRel(home, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(home, abs)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s is not inside your home directory %s", path, home)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// trackDotfile uploads a file and records where it goes and its mode.
+func trackDotfile(prefix, home, path string) error {
+	rel, err := homeRel(home, path)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", path)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := putKeyValue(ctx, prefix+rel, b); err != nil {
+		return err
+	}
+	err = setMeta(ctx, prefix+rel, map[string]string{
+		"target": "~/" + rel,
+		"mode":   fmt.Sprintf("%04o", fi.Mode().Perm()),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("tracked ~/%s\n", rel)
+	return nil
+}
+
+// applyDotfile writes one stored dotfile to its target. Files that exist
+// with other contents are only replaced with force, after saving a backup.
+func applyDotfile(key, home string, force, dryRun bool) error {
+	meta, err := getMeta(ctx, key)
+	if err != nil {
+		return err
+	}
+	target := meta["target"]
+	if !strings.HasPrefix(target, "~/") {
+		return fmt.Errorf("%s has no target recorded, track it again", key)
+	}
+	path := filepath.Join(home, filepath.FromSlash(target[2:]))
+	mode := os.FileMode(0644)
+	if m, err := strconv.ParseUint(meta["mode"], 8, 32); err == nil {
+		mode = os.FileMode(m)
+	}
+	value, err := getKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	current, err := os.ReadFile(path)
+	switch {
+	case err == nil && bytes.Equal(current, value):
+		return nil
+	case err == nil && !force:
+		fmt.Printf("skipped %s: differs from the board, use --force to replace it\n", path)
+		return nil
+	case err != nil && !os.IsNotExist(err):
+		return err
+	}
+	if dryRun {
+		fmt.Printf("would write %s (%04o)\n", path, mode)
+		return nil
+	}
+	if err == nil {
+		if err := os.WriteFile(path+".pb-backup", current, mode); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, value, mode); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file
+	if err := os.Chmod(path, mode); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s (%04o)\n", path, mode)
+	return nil
+}
+
+func dotfilesCommand() *gcli.Command {
+	var name string
+	var force, dryRun bool
+	nameOpt := func(c *gcli.Command) {
+		c.StrOpt(&name, "user", "u", "", "Whose dotfiles to use (default the current user)")
+	}
+	return &gcli.Command{
+		Name: "dotfiles",
+		Desc: "Keep dotfiles on the board and apply them on other machines",
+		Subs: []*gcli.Command{
+			{
+				Name: "init",
+				Desc: "Start tracking the common dotfiles found in your home directory",
+				Config: func(c *gcli.Command) {
+					nameOpt(c)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					home, err := os.UserHomeDir()
+					if err != nil {
+						return err
+					}
+					prefix, err := dotfilesPrefix(name)
+					if err != nil {
+						return err
+					}
+					if err := connect(); err != nil {
+						return err
+					}
+					found := 0
+					for _, rel := range commonDotfiles {
+						path := filepath.Join(home, filepath.FromSlash(rel))
+						if _, err := os.Stat(path); err != nil {
+							continue
+						}
+						if err := trackDotfile(prefix, home, path); err != nil {
+							return err
+						}
+						found++
+					}
+					if found == 0 {
+						fmt.Println("no common dotfiles found, add files with `pb dotfiles track`")
+					}
+					return nil
+				},
+			},
+			{
+				Name: "track",
+				Desc: "Upload dotfiles, or re-upload all tracked ones if no file is given",
+				Config: func(c *gcli.Command) {
+					nameOpt(c)
+					c.AddArg("files", "The files to track", false, true)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					home, err := os.UserHomeDir()
+					if err != nil {
+						return err
+					}
+					prefix, err := dotfilesPrefix(name)
+					if err != nil {
+						return err
+					}
+					if err := connect(); err != nil {
+						return err
+					}
+					files := c.Arg("files").Array()
+					if len(files) == 0 {
+						keys, err := listKeysWithPrefix(ctx, prefix)
+						if err != nil {
+							return err
+						}
+						for _, key := range keys {
+							files = append(files, filepath.Join(home, filepath.FromSlash(strings.TrimPrefix(key, prefix))))
+						}
+					}
+					for _, path := range files {
+						if err := trackDotfile(prefix, home, path); err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			},
+			{
+				Name: "apply",
+				Desc: "Write the tracked dotfiles into your home directory",
+				Config: func(c *gcli.Command) {
+					nameOpt(c)
+					c.BoolOpt(&force, "force", "f", false, "Replace files that differ, keeping a .pb-backup copy")
+					c.BoolOpt(&dryRun, "dry-run", "n", false, "Print what would be written without writing")
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					home, err := os.UserHomeDir()
+					if err != nil {
+						return err
+					}
+					prefix, err := dotfilesPrefix(name)
+					if err != nil {
+						return err
+					}
+					if err := connect(); err != nil {
+						return err
+					}
+					dryRun = dryRun || dryRunWrites
+					keys, err := listKeysWithPrefix(ctx, prefix)
+					if err != nil {
+						return err
+					}
+					if len(keys) == 0 {
+						return fmt.Errorf("no dotfiles under %s", prefix)
+					}
+					for _, key := range keys {
+						if err := applyDotfile(key, home, force, dryRun); err == sql.ErrNoRows {
+							continue // deleted meanwhile
+						} else if err != nil {
+							return err
+						}
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strings"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// editorCommand is the editor pb edit runs, with its arguments: $VISUAL,
+// then $EDITOR, then the platform's default.
+func editorCommand() []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if args := strings.Fields(os.Getenv(name)); len(args) > 0 {
+			return args
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+// editKey opens the value of key in an editor and writes it back if it
+// changed. Unless force is set, the write fails with errConflict if the
+// key changed meanwhile, and the edited value is kept in its file.
+func editKey(key string, force bool) error {
+	if readOnly {
+		// rather than after editing
+		return errReadOnly
+	}
+	original, err := getKey(ctx, key)
+	exists := err == nil
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	if exists && original == nil {
+		// an empty value is not a missing one
+		original = []byte{}
+	}
+	// the extension of the key, if any, lets the editor highlight it
+	f, err := os.CreateTemp("", "pb-*"+path.Ext(key))
+	if err != nil {
+		return err
+	}
+	file := f.Name()
+	keep := false
+	defer func() {
+		if !keep {
+			os.Remove(file)
+		}
+	}()
+	if _, err := f.Write(original); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	args := editorCommand()
+	cmd := exec.CommandContext(ctx, args[0], append(args[1:], file)...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	edited, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, original) {
+		fmt.Fprintln(os.Stderr, "No changes")
+		return nil
+	}
+	if _, ok := store.(conditionalStore); force || !ok {
+		return putKeyValue(ctx, key, edited)
+	}
+	err = putKeyValueIf(key, edited, original, 0)
+	if errors.Is(err, errConflict) {
+		keep = true
+		return fmt.Errorf("%s changed while being edited, your version is in %s: %w", key, file, errConflict)
+	}
+	return err
+}
+
+func editCommand() *gcli.Command {
+	var force bool
+	return &gcli.Command{
+		Name: "edit",
+		Desc: "Edit the value of a key in $EDITOR",
+		Help: `The value is written back if it changed, unless someone else changed
+the key meanwhile: pb then exits with 6 and keeps the edited value in a
+file. A key that does not exist is created.`,
+		Config: func(c *gcli.Command) {
+			c.BoolOpt(&force, "force", "f", false, "Write the value back even if the key changed meanwhile")
+			c.AddArg("key", "The key to edit", true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			return editKey(c.Arg("key").String(), force)
+		},
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// envUnsafe matches the characters of a value that make it need quoting
+// in a shell or dotenv file.
+var envUnsafe = regexp.MustCompile(`[^A-Za-z0-9_./:@%+=,-]`)
+
+// envName turns a key into an environment variable name: upper case, with
+// anything but letters, digits and _ replaced by _.
+func envName(key string) string {
+	name := []byte(strings.ToUpper(key))
+	for i, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || (name[0] >= '0' && name[0] <= '9') {
+		return "_" + string(name)
+	}
+	return string(name)
+}
+
+// envQuote single-quotes value if it needs it, as both sh and dotenv
+// parsers read it.
+func envQuote(value string) string {
+	if value != "" && !envUnsafe.MatchString(value) {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+type envVar struct {
+	Name, Value string
+}
+
+// prefixEnv names the keys under prefix as environment variables, without
+// stripPrefix, sorted by key.
+func prefixEnv(prefix, stripPrefix string) ([]envVar, error) {
+	keys, err := listKeysWithPrefix(ctx, strings.TrimSuffix(prefix, "*"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(keys)
+	values, err := getKeys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]string{}
+	var vars []envVar
+	for _, key := range keys {
+		value, ok := values[key]
+		if !ok {
+			// deleted since it was listed
+			continue
+		}
+		name := envName(strings.TrimPrefix(key, stripPrefix))
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("%s and %s are both %s", other, key, name)
+		}
+		names[name] = key
+		vars = append(vars, envVar{name, string(value)})
+	}
+	return vars, nil
+}
+
+func envCommand() *gcli.Command {
+	var stripPrefix string
+	var export bool
+	return &gcli.Command{
+		Name: "env",
+		Desc: "Print the keys under a prefix as KEY=VALUE lines",
+		Help: `For dotenv files and deploy scripts:
+
+  eval "$(pb env --export --prefix-strip app/prod/ app/prod/)"
+
+app/prod/db-host becomes DB_HOST with --prefix-strip app/prod/, and
+APP_PROD_DB_HOST without. Values are single-quoted where the shell needs it,
+and left as they are with --format raw. With json or yaml, the variables
+are printed as one object of names to values.`,
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&stripPrefix, "prefix-strip", "", "", "Remove this prefix from keys before naming variables")
+			c.BoolOpt(&export, "export", "e", false, "Prefix every line with export")
+			formatOpt(c, "the variables", formatText)
+			c.AddArg("prefix", "The prefix of the keys to print", true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			vars, err := prefixEnv(c.Arg("prefix").String(), stripPrefix)
+			if err != nil {
+				return err
+			}
+			format := commandFormat(formatText)
+			switch format {
+			case formatJSON, formatYAML:
+				object := map[string]string{}
+				for _, v := range vars {
+					object[v.Name] = v.Value
+				}
+				return printStructured(format, object)
+			case formatTable:
+				rows := make([][]string, len(vars))
+				for i, v := range vars {
+					rows[i] = []string{v.Name, tableCell(v.Value, 60)}
+				}
+				return printTable([]string{"NAME", "VALUE"}, rows)
+			}
+			for _, v := range vars {
+				line := v.Name + "=" + envQuote(v.Value)
+				if format == formatRaw {
+					line = v.Name + "=" + v.Value
+				}
+				if export {
+					line = "export " + line
+				}
+				fmt.Println(line)
+			}
+			return nil
+		},
+	}
+}
+
+func execCommand() *gcli.Command {
+	var stripPrefix string
+	return &gcli.Command{
+		Name: "exec",
+		Desc: "Run a command with the keys under a prefix as environment variables",
+		Help: `  pb exec --prefix-strip app/prod/ app/prod/ -- ./server --port 8080
+
+Variables are named as by pb env and override those already set. Nothing
+is written to disk; pb exits with the command's exit code.`,
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&stripPrefix, "prefix-strip", "", "", "Remove this prefix from keys before naming variables")
+			c.AddArg("prefix", "The prefix of the keys to set", true)
+			c.AddArg("command", "The command to run and its arguments", true, true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			vars, err := prefixEnv(c.Arg("prefix").String(), stripPrefix)
+			if err != nil {
+				return err
+			}
+			argv := c.Arg("command").Strings()
+			if len(argv) > 0 && argv[0] == "--" {
+				argv = argv[1:]
+			}
+			if len(argv) == 0 {
+				return fmt.Errorf("no command to run")
+			}
+			cmd := exec.Command(argv[0], argv[1:]...)
+			cmd.Env = os.Environ()
+			for _, v := range vars {
+				cmd.Env = append(cmd.Env, v.Name+"="+v.Value)
+			}
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+			err = cmd.Run()
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				childExitCode = exitErr.ExitCode()
+				return nil
+			}
+			return err
+		},
+	}
+}
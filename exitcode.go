@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gookit/color"
+	"github.com/gookit/gcli/v3"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Exit codes, so scripts can tell a missing key from a broken setup
+// without parsing messages.
+const (
+	exitOK = 0
+	// exitFalse answers no to a question such as pb exists, as test(1)
+	// does.
+	exitFalse = 1
+	// exitFailure is any error not classified below; it is what pb has
+	// always exited with on errors.
+	exitFailure = 2
+	// exitNotFound means the key or other named object does not exist.
+	exitNotFound = 3
+	// exitConfig means the config file or a flag is invalid, or forbids
+	// what was asked, as ReadOnly and the grants of Token do.
+	exitConfig = 4
+	// exitUnavailable means the database could not be reached.
+	exitUnavailable = 5
+	// exitConflict means a conditional write did not happen because the
+	// key did not have the expected value.
+	exitConflict = 6
+	// exitInterrupted is the conventional exit code for a process stopped
+	// by SIGINT.
+	exitInterrupted = 130
+)
+
+// runErr is the error the command failed with, if any.
+var runErr error
+
+// recordRunError replaces gcli's default error hooks: it prints the error
+// the same way and keeps it for exitCodeFor. It is registered for both the
+// command and the app error events, as gcli wraps the command's error
+// before passing it to the latter; the first one seen is kept.
+func recordRunError(hc *gcli.HookCtx) bool {
+	if err, ok := hc.Get("err").(error); ok && runErr == nil {
+		runErr = err
+		if !errors.As(err, new(exitStatus)) {
+			color.Error.Tips(err.Error())
+		}
+	}
+	return true
+}
+
+// exitStatus is returned by a command to exit with a code and no message.
+type exitStatus int
+
+func (e exitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// exitCodeFor maps the error a command failed with to an exit code.
+func exitCodeFor(err error) int {
+	var fieldErr *fieldError
+	var netErr net.Error
+	var myErr *mysql.MySQLError
+	var pgErr *pgconn.PgError
+	var status exitStatus
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &status):
+		return int(status)
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.Is(err, sql.ErrNoRows):
+		return exitNotFound
+	case errors.Is(err, errConflict):
+		return exitConflict
+	case errors.As(err, &fieldErr), errors.Is(err, errReadOnly), errors.Is(err, errForbidden):
+		return exitConfig
+	case errors.As(err, &netErr), errors.Is(err, mysql.ErrInvalidConn):
+		return exitUnavailable
+	case errors.As(err, &myErr) && (myErr.Number == 1045 || myErr.Number == 1049):
+		// access denied or unknown database
+		return exitConfig
+	case errors.As(err, &pgErr) && (pgErr.Code == "28P01" || pgErr.Code == "3D000"):
+		// the same for postgres
+		return exitConfig
+	}
+	return exitFailure
+}
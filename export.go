@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gookit/gcli/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// cfKVBatch is the most pairs the Workers KV bulk API takes in one request.
+const cfKVBatch = 10000
+
+// selectKeys expands patterns as pb get does: a pattern ending in * selects
+// every key with that prefix, anything else the key itself. No patterns
+// select every key.
+func selectKeys(patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		patterns = []string{"*"}
+	}
+	var keys []string
+	for _, p := range patterns {
+		prefix, ok := strings.CutSuffix(p, "*")
+		if !ok {
+			keys = append(keys, p)
+			continue
+		}
+		matched, err := listKeysWithPrefix(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, matched...)
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys), nil
+}
+
+// exportFormats are the formats pb export writes dumps in.
+var exportFormats = []string{"json", "ndjson", "csv", "sh", "k8s-secret", "k8s-configmap"}
+
+// csvColumns are the columns pb export --format csv can write.
+var csvColumns = []string{"key", "value", "encoding", "size", "created_at", "updated_at", "updated_by", "expires_at"}
+
+// dumpEntry is a key in a dump made by pb export: its record, with the
+// value as text or base64, and its metadata.
+type dumpEntry struct {
+	record
+	Meta map[string]string `json:"meta,omitempty"`
+}
+
+// dumpKeys returns the entries of keys that exist, named without
+// stripPrefix.
+func dumpKeys(keys []string, stripPrefix string, bulk *bulkFlags) ([]dumpEntry, error) {
+	values, err := getKeys(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	records, err := keyRecords(keys, values)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]dumpEntry, len(records))
+	err = bulk.each(len(records), func(i int) error {
+		meta, err := getMeta(ctx, records[i].Key)
+		if err != nil {
+			return fmt.Errorf("%s: %w", records[i].Key, err)
+		}
+		if len(meta) == 0 {
+			meta = nil
+		}
+		entries[i] = dumpEntry{records[i], meta}
+		entries[i].Key = strings.TrimPrefix(entries[i].Key, stripPrefix)
+		return nil
+	})
+	return entries, err
+}
+
+// writeDump writes entries to w in format: json for one array, ndjson for
+// an object per line.
+func writeDump(w io.Writer, format string, entries []dumpEntry) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if format == "ndjson" {
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if entries == nil {
+		entries = []dumpEntry{}
+	}
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// writeCSV writes entries to w as CSV with a header row, one column per
+// name in columns. Times are RFC 3339, in UTC.
+func writeCSV(w io.Writer, entries []dumpEntry, columns []string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	csvTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	row := make([]string, len(columns))
+	for _, e := range entries {
+		for i, col := range columns {
+			switch col {
+			case "key":
+				row[i] = e.Key
+			case "value":
+				row[i] = e.Value
+			case "encoding":
+				row[i] = e.Encoding
+			case "size":
+				row[i] = strconv.Itoa(e.Size)
+			case "created_at":
+				row[i] = csvTime(e.CreatedAt)
+			case "updated_at":
+				row[i] = csvTime(e.UpdatedAt)
+			case "updated_by":
+				row[i] = e.UpdatedBy
+			case "expires_at":
+				row[i] = csvTime(e.ExpiresAt)
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeShell writes entries to w as a script of export lines, named as
+// by pb env, for sh and bash to source.
+func writeShell(w io.Writer, entries []dumpEntry) error {
+	// nothing is written unless every key can be
+	var script bytes.Buffer
+	names := map[string]string{}
+	for _, e := range entries {
+		value, err := entryValue(e)
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.Key, err)
+		}
+		if bytes.IndexByte(value, 0) >= 0 {
+			return fmt.Errorf("%s: the value has a NUL byte, which no shell variable can hold", e.Key)
+		}
+		name := envName(e.Key)
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("%s and %s are both %s", other, e.Key, name)
+		}
+		names[name] = e.Key
+		fmt.Fprintf(&script, "export %s=%s\n", name, envQuote(string(value)))
+	}
+	_, err := script.WriteTo(w)
+	return err
+}
+
+// k8sManifest is a Secret or ConfigMap as kubectl apply takes it.
+type k8sManifest struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace,omitempty"`
+	} `yaml:"metadata"`
+	Type       string            `yaml:"type,omitempty"`
+	Data       map[string]string `yaml:"data,omitempty"`
+	BinaryData map[string]string `yaml:"binaryData,omitempty"`
+}
+
+// k8sDataKey turns a key into a key of the data of a Secret or ConfigMap,
+// replacing anything but letters, digits, -, _ and . with _.
+func k8sDataKey(key string) string {
+	name := []byte(key)
+	for i, c := range name {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' && c != '_' && c != '.' {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+// writeK8s writes entries to w as a Secret, for format k8s-secret, or a
+// ConfigMap named name in the Kubernetes namespace k8sNamespace, if set.
+func writeK8s(w io.Writer, format string, entries []dumpEntry, name, k8sNamespace string) error {
+	m := k8sManifest{APIVersion: "v1", Kind: "ConfigMap", Data: map[string]string{}}
+	if format == "k8s-secret" {
+		m.Kind, m.Type = "Secret", "Opaque"
+	}
+	m.Metadata.Name, m.Metadata.Namespace = name, k8sNamespace
+	keys := map[string]string{}
+	for _, e := range entries {
+		value, err := entryValue(e)
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.Key, err)
+		}
+		dataKey := k8sDataKey(e.Key)
+		if other, ok := keys[dataKey]; ok {
+			return fmt.Errorf("%s and %s are both %s", other, e.Key, dataKey)
+		}
+		keys[dataKey] = e.Key
+		switch {
+		case m.Kind == "Secret":
+			m.Data[dataKey] = base64.StdEncoding.EncodeToString(value)
+		case e.Encoding != "":
+			if m.BinaryData == nil {
+				m.BinaryData = map[string]string{}
+			}
+			m.BinaryData[dataKey] = base64.StdEncoding.EncodeToString(value)
+		default:
+			m.Data[dataKey] = string(value)
+		}
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// writeOutput calls write with the file at path, created only readable by
+// the user, or with stdout if path is empty.
+func writeOutput(path string, write func(io.Writer) error) error {
+	if path == "" {
+		return write(os.Stdout)
+	}
+	// a failed export leaves the file as it was
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	// written next to path and renamed over it, so that readers of path
+	// never see half an export; CreateTemp makes it only readable by the
+	// user, as values are often credentials
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+type cfKVPair struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Base64 bool   `json:"base64"`
+}
+
+// exportCFKV writes keys to a Cloudflare Workers KV namespace with the bulk
+// API, named without stripPrefix.
+func exportCFKV(account, namespaceID, stripPrefix string, keys []string, bulk *bulkFlags, dryRun bool) error {
+	token := os.Getenv("CLOUDFLARE_API_TOKEN")
+	if token == "" && !dryRun {
+		return fmt.Errorf("set CLOUDFLARE_API_TOKEN to a token with Workers KV Storage edit permission")
+	}
+	pairs := make([]cfKVPair, len(keys))
+	err := bulk.each(len(keys), func(i int) error {
+		value, err := getKey(ctx, keys[i])
+		if err != nil {
+			return fmt.Errorf("%s: %w", keys[i], err)
+		}
+		// values may be binary, so always send them encoded
+		pairs[i] = cfKVPair{Key: strings.TrimPrefix(keys[i], stripPrefix), Value: base64.StdEncoding.EncodeToString(value), Base64: true}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, p := range pairs {
+		fmt.Printf("export %s -> %s\n", keys[i], p.Key)
+	}
+	if dryRun {
+		return nil
+	}
+	u := "https://api.cloudflare.com/client/v4/accounts/" + url.PathEscape(account) +
+		"/storage/kv/namespaces/" + url.PathEscape(namespaceID) + "/bulk"
+	for batch := range slices.Chunk(pairs, cfKVBatch) {
+		var resp struct {
+			Success bool `json:"success"`
+			Errors  []struct {
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		if err := fetchJSON("PUT", u, token, batch, &resp); err != nil {
+			return err
+		}
+		if !resp.Success {
+			return fmt.Errorf("cloudflare bulk write failed: %v", resp.Errors)
+		}
+	}
+	return nil
+}
+
+func exportCommand() *gcli.Command {
+	var cfKV, dryRun bool
+	var account, namespaceID, stripPrefix, prefix, output, name, k8sNamespace string
+	format, columns := "json", "key,value"
+	var bulk bulkFlags
+	return &gcli.Command{
+		Name: "export",
+		Desc: "Dump keys for backup or to load elsewhere, or copy them to another system",
+		Help: `Keys are selected as with pb get: key/prefix* patterns, all keys if none
+are given. By default they are dumped with their values, metadata and
+times, for pb import to load into this board or another:
+
+  pb export --prefix staging/ -o staging.json
+  pb export --format ndjson > board.ndjson
+
+With --format csv, --columns picks the columns for a spreadsheet, out of
+` + strings.Join(csvColumns, ", ") + `:
+
+  pb export --format csv --columns key,value,updated_at --prefix app/ -o app.csv
+
+With --format sh, keys are written as a script of export lines, named as
+by pb env, for init scripts to source:
+
+  pb export --format sh --prefix app/ -o /etc/app.env   # APP_DB_HOST='...'
+
+With --format k8s-secret or k8s-configmap, keys are written as a Secret or
+ConfigMap manifest for kubectl apply, named after the keys without
+--prefix, or --strip-prefix if given:
+
+  pb export --format k8s-secret --name app-secrets --prefix app/prod/ | kubectl apply -f -
+
+In JSON and CSV, values that are not UTF-8 text are base64-encoded, with
+base64 in their encoding field.
+
+With --cf-kv, keys are written to a Cloudflare Workers KV namespace
+instead, authenticating with CLOUDFLARE_API_TOKEN.`,
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&format, "format", "", format, "How to write the dump: "+strings.Join(exportFormats, ", "))
+			c.StrOpt(&output, "output", "o", "", "Write the dump to this file instead of stdout")
+			c.StrOpt(&columns, "columns", "", columns, "With --format csv, the columns to write, separated by commas")
+			c.StrOpt(&name, "name", "", "", "With --format k8s-secret or k8s-configmap, the name of the Secret or ConfigMap")
+			c.StrOpt(&k8sNamespace, "k8s-namespace", "", "", "With --format k8s-secret or k8s-configmap, the Kubernetes namespace to put it in")
+			c.StrOpt(&prefix, "prefix", "", "", "Export the keys starting with this, as does a prefix* pattern")
+			c.BoolOpt(&cfKV, "cf-kv", "", false, "Write to a Cloudflare Workers KV namespace")
+			c.StrOpt(&account, "account-id", "", os.Getenv("CLOUDFLARE_ACCOUNT_ID"), "The Cloudflare account (default $CLOUDFLARE_ACCOUNT_ID)")
+			c.StrOpt(&namespaceID, "namespace-id", "", "", "The Workers KV namespace id")
+			c.StrOpt(&stripPrefix, "strip-prefix", "", "", "Remove this prefix from the exported key names")
+			c.BoolOpt(&dryRun, "dry-run", "n", false, "With --cf-kv, print what would be done without changing anything")
+			bulk.register(c)
+			c.AddArg("keys", "Keys or prefix* patterns to export", false, true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := bulk.validate(); err != nil {
+				return err
+			}
+			if !slices.Contains(exportFormats, format) {
+				return fmt.Errorf("unknown format %q, want %s", format, strings.Join(exportFormats, ", "))
+			}
+			cols := strings.Split(columns, ",")
+			for i, col := range cols {
+				cols[i] = strings.TrimSpace(col)
+				if !slices.Contains(csvColumns, cols[i]) {
+					return fmt.Errorf("unknown column %q, want some of %s", cols[i], strings.Join(csvColumns, ", "))
+				}
+			}
+			isK8s := strings.HasPrefix(format, "k8s-")
+			if isK8s && name == "" {
+				return fmt.Errorf("--format %s needs --name", format)
+			}
+			if isK8s && stripPrefix == "" {
+				// the data of a Secret cannot have keys with a /
+				stripPrefix = strings.TrimSuffix(prefix, "*")
+			}
+			if cfKV && (account == "" || namespaceID == "") {
+				return fmt.Errorf("--cf-kv needs --account-id and --namespace-id")
+			}
+			patterns := c.Arg("keys").Array()
+			if prefix != "" {
+				patterns = append(patterns, strings.TrimSuffix(prefix, "*")+"*")
+			}
+			if err := connect(); err != nil {
+				return err
+			}
+			dryRun = dryRun || dryRunWrites
+			keys, err := selectKeys(patterns)
+			if err != nil {
+				return err
+			}
+			if cfKV {
+				return exportCFKV(account, namespaceID, stripPrefix, keys, &bulk, dryRun)
+			}
+			entries, err := dumpKeys(keys, stripPrefix, &bulk)
+			if err != nil {
+				return err
+			}
+			return writeOutput(output, func(w io.Writer) error {
+				switch format {
+				case "csv":
+					return writeCSV(w, entries, cols)
+				case "sh":
+					return writeShell(w, entries)
+				case "k8s-secret", "k8s-configmap":
+					return writeK8s(w, format, entries, name, k8sNamespace)
+				}
+				return writeDump(w, format, entries)
+			})
+		},
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gookit/gcli/v3"
+	"github.com/gookit/gcli/v3/gflag"
+)
+
+// defaultMaxFetch bounds the size of values fetched with --from-url.
+const defaultMaxFetch = "1MB"
+
+// parseSize parses a byte count such as 512, 64KB or 10MB.
+func parseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		n      int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	for _, u := range units {
+		if num, ok := strings.CutSuffix(upper, u.suffix); ok {
+			n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid size %q", s)
+			}
+			return n * u.n, nil
+		}
+	}
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q, e.g. 512KB or 10MB", s)
+	}
+	return n, nil
+}
+
+// fetched is the result of fetchURL; notModified is set, and body empty,
+// when the server answered a conditional request with 304.
+type fetched struct {
+	body         []byte
+	etag         string
+	lastModified string
+	notModified  bool
+}
+
+// fetchURL downloads url, sending headers given as "Name: value" and, if
+// etag or lastModified are set, making the request conditional.
+func fetchURL(url string, headers []string, etag, lastModified string, maxSize int64) (*fetched, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, fmt.Errorf("header %q should be Name: value", h)
+		}
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return &fetched{etag: etag, lastModified: lastModified, notModified: true}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", req.URL.Redacted(), resp.Status)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("%s is %d bytes, more than the limit of %d", req.URL.Redacted(), resp.ContentLength, maxSize)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxSize {
+		return nil, fmt.Errorf("%s is larger than the limit of %d bytes", req.URL.Redacted(), maxSize)
+	}
+	return &fetched{body: body, etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}, nil
+}
+
+// storeFetched stores a download as the value of key and records where it
+// came from for pb refresh. Headers are not recorded as they often carry
+// credentials.
+func storeFetched(key, url string, f *fetched) error {
+	return inTx(ctx, func(ctx context.Context) error {
+		if err := putKeyValue(ctx, key, f.body); err != nil {
+			return err
+		}
+		return setMeta(ctx, key, map[string]string{
+			"source_url":    url,
+			"etag":          f.etag,
+			"last_modified": f.lastModified,
+			"fetched_at":    time.Now().UTC().Format(time.RFC3339),
+		})
+	})
+}
+
+// setFromURL implements pb set --from-url.
+func setFromURL(key, url string, headers []string, maxSize string) error {
+	limit, err := parseSize(maxSize)
+	if err != nil {
+		return err
+	}
+	f, err := fetchURL(url, headers, "", "", limit)
+	if err != nil {
+		return err
+	}
+	return storeFetched(key, url, f)
+}
+
+func refreshCommand() *gcli.Command {
+	var headers gflag.Strings
+	maxSize := defaultMaxFetch
+	var force bool
+	return &gcli.Command{
+		Name: "refresh",
+		Desc: "Fetch keys set with --from-url again",
+		Help: `Downloads each key's source URL again, skipping the download when the
+server reports it unchanged. Headers given to pb set --from-url are not
+stored, pass them again with --header.`,
+		Config: func(c *gcli.Command) {
+			c.VarOpt(&headers, "header", "H", "A request header such as 'Authorization: Bearer x', may be repeated")
+			c.StrOpt(&maxSize, "max-size", "", maxSize, "The largest value to accept")
+			c.BoolOpt(&force, "force", "f", false, "Download even if the server reports no change")
+			c.AddArg("keys", "The keys to refresh", true, true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			limit, err := parseSize(maxSize)
+			if err != nil {
+				return err
+			}
+			if err := connect(); err != nil {
+				return err
+			}
+			for _, key := range c.Arg("keys").Array() {
+				meta, err := getMeta(ctx, key)
+				if err != nil {
+					return err
+				}
+				url := meta["source_url"]
+				if url == "" {
+					return fmt.Errorf("%s was not set with --from-url", key)
+				}
+				etag, lastModified := meta["etag"], meta["last_modified"]
+				if force {
+					etag, lastModified = "", ""
+				}
+				f, err := fetchURL(url, headers, etag, lastModified, limit)
+				if err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+				if f.notModified {
+					fmt.Printf("%s is up to date\n", key)
+					continue
+				}
+				if err := storeFetched(key, url, f); err != nil {
+					return err
+				}
+				fmt.Printf("%s updated (%d bytes)\n", key, len(f.body))
+			}
+			return nil
+		},
+	}
+}
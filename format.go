@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gookit/gcli/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// outputFormat is the --format of commands that read keys: text is what
+// each command has always printed, json and yaml are for scripts and
+// table is for humans. raw prints values as they are, a single one with
+// nothing added and several one per line without their keys; commands
+// that print no values print text for it.
+type outputFormat string
+
+const (
+	formatText  outputFormat = "text"
+	formatRaw   outputFormat = "raw"
+	formatJSON  outputFormat = "json"
+	formatYAML  outputFormat = "yaml"
+	formatTable outputFormat = "table"
+)
+
+// formatFlag is the global --format, which the commands that print keys
+// also take after their name, see formatOpt. Empty leaves each command its
+// own default.
+var formatFlag outputFormat
+
+// formatOpt adds --format to a command that prints what, setting
+// formatFlag as the global flag does.
+func formatOpt(c *gcli.Command, what string, def outputFormat) {
+	c.VarOpt(&formatFlag, "format", "", fmt.Sprintf("How to print %s: text, raw, json, yaml or table, default %s", what, def))
+}
+
+// commandFormat returns --format, or def if it was not given.
+func commandFormat(def outputFormat) outputFormat {
+	if formatFlag == "" {
+		return def
+	}
+	return formatFlag
+}
+
+// structured reports whether f prints records, rather than lines of text.
+func (f outputFormat) structured() bool {
+	return f == formatJSON || f == formatYAML || f == formatTable
+}
+
+func (f *outputFormat) String() string {
+	return string(*f)
+}
+
+func (f *outputFormat) Set(s string) error {
+	switch outputFormat(s) {
+	case formatText, formatRaw, formatJSON, formatYAML, formatTable:
+		*f = outputFormat(s)
+	case "env":
+		// what pb mget called text
+		*f = formatText
+	default:
+		return fmt.Errorf("unknown format %q, want text, raw, json, yaml or table", s)
+	}
+	return nil
+}
+
+// keyStat is what the store knows of a key besides its value.
+type keyStat struct {
+	Key string
+	// Size is the size of the value as stored, after compression and
+	// encryption.
+	Size      int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// UpdatedBy is who last wrote the value, see Config.User; empty for
+	// values written before it was recorded.
+	UpdatedBy string
+	// ExpiresAt is nil for keys without a TTL.
+	ExpiresAt *time.Time
+}
+
+// statStore is implemented by stores that keep when keys were written.
+type statStore interface {
+	// Stat returns the stat of the keys that exist.
+	Stat(ctx context.Context, keys []string) (map[string]keyStat, error)
+}
+
+// statColumns are the columns scanStats reads.
+const statColumns = "k, LENGTH(v), created_at, updated_at, updated_by, expires_at"
+
+// scanStats reads the rows of a query of statColumns, adding the size of
+// the chunks of chunked values.
+func scanStats(ctx context.Context, rows *sql.Rows) ([]keyStat, error) {
+	defer rows.Close()
+	var stats []keyStat
+	for rows.Next() {
+		var st keyStat
+		var created, updated string
+		var by, expires sql.NullString
+		if err := rows.Scan(&st.Key, &st.Size, &created, &updated, &by, &expires); err != nil {
+			return nil, err
+		}
+		st.UpdatedBy = by.String
+		var err error
+		if st.CreatedAt, err = parseDBTime(created); err == nil {
+			st.UpdatedAt, err = parseDBTime(updated)
+		}
+		if err == nil && expires.Valid {
+			var at time.Time
+			at, err = parseDBTime(expires.String)
+			st.ExpiresAt = &at
+		}
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	for i, st := range stats {
+		// only a value of the length of a reference can be one
+		if st.Size != len(chunkedMagic)+32 {
+			continue
+		}
+		var value []byte
+		if err := queryerFor(ctx).QueryRowContext(ctx, "SELECT v FROM "+kvTable+" WHERE k = ?", st.Key).Scan(&value); err != nil {
+			if err == sql.ErrNoRows {
+				continue
+			}
+			return nil, err
+		}
+		if !bytes.HasPrefix(value, chunkedMagic) {
+			continue
+		}
+		chunks, err := queryerFor(ctx).QueryContext(ctx, "SELECT LENGTH(v) FROM "+chunksTable()+" WHERE id = ?", value)
+		if err != nil {
+			return nil, err
+		}
+		size := 0
+		for chunks.Next() {
+			var n int
+			if err := chunks.Scan(&n); err != nil {
+				chunks.Close()
+				return nil, err
+			}
+			size += n
+		}
+		chunks.Close()
+		if err := chunks.Err(); err != nil {
+			return nil, err
+		}
+		stats[i].Size = size
+	}
+	return stats, nil
+}
+
+func (sqlStore) Stat(ctx context.Context, keys []string) (map[string]keyStat, error) {
+	stats := map[string]keyStat{}
+	for batch := range slices.Chunk(keys, msetBatch) {
+		args := make([]any, len(batch))
+		for i, key := range batch {
+			args[i] = key
+		}
+		rows, err := queryerFor(ctx).QueryContext(ctx, "SELECT "+statColumns+" FROM "+kvTable+" WHERE k IN (?"+strings.Repeat(", ?", len(batch)-1)+") AND "+notExpired(), args...)
+		if err != nil {
+			return nil, err
+		}
+		list, err := scanStats(ctx, rows)
+		if err != nil {
+			return nil, err
+		}
+		for _, st := range list {
+			stats[st.Key] = st
+		}
+	}
+	return stats, nil
+}
+
+// record is a key as printed by --format json, yaml and table. Times are
+// left out where the store does not keep them.
+type record struct {
+	Key   string `json:"key" yaml:"key"`
+	Value string `json:"value" yaml:"value"`
+	// Encoding is base64 for values that are not UTF-8 text.
+	Encoding  string     `json:"encoding,omitempty" yaml:"encoding,omitempty"`
+	Size      int        `json:"size" yaml:"size"`
+	CreatedAt *time.Time `json:"created_at,omitempty" yaml:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty" yaml:"updated_at,omitempty"`
+	UpdatedBy string     `json:"updated_by,omitempty" yaml:"updated_by,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+}
+
+func newRecord(key string, value []byte) record {
+	r := record{Key: key, Value: string(value), Size: len(value)}
+	if !utf8.Valid(value) {
+		r.Value, r.Encoding = base64.StdEncoding.EncodeToString(value), "base64"
+	}
+	return r
+}
+
+// keyRecords returns the records of keys, in order, from their values;
+// keys without a value are left out.
+func keyRecords(keys []string, values map[string][]byte) ([]record, error) {
+	var stats map[string]keyStat
+	if s, ok := store.(statStore); ok {
+		full := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if _, ok := values[key]; ok {
+				full = append(full, namespace+key)
+			}
+		}
+		var err error
+		if stats, err = s.Stat(ctx, full); err != nil {
+			return nil, err
+		}
+	}
+	records := make([]record, 0, len(keys))
+	seen := map[string]bool{}
+	for _, key := range keys {
+		value, ok := values[key]
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		r := newRecord(key, value)
+		if st, ok := stats[namespace+key]; ok {
+			r.CreatedAt, r.UpdatedAt, r.UpdatedBy, r.ExpiresAt = &st.CreatedAt, &st.UpdatedAt, st.UpdatedBy, st.ExpiresAt
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// printStructured prints v as JSON or YAML.
+func printStructured(format outputFormat, v any) error {
+	if format == formatYAML {
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printTable prints rows in aligned columns under header.
+func printTable(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// tableCell shortens a value to one line of a table.
+func tableCell(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) > max {
+		s = string([]rune(s)[:max-3]) + "..."
+	}
+	return s
+}
+
+// formatTime prints an optional time in a table.
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+// printRecords prints records in format, which is structured. one prints a
+// single record as itself rather than as a list.
+func printRecords(format outputFormat, records []record, one bool) error {
+	if format == formatTable {
+		rows := make([][]string, len(records))
+		for i, r := range records {
+			value := r.Value
+			if r.Encoding != "" {
+				value = "(binary)"
+			}
+			rows[i] = []string{r.Key, strconv.Itoa(r.Size), formatTime(r.UpdatedAt), formatTime(r.ExpiresAt), tableCell(value, 60)}
+		}
+		return printTable([]string{"KEY", "SIZE", "UPDATED", "EXPIRES", "VALUE"}, rows)
+	}
+	if one && len(records) == 1 {
+		return printStructured(format, records[0])
+	}
+	return printStructured(format, records)
+}
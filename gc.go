@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// gcBatch bounds the rows deleted per statement so that collecting a large
+// backlog never holds locks for long.
+const gcBatch = 500
+
+// gcTask finds and, unless dryRun, deletes one kind of garbage, returning
+// how many items it removed and their size in bytes.
+type gcTask struct {
+	name string
+	run  func(dryRun bool) (n int, bytes int64, err error)
+}
+
+// gcTasks are run in order by pb gc. Features that leave garbage behind
+// add their collector here.
+var gcTasks = []gcTask{
+	{"expired gists", gcGists},
+	{"expired keys", gcExpired},
+	{"orphaned metadata", gcMeta},
+	{"orphaned chunks", gcChunks},
+}
+
+// gcSkipped is returned by a task that cannot run on this board, saying
+// why.
+type gcSkipped string
+
+func (e gcSkipped) Error() string {
+	return string(e)
+}
+
+// baseStore returns the store that connect wrapped for --read-only,
+// --dry-run and Token.
+func baseStore(s Store) Store {
+	for {
+		switch w := s.(type) {
+		case aclStore:
+			s = w.Store
+		case readOnlySQLStore:
+			s = w.readOnlyStore.Store
+		case readOnlyStore:
+			s = w.Store
+		case *dryRunStore:
+			s = w.store
+		default:
+			return s
+		}
+	}
+}
+
+// sqlTables reports whether the tasks that delete rows of the tables
+// themselves, rather than through the store, can run: the board must be
+// on an SQL driver, and Token must not be set, as the rows are not checked
+// against its grants.
+func sqlTables() (bool, error) {
+	if _, ok := baseStore(store).(sqlStore); !ok {
+		return false, nil
+	}
+	if _, ok := store.(aclStore); ok {
+		return false, gcSkipped("it is not checked against the grants of Token, run it without")
+	}
+	return true, nil
+}
+
+// valueSize returns the stored size of the values of keys.
+func valueSize(keys []string) (int64, error) {
+	var total int64
+	for _, key := range keys {
+		v, err := store.Get(ctx, namespace+key)
+		if err != nil {
+			return 0, err
+		}
+		total += int64(len(v))
+	}
+	return total, nil
+}
+
+// gcGists deletes gists that have expired, and files whose gist has no
+// index, such as those left by an interrupted upload.
+func gcGists(dryRun bool) (n int, bytes int64, err error) {
+	keys, err := listKeysWithPrefix(ctx, gistPrefix)
+	if err != nil {
+		return 0, 0, err
+	}
+	byID := map[string][]string{}
+	for _, key := range keys {
+		id, _, _ := strings.Cut(strings.TrimPrefix(key, gistPrefix), "/")
+		byID[id] = append(byID[id], key)
+	}
+	for id, keys := range byID {
+		if err := ctx.Err(); err != nil {
+			return n, bytes, err
+		}
+		expired := !contains(keys, gistPrefix+id+"/index")
+		if !expired {
+			index, err := readGistIndex(id)
+			if err != nil {
+				return n, bytes, err
+			}
+			expired = time.Now().After(index.ExpiresAt)
+		}
+		if !expired {
+			continue
+		}
+		size, err := valueSize(keys)
+		if err != nil {
+			return n, bytes, err
+		}
+		if !dryRun {
+			if err := deleteGist(id); err != nil {
+				return n, bytes, err
+			}
+		}
+		n++
+		bytes += size
+	}
+	return n, bytes, nil
+}
+
+// gcExpired deletes keys written with pb set --ttl that have expired, and
+// their metadata. Other stores expire keys by themselves.
+func gcExpired(dryRun bool) (n int, bytes int64, err error) {
+	if ok, err := sqlTables(); !ok {
+		return 0, 0, err
+	}
+	for {
+		rows, err := queryerFor(ctx).QueryContext(ctx, `SELECT k, LENGTH(v) FROM `+kvTable+`
+WHERE `+hasPrefix("k")+` AND expires_at <= `+currentTimestamp()+` LIMIT ?`, likePrefix(namespace), gcBatch)
+		if err != nil {
+			return n, bytes, err
+		}
+		var expired []any
+		for rows.Next() {
+			var k string
+			var size int64
+			if err := rows.Scan(&k, &size); err != nil {
+				rows.Close()
+				return n, bytes, err
+			}
+			expired = append(expired, k)
+			bytes += size
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return n, bytes, err
+		}
+		n += len(expired)
+		if dryRun || len(expired) == 0 {
+			return n, bytes, nil
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(expired)), ",")
+		err = inTx(ctx, func(ctx context.Context) error {
+			// a key written again since the scan has a new expiry
+			if _, err := queryerFor(ctx).ExecContext(ctx, "DELETE FROM "+kvTable+" WHERE k IN ("+placeholders+") AND expires_at <= "+currentTimestamp(), expired...); err != nil {
+				return err
+			}
+			_, err := queryerFor(ctx).ExecContext(ctx, "DELETE FROM "+metaTable()+" WHERE k IN ("+placeholders+")", expired...)
+			return err
+		})
+		if err != nil {
+			return n, bytes, err
+		}
+		if len(expired) < gcBatch {
+			return n, bytes, nil
+		}
+	}
+}
+
+// gcMeta deletes metadata whose key no longer exists.
+func gcMeta(dryRun bool) (n int, bytes int64, err error) {
+	if ok, err := sqlTables(); !ok {
+		// other stores have no way to delete a key but not its metadata
+		return 0, 0, err
+	}
+	for {
+		rows, err := queryerFor(ctx).QueryContext(ctx, `SELECT m.k, SUM(LENGTH(m.v)) FROM `+metaTable()+` m
+LEFT JOIN `+kvTable+` t ON t.k = m.k
+WHERE `+hasPrefix("m.k")+` AND t.k IS NULL GROUP BY m.k LIMIT ?`, likePrefix(namespace), gcBatch)
+		if err != nil {
+			return n, bytes, err
+		}
+		var orphans []string
+		for rows.Next() {
+			var k string
+			var size int64
+			if err := rows.Scan(&k, &size); err != nil {
+				rows.Close()
+				return n, bytes, err
+			}
+			orphans = append(orphans, k)
+			bytes += size
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return n, bytes, err
+		}
+		n += len(orphans)
+		if dryRun || len(orphans) == 0 {
+			return n, bytes, nil
+		}
+		args := make([]any, len(orphans))
+		for i, k := range orphans {
+			args[i] = k
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(orphans)), ",")
+		if _, err := queryerFor(ctx).ExecContext(ctx, "DELETE FROM "+metaTable()+" WHERE k IN ("+placeholders+")", args...); err != nil {
+			return n, bytes, err
+		}
+		if len(orphans) < gcBatch {
+			return n, bytes, nil
+		}
+	}
+}
+
+// collectGarbage runs every task and prints what it reclaimed.
+func collectGarbage(dryRun bool) error {
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+	var total int64
+	for _, task := range gcTasks {
+		n, bytes, err := task.run(dryRun)
+		var skipped gcSkipped
+		if errors.As(err, &skipped) {
+			log.Printf("skipped %s: %v", task.name, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", task.name, err)
+		}
+		if n > 0 {
+			log.Printf("%s %d %s (%d bytes)", verb, n, task.name, bytes)
+		}
+		total += bytes
+	}
+	log.Printf("%s %d bytes in total", verb, total)
+	return nil
+}
+
+func gcCommand() *gcli.Command {
+	var daemon, dryRun bool
+	interval := Duration{time.Hour}
+	return &gcli.Command{
+		Name: "gc",
+		Desc: "Delete expired and orphaned data",
+		Help: `Removes expired gists, gist files left without an index, keys whose
+--ttl has passed, and metadata whose key was deleted, and reports the bytes
+reclaimed.`,
+		Config: func(c *gcli.Command) {
+			c.BoolOpt(&daemon, "daemon", "d", false, "Keep collecting every --interval until interrupted")
+			c.VarOpt(&interval, "interval", "", "How often to collect with --daemon")
+			c.BoolOpt(&dryRun, "dry-run", "n", false, "Report what would be removed without deleting anything")
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			// the tasks on the tables would bypass the staging of --dry-run
+			dryRun = dryRun || dryRunWrites
+			if readOnly && !dryRun {
+				return errReadOnly
+			}
+			for {
+				if err := collectGarbage(dryRun); err != nil {
+					if !daemon {
+						return err
+					}
+					log.Print(err)
+				}
+				if !daemon {
+					return nil
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(interval.Duration):
+				}
+			}
+		},
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gookit/gcli/v3"
+)
+
+const (
+	gistPrefix = "gists/"
+	// gistIDLen is longer than a short code since knowing the id is all
+	// it takes to read a gist.
+	gistIDLen = 12
+)
+
+var errGistExpired = errors.New("gist has expired")
+
+type gistFile struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+	Mode string `json:"mode"`
+}
+
+// gistIndex is stored at gists/<id>/index and lists the files stored at
+// gists/<id>/files/<name>.
+type gistIndex struct {
+	Files     []gistFile `json:"files"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+}
+
+// parseTTL is time.ParseDuration plus d (days) and w (weeks) units, which
+// is how people think about share lifetimes.
+func parseTTL(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.ParseFloat(n, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(v * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+func createGist(files []string, ttl time.Duration) (string, error) {
+	now := time.Now().UTC()
+	index := gistIndex{CreatedAt: now, ExpiresAt: now.Add(ttl)}
+	contents := map[string][]byte{}
+	for _, path := range files {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		if !fi.Mode().IsRegular() {
+			return "", fmt.Errorf("%s is not a regular file", path)
+		}
+		name := filepath.Base(path)
+		if _, dup := contents[name]; dup {
+			return "", fmt.Errorf("two files are named %s", name)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		contents[name] = b
+		index.Files = append(index.Files, gistFile{Name: name, Size: len(b), Mode: fmt.Sprintf("%04o", fi.Mode().Perm())})
+	}
+	b, err := json.Marshal(index)
+	if err != nil {
+		return "", err
+	}
+
+	id := randomCode(gistIDLen)
+	err = inTx(ctx, func(ctx context.Context) error {
+		for name, content := range contents {
+			if err := putKeyValue(ctx, gistPrefix+id+"/files/"+name, content); err != nil {
+				return err
+			}
+		}
+		return putKeyValue(ctx, gistPrefix+id+"/index", b)
+	})
+	return id, err
+}
+
+func readGistIndex(id string) (*gistIndex, error) {
+	b, err := getKey(ctx, gistPrefix+id+"/index")
+	if err != nil {
+		return nil, err
+	}
+	var index gistIndex
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, fmt.Errorf("gist %s has a corrupt index: %w", id, err)
+	}
+	return &index, nil
+}
+
+// loadGist returns the index of a gist, deleting it if it has expired.
+func loadGist(id string) (*gistIndex, error) {
+	index, err := readGistIndex(id)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(index.ExpiresAt) {
+		if err := deleteGist(id); err != nil {
+			return nil, err
+		}
+		return nil, errGistExpired
+	}
+	return index, nil
+}
+
+func deleteGist(id string) error {
+	keys, err := listKeysWithPrefix(ctx, gistPrefix+id+"/")
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err := deleteKey(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gistHandler serves /g/{id} as a plain text listing and /g/{id}/{name} as
+// the raw file.
+func gistHandler(w http.ResponseWriter, r *http.Request) {
+	id, name := r.PathValue("id"), r.PathValue("name")
+	index, err := loadGist(id)
+	switch {
+	case err == sql.ErrNoRows:
+		http.NotFound(w, r)
+		return
+	case err == errGistExpired:
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	case err != nil:
+		log.Printf("gist %s: %v", id, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if name == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "gist %s, expires %s\n\n", id, index.ExpiresAt.Format(time.RFC3339))
+		for _, f := range index.Files {
+			fmt.Fprintf(w, "%s\t%d bytes\t/g/%s/%s\n", f.Name, f.Size, id, f.Name)
+		}
+		return
+	}
+	content, err := getKey(ctx, gistPrefix+id+"/files/"+name)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", name))
+	w.Write(content)
+}
+
+func gistCommand() *gcli.Command {
+	ttl := "7d"
+	var dir string
+	var force bool
+	return &gcli.Command{
+		Name: "gist",
+		Desc: "Share a set of files that expires, also served as /g/{id} by pb serve",
+		Subs: []*gcli.Command{
+			{
+				Name: "create",
+				Desc: "Store files under a new share id",
+				Config: func(c *gcli.Command) {
+					c.StrOpt(&ttl, "ttl", "", ttl, "How long the gist is kept, e.g. 12h, 3d or 2w")
+					c.AddArg("files", "The files to share", true, true)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					d, err := parseTTL(ttl)
+					if err != nil {
+						return err
+					}
+					if d <= 0 {
+						return fmt.Errorf("--ttl must be positive")
+					}
+					if err := connect(); err != nil {
+						return err
+					}
+					id, err := createGist(c.Arg("files").Array(), d)
+					if err != nil {
+						return err
+					}
+					fmt.Println(id)
+					return nil
+				},
+			},
+			{
+				Name: "get",
+				Desc: "Download the files of a gist",
+				Config: func(c *gcli.Command) {
+					c.StrOpt(&dir, "dir", "d", ".", "The directory to write the files to")
+					c.BoolOpt(&force, "force", "f", false, "Overwrite existing files")
+					c.AddArg("id", "The gist id", true)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					if err := connect(); err != nil {
+						return err
+					}
+					id := c.Arg("id").String()
+					index, err := loadGist(id)
+					if err == sql.ErrNoRows {
+						return fmt.Errorf("no gist %s", id)
+					}
+					if err != nil {
+						return err
+					}
+					if err := os.MkdirAll(dir, 0755); err != nil {
+						return err
+					}
+					for _, f := range index.Files {
+						path := filepath.Join(dir, f.Name)
+						if _, err := os.Stat(path); err == nil && !force {
+							return fmt.Errorf("%s already exists, use --force to overwrite", path)
+						}
+						content, err := getKey(ctx, gistPrefix+id+"/files/"+f.Name)
+						if err != nil {
+							return err
+						}
+						mode, err := strconv.ParseUint(f.Mode, 8, 32)
+						if err != nil {
+							mode = 0644
+						}
+						if err := os.WriteFile(path, content, os.FileMode(mode)); err != nil {
+							return err
+						}
+						fmt.Println(path)
+					}
+					return nil
+				},
+			},
+		},
+	}
+}
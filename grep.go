@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// grepMatch is a line that pb grep matched, as --format json, yaml and
+// table print it.
+type grepMatch struct {
+	Key string `json:"key" yaml:"key"`
+	// Line is empty for binary values, of which only the key is printed.
+	Line   string `json:"line,omitempty" yaml:"line,omitempty"`
+	Binary bool   `json:"binary,omitempty" yaml:"binary,omitempty"`
+}
+
+// grepKeys prints the lines of the values of the keys starting with
+// prefix that re matches in format, a page of keys at a time: as key:
+// line, or the lines alone with raw. Structured formats are printed once
+// all are found. It reports whether any matched.
+func grepKeys(re *regexp.Regexp, prefix string, keysOnly bool, format outputFormat) (bool, error) {
+	matched := false
+	var matches []grepMatch
+	match := func(m grepMatch) {
+		matched = true
+		switch {
+		case format.structured():
+			matches = append(matches, m)
+		case keysOnly:
+			fmt.Println(m.Key)
+		case m.Binary:
+			fmt.Printf("%s: binary value matches\n", m.Key)
+		case format == formatRaw:
+			fmt.Println(m.Line)
+		default:
+			fmt.Printf("%s: %s\n", m.Key, m.Line)
+		}
+	}
+	after := ""
+	for {
+		keys, err := listKeysPage(ctx, prefix, after, listPage)
+		if err != nil {
+			return false, err
+		}
+		values, err := getKeys(ctx, keys)
+		if err != nil {
+			return false, err
+		}
+		for _, key := range keys {
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
+			value, ok := values[key]
+			if !ok || !re.Match(value) {
+				continue
+			}
+			if keysOnly {
+				match(grepMatch{Key: key})
+				continue
+			}
+			if !utf8.Valid(value) {
+				match(grepMatch{Key: key, Binary: true})
+				continue
+			}
+			lines := bufio.NewScanner(bytes.NewReader(value))
+			lines.Buffer(nil, len(value)+1)
+			for lines.Scan() {
+				if re.Match(lines.Bytes()) {
+					match(grepMatch{Key: key, Line: lines.Text()})
+				}
+			}
+		}
+		if len(keys) < listPage {
+			break
+		}
+		after = keys[len(keys)-1]
+	}
+	if !format.structured() || !matched {
+		return matched, nil
+	}
+	if format != formatTable {
+		if keysOnly {
+			keys := make([]string, len(matches))
+			for i, m := range matches {
+				keys[i] = m.Key
+			}
+			return true, printStructured(format, keys)
+		}
+		return true, printStructured(format, matches)
+	}
+	header := []string{"KEY", "LINE"}
+	if keysOnly {
+		header = header[:1]
+	}
+	rows := make([][]string, len(matches))
+	for i, m := range matches {
+		line := tableCell(m.Line, 80)
+		if m.Binary {
+			line = "(binary)"
+		}
+		rows[i] = append([]string{m.Key}, line)[:len(header)]
+	}
+	return true, printTable(header, rows)
+}
+
+func grepCommand() *gcli.Command {
+	var ignoreCase, fixed, keysOnly bool
+	return &gcli.Command{
+		Name: "grep",
+		Desc: "Search the values of keys for a regular expression",
+		Help: `Prints the matching lines as key: line, like grep -H, or the lines alone
+with --format raw:
+
+  pb grep -F db1.internal app/
+
+pb exits with 1 if nothing matches.`,
+		Config: func(c *gcli.Command) {
+			c.BoolOpt(&ignoreCase, "ignore-case", "i", false, "Match upper and lower case alike")
+			c.BoolOpt(&fixed, "fixed-strings", "F", false, "Search for the pattern as is rather than as a regular expression")
+			c.BoolOpt(&keysOnly, "keys", "l", false, "Only print the keys whose values match")
+			formatOpt(c, "the matches", formatText)
+			c.AddArg("pattern", "The regular expression to search for, in Go syntax", true)
+			c.AddArg("prefix", "Only search the keys starting with this", false)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			pattern := c.Arg("pattern").String()
+			if fixed {
+				pattern = regexp.QuoteMeta(pattern)
+			}
+			if ignoreCase {
+				pattern = "(?i)" + pattern
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return err
+			}
+			if err := connect(); err != nil {
+				return err
+			}
+			matched, err := grepKeys(re, strings.TrimSuffix(c.Arg("prefix").String(), "*"), keysOnly, commandFormat(formatText))
+			if err != nil {
+				return err
+			}
+			if !matched {
+				return exitStatus(exitFalse)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/postboard/kvpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcWatchInterval is how often a Watch call polls for changes.
+const grpcWatchInterval = time.Second
+
+// kvServer implements the KV service of kvpb/kv.proto on top of the same
+// helpers as the CLI, so hooks, encryption and the cache apply.
+type kvServer struct {
+	kvpb.UnimplementedKVServer
+}
+
+// grpcError converts err to a status, hiding the details of unexpected
+// errors from clients.
+func grpcError(method string, err error) error {
+	var rejected *hookRejection
+	switch {
+	case err == sql.ErrNoRows:
+		return status.Error(codes.NotFound, "no such key")
+	case errors.As(err, &rejected):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errReadOnly), errors.Is(err, errForbidden):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, errUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+	log.Printf("grpc %s: %v", method, err)
+	return status.Error(codes.Internal, "internal error")
+}
+
+func (kvServer) Get(ctx context.Context, req *kvpb.GetRequest) (*kvpb.GetResponse, error) {
+	if err := requestPrincipal(ctx).check(req.Key, false); err != nil {
+		return nil, grpcError("Get", err)
+	}
+	value, err := getKey(ctx, req.Key)
+	if err != nil {
+		return nil, grpcError("Get", err)
+	}
+	return &kvpb.GetResponse{Value: value}, nil
+}
+
+func (kvServer) Put(ctx context.Context, req *kvpb.PutRequest) (*kvpb.PutResponse, error) {
+	if err := requestPrincipal(ctx).check(req.Key, true); err != nil {
+		return nil, grpcError("Put", err)
+	}
+	if err := putKeyValue(ctx, req.Key, req.Value); err != nil {
+		return nil, grpcError("Put", err)
+	}
+	return &kvpb.PutResponse{}, nil
+}
+
+func (kvServer) Delete(ctx context.Context, req *kvpb.DeleteRequest) (*kvpb.DeleteResponse, error) {
+	if err := requestPrincipal(ctx).check(req.Key, true); err != nil {
+		return nil, grpcError("Delete", err)
+	}
+	deleted, err := deleteKey(ctx, req.Key)
+	if err != nil {
+		return nil, grpcError("Delete", err)
+	}
+	if !deleted {
+		return nil, status.Error(codes.NotFound, "no such key")
+	}
+	return &kvpb.DeleteResponse{}, nil
+}
+
+// List leaves out the keys the user may not read.
+func (kvServer) List(ctx context.Context, req *kvpb.ListRequest) (*kvpb.ListResponse, error) {
+	keys, err := listKeysWithPrefix(ctx, req.Prefix)
+	if err != nil {
+		return nil, grpcError("List", err)
+	}
+	p := requestPrincipal(ctx)
+	readable := []string{}
+	for _, key := range keys {
+		if p.can(namespace+key, false) {
+			readable = append(readable, key)
+		}
+	}
+	return &kvpb.ListResponse{Keys: readable}, nil
+}
+
+// Watch leaves out the changes to keys the user may not read.
+func (kvServer) Watch(req *kvpb.WatchRequest, stream grpc.ServerStreamingServer[kvpb.WatchEvent]) error {
+	p := requestPrincipal(stream.Context())
+	err := watchKeys(stream.Context(), req.Key, req.Prefix, grpcWatchInterval, func(e keyEvent) error {
+		if !p.can(namespace+e.Key, false) {
+			return nil
+		}
+		ev := &kvpb.WatchEvent{Type: kvpb.WatchEvent_DELETE, Key: e.Key}
+		if !e.Deleted {
+			value, err := e.Value()
+			if err != nil {
+				return err
+			}
+			ev.Type, ev.Value = kvpb.WatchEvent_PUT, value
+		}
+		return stream.Send(ev)
+	})
+	if err != nil && stream.Context().Err() == nil {
+		return grpcError("Watch", err)
+	}
+	return nil
+}
+
+// grpcPrincipal authenticates a call by the token in its authorization
+// metadata, as for HTTP, and returns its context carrying the principal,
+// see requestPrincipal.
+func grpcPrincipal(ctx context.Context, method string) (context.Context, error) {
+	p, err := authenticate(grpcToken(ctx))
+	if err != nil {
+		return nil, grpcError(method, err)
+	}
+	return context.WithValue(ctx, principalKey{}, p), nil
+}
+
+// grpcToken returns the bearer token in the authorization metadata of a
+// call.
+func grpcToken(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			return strings.TrimSpace(strings.TrimPrefix(values[0], "Bearer "))
+		}
+	}
+	return ""
+}
+
+func grpcAuth(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := grpcPrincipal(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func grpcStreamAuth(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := grpcPrincipal(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, authedStream{ss, ctx})
+}
+
+// authedStream is a stream with the context made by grpcPrincipal.
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authedStream) Context() context.Context {
+	return s.ctx
+}
+
+// serveGRPC runs the gRPC server until pb is interrupted, then lets
+// in-flight calls finish.
+func serveGRPC(addr string, tlsCfg *tls.Config) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(grpcMetrics, grpcRateLimit, grpcAuth),
+		grpc.ChainStreamInterceptor(grpcStreamMetrics, grpcStreamRateLimit, grpcStreamAuth),
+	}
+	if tlsCfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+	srv := grpc.NewServer(opts...)
+	kvpb.RegisterKVServer(srv, kvServer{})
+	log.Printf("serving gRPC on %s", addr)
+	go func() {
+		<-ctx.Done()
+		// Watch streams end by themselves once ctx is done
+		srv.GracefulStop()
+	}()
+	return srv.Serve(lis)
+}
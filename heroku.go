@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gookit/gcli/v3"
+)
+
+func herokuAPI() string {
+	if host := os.Getenv("HEROKU_API_URL"); host != "" {
+		return strings.TrimSuffix(host, "/")
+	}
+	return "https://api.heroku.com"
+}
+
+// herokuConfigVars sends a request to the config-vars endpoint of app,
+// which both returns and, for PATCH, updates all of the app's vars.
+func herokuConfigVars(method, app string, body any) (map[string]string, error) {
+	token := os.Getenv("HEROKU_API_KEY")
+	if token == "" {
+		return nil, fmt.Errorf("set HEROKU_API_KEY, e.g. to the output of heroku auth:token")
+	}
+	req, err := newJSONRequest(method, herokuAPI()+"/apps/"+url.PathEscape(app)+"/config-vars", token, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.heroku+json; version=3")
+	vars := map[string]string{}
+	return vars, doJSON(req, &vars)
+}
+
+func herokuCommand() *gcli.Command {
+	var app, prefix string
+	var dryRun, del bool
+	config := func(c *gcli.Command) {
+		c.StrOpt(&app, "app", "a", os.Getenv("HEROKU_APP"), "The Heroku app (default $HEROKU_APP)")
+		c.StrOpt(&prefix, "prefix", "p", "", "The key prefix holding the config vars, e.g. heroku/myapp/")
+		c.BoolOpt(&dryRun, "dry-run", "n", false, "Print what would be done without changing anything")
+	}
+	return &gcli.Command{
+		Name: "heroku",
+		Desc: "Copy the config vars of a Heroku app to or from a key prefix",
+		Help: `Each config var is one key under the prefix. Authenticates with the API
+key in HEROKU_API_KEY.`,
+		Subs: []*gcli.Command{
+			{
+				Name:   "pull",
+				Desc:   "Store the config vars of an app as secret keys",
+				Config: config,
+				Func: func(c *gcli.Command, args []string) error {
+					if app == "" {
+						return fmt.Errorf("--app is required")
+					}
+					vars, err := herokuConfigVars("GET", app, nil)
+					if err != nil {
+						return err
+					}
+					var secrets []pulledSecret
+					for _, name := range slices.Sorted(maps.Keys(vars)) {
+						secrets = append(secrets, pulledSecret{Name: name, Value: vars[name], Secret: true})
+					}
+					return storeSecrets(prefix, secrets, dryRun, false)
+				},
+			},
+			{
+				Name: "push",
+				Desc: "Set the config vars of an app from the keys under a prefix",
+				Config: func(c *gcli.Command) {
+					config(c)
+					c.BoolOpt(&del, "delete", "", false, "Unset config vars that have no key under the prefix")
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					if app == "" {
+						return fmt.Errorf("--app is required")
+					}
+					if prefix == "" {
+						return fmt.Errorf("--prefix is required, pushing the whole board is never intended")
+					}
+					if err := connect(); err != nil {
+						return err
+					}
+					dryRun = dryRun || dryRunWrites
+					keys, err := listKeysWithPrefix(ctx, prefix)
+					if err != nil {
+						return err
+					}
+					current, err := herokuConfigVars("GET", app, nil)
+					if err != nil {
+						return err
+					}
+					// a null value unsets the var
+					patch := map[string]*string{}
+					for _, key := range keys {
+						value, err := getKey(ctx, key)
+						if err != nil {
+							return err
+						}
+						name, v := strings.TrimPrefix(key, prefix), string(value)
+						if old, ok := current[name]; ok && old == v {
+							continue
+						}
+						patch[name] = &v
+						fmt.Printf("set %s from %s\n", name, key)
+					}
+					if del {
+						for name := range current {
+							if !slices.Contains(keys, prefix+name) {
+								patch[name] = nil
+								fmt.Printf("unset %s\n", name)
+							}
+						}
+					}
+					if dryRun || len(patch) == 0 {
+						return nil
+					}
+					_, err = herokuConfigVars("PATCH", app, patch)
+					return err
+				},
+			},
+		},
+	}
+}
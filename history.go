@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// keyVersion describes one value a key has had. Versions count up from 1
+// per key; the current value, if any, is the newest.
+type keyVersion struct {
+	Version   int       `json:"version" yaml:"version"`
+	UpdatedAt time.Time `json:"updated_at" yaml:"updated_at"`
+	Size      int       `json:"size" yaml:"size"`
+	Current   bool      `json:"current" yaml:"current"`
+}
+
+// versionedStore is implemented by stores that keep the values a key had
+// before it was overwritten or deleted, see pb history.
+type versionedStore interface {
+	// History returns the versions of key, newest first.
+	History(ctx context.Context, key string) ([]keyVersion, error)
+	// GetVersion returns the value of key at version, or sql.ErrNoRows.
+	GetVersion(ctx context.Context, key string, version int) ([]byte, error)
+}
+
+func historyTable() string {
+	return kvTable + "_history"
+}
+
+// archive copies the current value of key to its history before it is
+// replaced by value, or deleted if value is nil. Rewriting the same value
+// adds no version. It must run in the transaction of the write, whose row
+// it locks so that concurrent writers of key number their versions one
+// after the other.
+func (sqlStore) archive(ctx context.Context, key string, value []byte) error {
+	tx := queryerFor(ctx)
+	var locked string
+	err := tx.QueryRowContext(ctx, `SELECT k FROM `+kvTable+` WHERE k = ?`+forUpdate(), key).Scan(&locked)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	stmt := `INSERT INTO ` + historyTable() + ` (k, version, v, updated_at)
+SELECT k, (SELECT COALESCE(MAX(version), 0) + 1 FROM ` + historyTable() + ` WHERE k = ?), v, updated_at
+FROM ` + kvTable + ` WHERE k = ?`
+	args := []any{key, key}
+	if value != nil {
+		stmt += " AND v <> ?"
+		args = append(args, value)
+	}
+	_, err = tx.ExecContext(ctx, stmt, args...)
+	return err
+}
+
+// parseDBTime parses a timestamp column scanned into a string, which
+// drivers format differently.
+func parseDBTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02 15:04:05.999999", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func (sqlStore) History(ctx context.Context, key string) ([]keyVersion, error) {
+	rows, err := queryerFor(ctx).QueryContext(ctx, `SELECT version, updated_at, LENGTH(v) FROM `+historyTable()+`
+WHERE k = ? ORDER BY version DESC`, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var versions []keyVersion
+	for rows.Next() {
+		var v keyVersion
+		var at string
+		if err := rows.Scan(&v.Version, &at, &v.Size); err != nil {
+			return nil, err
+		}
+		if v.UpdatedAt, err = parseDBTime(at); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	current := keyVersion{Version: 1, Current: true}
+	if len(versions) > 0 {
+		current.Version = versions[0].Version + 1
+	}
+	var at string
+	err = queryerFor(ctx).QueryRowContext(ctx, `SELECT updated_at, LENGTH(v) FROM `+kvTable+` WHERE k = ? AND `+notExpired(), key).Scan(&at, &current.Size)
+	if err == sql.ErrNoRows {
+		// deleted or expired
+		return versions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if current.UpdatedAt, err = parseDBTime(at); err != nil {
+		return nil, err
+	}
+	return append([]keyVersion{current}, versions...), nil
+}
+
+func (s sqlStore) GetVersion(ctx context.Context, key string, version int) (value []byte, err error) {
+	err = queryerFor(ctx).QueryRowContext(ctx, `SELECT v FROM `+historyTable()+` WHERE k = ? AND version = ?`, key, version).Scan(&value)
+	if err == nil {
+		return readChunks(ctx, queryerFor(ctx), value)
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+	// the current value has the version after the last archived one
+	var last int
+	if err := queryerFor(ctx).QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM `+historyTable()+` WHERE k = ?`, key).Scan(&last); err != nil {
+		return nil, err
+	}
+	if version != last+1 {
+		return nil, sql.ErrNoRows
+	}
+	return s.Get(ctx, key)
+}
+
+// versions returns the store's history, or an error naming the driver if
+// it keeps none.
+func versions() (versionedStore, error) {
+	if s, ok := store.(versionedStore); ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("the %s driver does not keep history", sqlDriver)
+}
+
+// getVersion returns the value key had at version, decrypted.
+func getVersion(key string, version int) ([]byte, error) {
+	s, err := versions()
+	if err != nil {
+		return nil, err
+	}
+	value, err := s.GetVersion(ctx, namespace+key, version)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%s has no version %d: %w", key, version, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decryptValue(value)
+}
+
+func historyCommand() *gcli.Command {
+	return &gcli.Command{
+		Name: "history",
+		Desc: "List the earlier values of a key",
+		Help: `Every time a key is overwritten or deleted its value is kept as a
+version. Print one with pb get --version N key.`,
+		Config: func(c *gcli.Command) {
+			formatOpt(c, "the versions", formatText)
+			c.AddArg("key", "The key to list the versions of", true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			key := c.Arg("key").String()
+			s, err := versions()
+			if err != nil {
+				return err
+			}
+			list, err := s.History(ctx, namespace+key)
+			if err != nil {
+				return err
+			}
+			if len(list) == 0 {
+				return fmt.Errorf("%s: %w", key, sql.ErrNoRows)
+			}
+			switch format := commandFormat(formatText); format {
+			case formatJSON, formatYAML:
+				return printStructured(format, list)
+			case formatTable:
+				rows := make([][]string, len(list))
+				for i, v := range list {
+					current := ""
+					if v.Current {
+						current = "yes"
+					}
+					rows[i] = []string{strconv.Itoa(v.Version), formatTime(&v.UpdatedAt), strconv.Itoa(v.Size), current}
+				}
+				return printTable([]string{"VERSION", "UPDATED", "SIZE", "CURRENT"}, rows)
+			}
+			for _, v := range list {
+				line := fmt.Sprintf("%-8s %s  %d bytes", strconv.Itoa(v.Version), v.UpdatedAt.Format(time.DateTime), v.Size)
+				if v.Current {
+					line += "  (current)"
+				}
+				fmt.Println(line)
+			}
+			return nil
+		},
+	}
+}
+
+func rollbackCommand() *gcli.Command {
+	var to int
+	return &gcli.Command{
+		Name: "rollback",
+		Desc: "Restore an earlier value of a key",
+		Help: `Sets the key to the value it had before the last write, or to version
+--to-version of pb history. The value it replaces is kept as a version, so
+a rollback can be rolled back too.`,
+		Config: func(c *gcli.Command) {
+			c.IntOpt(&to, "to-version", "", 0, "The version to restore, by default the one before the current value")
+			c.AddArg("key", "The key to roll back", true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			key := c.Arg("key").String()
+			s, err := versions()
+			if err != nil {
+				return err
+			}
+			list, err := s.History(ctx, namespace+key)
+			if err != nil {
+				return err
+			}
+			if to == 0 {
+				if len(list) > 0 && list[0].Current {
+					list = list[1:]
+				}
+				if len(list) == 0 {
+					return fmt.Errorf("%s has no earlier version: %w", key, sql.ErrNoRows)
+				}
+				to = list[0].Version
+			} else if len(list) > 0 && list[0].Current && list[0].Version == to {
+				fmt.Printf("%s is already at version %d\n", key, to)
+				return nil
+			}
+			value, err := getVersion(key, to)
+			if err != nil {
+				return err
+			}
+			if err := putKeyValue(ctx, key, value); err != nil {
+				return err
+			}
+			fmt.Printf("Restored %s to version %d\n", key, to)
+			return nil
+		},
+	}
+}
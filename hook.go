@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// hookMemoryLimitPages caps hook memory at 16 MiB (64 KiB pages).
+const hookMemoryLimitPages = 256
+
+// HookConfig runs a WASM module on every value written under Prefix.
+//
+// Modules are WASI command modules. A module reads the value on stdin and
+// writes the value to store on stdout; the key is its first argument and
+// PB_KEY in its environment. Exiting non-zero rejects the write, with
+// stderr as the reason. Modules get no filesystem, network or clock beyond
+// what WASI requires, so the same hook behaves identically wherever pb
+// runs.
+type HookConfig struct {
+	Prefix string `json:"Prefix"`
+	Module string `json:"Module"`
+	// Timeout defaults to 5s.
+	Timeout Duration `json:"Timeout,omitzero"`
+}
+
+var (
+	hooks       []*HookConfig
+	hookRuntime wazero.Runtime
+	// hookModules caches compiled modules by path for the life of the process.
+	hookModules = map[string]wazero.CompiledModule{}
+)
+
+func setupHooks(cfg *Config) {
+	hooks = cfg.Hooks
+}
+
+func (h *HookConfig) validate(field string) error {
+	if h.Module == "" {
+		return fieldErrorf(field+".Module", "is empty, expected the path of a .wasm file")
+	}
+	if _, err := os.Stat(h.Module); err != nil {
+		return fieldErrorf(field+".Module", "%v", err)
+	}
+	if h.Timeout.Duration < 0 {
+		return fieldErrorf(field+".Timeout", "must not be negative")
+	}
+	return nil
+}
+
+func compileHook(path string) (wazero.CompiledModule, error) {
+	if m, ok := hookModules[path]; ok {
+		return m, nil
+	}
+	if hookRuntime == nil {
+		hookRuntime = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+			WithCloseOnContextDone(true).
+			WithMemoryLimitPages(hookMemoryLimitPages))
+		wasi_snapshot_preview1.MustInstantiate(ctx, hookRuntime)
+		onExit(func() { hookRuntime.Close(context.Background()) })
+	}
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := hookRuntime.CompileModule(ctx, code)
+	if err != nil {
+		return nil, fmt.Errorf("compile hook %s: %w", path, err)
+	}
+	hookModules[path] = m
+	return m, nil
+}
+
+// runHook feeds value to the module of h and returns what it wrote to
+// stdout.
+func runHook(h *HookConfig, key string, value []byte) ([]byte, error) {
+	m, err := compileHook(h.Module)
+	if err != nil {
+		return nil, err
+	}
+	timeout := h.Timeout.Duration
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	hookCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	modCfg := wazero.NewModuleConfig().
+		WithName("").
+		WithArgs(h.Module, key).
+		WithEnv("PB_KEY", key).
+		WithStdin(bytes.NewReader(value)).
+		WithStdout(&stdout).
+		WithStderr(&stderr)
+	mod, err := hookRuntime.InstantiateModule(hookCtx, m, modCfg)
+	if mod != nil {
+		mod.Close(context.Background())
+	}
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 0 {
+		err = nil
+	}
+	switch {
+	case hookCtx.Err() == context.DeadlineExceeded:
+		return nil, fmt.Errorf("hook %s timed out after %s", h.Module, timeout)
+	case errors.As(err, &exitErr):
+		reason := strings.TrimSpace(stderr.String())
+		if reason == "" {
+			reason = fmt.Sprintf("exit code %d", exitErr.ExitCode())
+		}
+		return nil, &hookRejection{fmt.Sprintf("hook %s rejected %s: %s", h.Module, key, reason)}
+	case err != nil:
+		return nil, fmt.Errorf("hook %s: %w", h.Module, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// hookRejection is the error for a value a hook refused, as opposed to a
+// hook that failed to run.
+type hookRejection struct {
+	msg string
+}
+
+func (e *hookRejection) Error() string {
+	return e.msg
+}
+
+// hasHooks reports whether any hook runs on values of key.
+func hasHooks(key string) bool {
+	for _, h := range hooks {
+		if strings.HasPrefix(key, h.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// runHooks passes value through every hook whose prefix matches key, in
+// config order, each seeing the output of the previous one.
+func runHooks(key string, value []byte) ([]byte, error) {
+	for _, h := range hooks {
+		if !strings.HasPrefix(key, h.Prefix) {
+			continue
+		}
+		var err error
+		if value, err = runHook(h, key, value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// authMethods are the values of Config.Auth. Both replace the password in
+// the DSN with a short-lived token generated for each new connection, so
+// tokens never expire under a long-running pb serve.
+var authMethods = []string{"rds-iam", "cloudsql-iam"}
+
+// tokenConnector opens connections with a fresh token as the password.
+type tokenConnector struct {
+	dsn   *mysql.Config
+	token func(ctx context.Context) (string, error)
+}
+
+func (c *tokenConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	token, err := c.token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get auth token: %w", err)
+	}
+	dsn := c.dsn.Clone()
+	dsn.Passwd = token
+	connector, err := mysql.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (c *tokenConnector) Driver() driver.Driver {
+	return &mysql.MySQLDriver{}
+}
+
+// newTokenConnector returns a connector authenticating with method.
+func newTokenConnector(dsn *mysql.Config, method string) (driver.Connector, error) {
+	if dsn.TLSConfig == "" || dsn.TLSConfig == "false" {
+		return nil, fieldErrorf("DSN", "Auth %s sends the token in the clear, add tls=true or tls=skip-verify to the DSN", method)
+	}
+	// tokens are sent with the cleartext plugin, protected by TLS
+	dsn.AllowCleartextPasswords = true
+	var token func(ctx context.Context) (string, error)
+	var err error
+	switch method {
+	case "rds-iam":
+		token, err = rdsTokenFunc(dsn)
+	case "cloudsql-iam":
+		token, err = cloudSQLTokenFunc()
+	default:
+		err = fieldErrorf("Auth", "unknown method %q, expected one of %s", method, strings.Join(authMethods, ", "))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tokenConnector{dsn: dsn, token: token}, nil
+}
+
+// rdsTokenFunc signs RDS IAM auth tokens with the default AWS credential
+// chain. Signing is local, so a token is made for every connection.
+func rdsTokenFunc(dsn *mysql.Config) (func(context.Context) (string, error), error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	region := awsCfg.Region
+	if region == "" {
+		region = rdsRegion(dsn.Addr)
+	}
+	if region == "" {
+		return nil, fmt.Errorf("cannot tell the AWS region of %s, set AWS_REGION", dsn.Addr)
+	}
+	endpoint := dsn.Addr
+	if _, _, err := net.SplitHostPort(endpoint); err != nil {
+		endpoint = net.JoinHostPort(endpoint, "3306")
+	}
+	return func(ctx context.Context) (string, error) {
+		creds, err := awsCfg.Credentials.Retrieve(ctx)
+		if err != nil {
+			return "", err
+		}
+		return rdsAuthToken(ctx, endpoint, region, dsn.User, creds)
+	}, nil
+}
+
+// rdsRegion extracts the region from an RDS endpoint such as
+// db.abc123.eu-west-1.rds.amazonaws.com:3306.
+func rdsRegion(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	parts := strings.Split(host, ".")
+	for i, p := range parts {
+		if p == "rds" && i > 0 {
+			return parts[i-1]
+		}
+	}
+	return ""
+}
+
+// rdsAuthToken is the presigned connect URL, without its scheme, that RDS
+// accepts as the password for 15 minutes.
+func rdsAuthToken(ctx context.Context, endpoint, region, user string, creds aws.Credentials) (string, error) {
+	u := "https://" + endpoint + "/?" + url.Values{
+		"Action":        {"connect"},
+		"DBUser":        {user},
+		"X-Amz-Expires": {"900"},
+	}.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return "", err
+	}
+	// the SHA-256 of an empty payload
+	const emptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	signed, _, err := v4.NewSigner().PresignHTTP(ctx, creds, req, emptyPayload, "rds-db", region, time.Now())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(signed, "https://"), nil
+}
+
+// cloudSQLTokenFunc returns OAuth2 access tokens of the application default
+// credentials, which Cloud SQL accepts as the password of IAM users. Tokens
+// are reused until they are about to expire.
+func cloudSQLTokenFunc() (func(context.Context) (string, error), error) {
+	ts, err := google.DefaultTokenSource(ctx, "https://www.googleapis.com/auth/sqlservice.login")
+	if err != nil {
+		return nil, err
+	}
+	ts = oauth2.ReuseTokenSource(nil, ts)
+	return func(context.Context) (string, error) {
+		t, err := ts.Token()
+		if err != nil {
+			return "", err
+		}
+		return t.AccessToken, nil
+	}, nil
+}
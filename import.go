@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// importBatch is how many keys pb import writes per transaction.
+const importBatch = 1000
+
+// importFormats are the formats pb import reads.
+var importFormats = []string{"json", "ndjson", "csv", "tsv", "dotenv"}
+
+// importFormat guesses the format of a file from its name, or returns ""
+// to go by its content.
+func importFormat(name string) string {
+	if base := filepath.Base(name); base == ".env" || strings.HasPrefix(base, ".env.") {
+		return "dotenv"
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".env":
+		return "dotenv"
+	case ".ndjson", ".jsonl":
+		return "ndjson"
+	case ".csv":
+		return "csv"
+	case ".tsv", ".tab":
+		return "tsv"
+	}
+	return ""
+}
+
+// csvColumn finds column in the header row: a 1-based number, or a name
+// in any case.
+func csvColumn(header []string, column string) (int, error) {
+	if n, err := strconv.Atoi(column); err == nil {
+		if n < 1 || n > len(header) {
+			return 0, fmt.Errorf("column %d is not in the header, which has %d", n, len(header))
+		}
+		return n - 1, nil
+	}
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), column) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no column %q in the header (%s), choose one with --key-column and --value-column", column, strings.Join(header, ", "))
+}
+
+// parseCSV reads keys and values from the columns keyColumn and
+// valueColumn of a CSV file, or TSV if comma is a tab, whose first row
+// names the columns. An encoding column, as pb export writes, is honoured.
+// Every row that cannot be read is reported, by its line.
+func parseCSV(input []byte, comma rune, keyColumn, valueColumn string) ([]dumpEntry, error) {
+	r := csv.NewReader(bytes.NewReader(input))
+	r.Comma = comma
+	r.FieldsPerRecord = -1
+	// TSV is rarely quoted, and then not as CSV is
+	r.LazyQuotes = comma == '\t'
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keyIndex, err := csvColumn(header, keyColumn)
+	if err != nil {
+		return nil, err
+	}
+	valueIndex, err := csvColumn(header, valueColumn)
+	if err != nil {
+		return nil, err
+	}
+	encodingIndex, err := csvColumn(header, "encoding")
+	if err != nil {
+		encodingIndex = -1
+	}
+	var entries []dumpEntry
+	var errs []error
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			errs = append(errs, fmt.Errorf("line %d: %v", parseErr.StartLine, parseErr.Err))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := r.FieldPos(0)
+		if len(row) <= max(keyIndex, valueIndex) {
+			errs = append(errs, fmt.Errorf("line %d: has %d columns, too few for the key and value", line, len(row)))
+			continue
+		}
+		e := dumpEntry{record: record{Key: strings.TrimSpace(row[keyIndex]), Value: row[valueIndex]}}
+		if encodingIndex >= 0 && encodingIndex < len(row) {
+			e.Encoding = row[encodingIndex]
+		}
+		if e.Key == "" {
+			errs = append(errs, fmt.Errorf("line %d: the key is empty", line))
+			continue
+		}
+		if _, err := entryValue(e); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %s: %v", line, e.Key, err))
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%d rows cannot be read, nothing was imported:\n%w", len(errs), errors.Join(errs...))
+	}
+	return entries, nil
+}
+
+// dotenvName matches the names of variables in a dotenv file.
+var dotenvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// parseDotenv reads the variables of a dotenv file: NAME=value lines, with
+// an optional export in front, blank lines and # comments. Values may be
+// single-quoted, taken as they are, or double-quoted, with \n, \t, \" and
+// \\ escapes; quoted values may span lines. Adjacent quoted parts are
+// joined, as in sh, so that what pb env writes reads back. Unquoted values
+// end at a # after a space.
+func parseDotenv(input []byte) ([]dumpEntry, error) {
+	s := strings.ReplaceAll(string(input), "\r\n", "\n")
+	var entries []dumpEntry
+	line := 1
+	for s != "" {
+		var text string
+		text, s, _ = strings.Cut(s, "\n")
+		start := line
+		line++
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+		name, value, ok := strings.Cut(text, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected NAME=value", start)
+		}
+		if !dotenvName.MatchString(name) {
+			return nil, fmt.Errorf("line %d: %q is not a variable name", start, name)
+		}
+		value = strings.TrimLeft(value, " \t")
+		if value == "" || (value[0] != '\'' && value[0] != '"') {
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = value[:i]
+			}
+			entries = append(entries, dumpEntry{record: record{Key: name, Value: strings.TrimSpace(value)}})
+			continue
+		}
+		// the value may go on over the following lines
+		rest := value + "\n" + s
+		if s == "" {
+			rest = value
+		}
+		var b strings.Builder
+		for rest != "" && (rest[0] == '\'' || rest[0] == '"' || strings.HasPrefix(rest, `\'`)) {
+			if strings.HasPrefix(rest, `\'`) {
+				b.WriteByte('\'')
+				rest = rest[2:]
+				continue
+			}
+			quote := rest[0]
+			end := -1
+			for i := 1; i < len(rest); i++ {
+				if rest[i] == '\\' && quote == '"' {
+					i++
+					continue
+				}
+				if rest[i] == quote {
+					end = i
+					break
+				}
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: %c is not closed", start, quote)
+			}
+			part := rest[1:end]
+			if quote == '"' {
+				part = dotenvUnescape(part)
+			}
+			b.WriteString(part)
+			line += strings.Count(rest[:end], "\n")
+			rest = rest[end+1:]
+		}
+		text, s, _ = strings.Cut(rest, "\n")
+		if text = strings.TrimSpace(text); text != "" && !strings.HasPrefix(text, "#") {
+			return nil, fmt.Errorf("line %d: unexpected %q after the quoted value of %s", line-1, text, name)
+		}
+		entries = append(entries, dumpEntry{record: record{Key: name, Value: b.String()}})
+	}
+	return entries, nil
+}
+
+// dotenvUnescape replaces the escapes of a double-quoted dotenv value.
+func dotenvUnescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '"', '\\', '$', '`':
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// parseDump reads the entries of a dump from pb export, as one JSON array
+// or as ndjson, or a plain JSON object of keys to values as pb mset reads.
+// With format "" it goes by the content.
+func parseDump(input []byte, format string) ([]dumpEntry, error) {
+	trimmed := bytes.TrimSpace(input)
+	if format == "" {
+		format = "json"
+		if bytes.HasPrefix(trimmed, []byte("{")) {
+			// one object is a plain object, more are ndjson
+			dec := json.NewDecoder(bytes.NewReader(trimmed))
+			var first json.RawMessage
+			if dec.Decode(&first) == nil && dec.More() {
+				format = "ndjson"
+			}
+		}
+	}
+	if format == "ndjson" {
+		var entries []dumpEntry
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		for n := 1; dec.More(); n++ {
+			var e dumpEntry
+			if err := dec.Decode(&e); err != nil {
+				return nil, fmt.Errorf("entry %d: %w", n, err)
+			}
+			entries = append(entries, e)
+		}
+		return entries, nil
+	}
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var entries []dumpEntry
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+	}
+	if !bytes.HasPrefix(trimmed, []byte("{")) {
+		return nil, fmt.Errorf("expected a dump from pb export or a JSON object of keys to values")
+	}
+	kvs, err := parseKeyValues(trimmed)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]dumpEntry, len(kvs))
+	for i, kv := range kvs {
+		entries[i].Key, entries[i].Value = kv.Key, string(kv.Value)
+	}
+	return entries, nil
+}
+
+// entryValue decodes the value of e.
+func entryValue(e dumpEntry) ([]byte, error) {
+	switch e.Encoding {
+	case "":
+		return []byte(e.Value), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(e.Value)
+	}
+	return nil, fmt.Errorf("unknown encoding %q", e.Encoding)
+}
+
+// importEntries writes entries, batch keys per transaction and as many
+// transactions at a time as bulk allows, with their metadata and what is
+// left of their TTLs. Keys that have expired since they were dumped are
+// skipped. It returns how many keys were written, which after an error are
+// those of the batches that were committed.
+func importEntries(entries []dumpEntry, batch int, bulk *bulkFlags) (int, error) {
+	now := time.Now()
+	var written atomic.Int64
+	err := bulk.eachBatch(len(entries), batch, func(start, end int) error {
+		chunk := entries[start:end]
+		n := 0
+		err := inTx(ctx, func(ctx context.Context) error {
+			var kvs []keyValue
+			for _, e := range chunk {
+				if e.ExpiresAt != nil || e.Meta != nil {
+					// written one by one below
+					continue
+				}
+				value, err := entryValue(e)
+				if err != nil {
+					return fmt.Errorf("%s: %w", e.Key, err)
+				}
+				kvs = append(kvs, keyValue{e.Key, value})
+			}
+			if len(kvs) > 0 {
+				if err := putKeyValues(ctx, kvs); err != nil {
+					return err
+				}
+				n += len(kvs)
+			}
+			for _, e := range chunk {
+				if e.ExpiresAt == nil && e.Meta == nil {
+					continue
+				}
+				var ttl time.Duration
+				if e.ExpiresAt != nil {
+					if ttl = e.ExpiresAt.Sub(now); ttl <= 0 {
+						continue
+					}
+				}
+				value, err := entryValue(e)
+				if err != nil {
+					return fmt.Errorf("%s: %w", e.Key, err)
+				}
+				if err := putKeyValueTTL(ctx, e.Key, value, ttl); err != nil {
+					return err
+				}
+				if e.Meta != nil {
+					if err := setMeta(ctx, e.Key, e.Meta); err != nil {
+						return err
+					}
+				}
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		written.Add(int64(n))
+		return nil
+	})
+	return int(written.Load()), err
+}
+
+func importCommand() *gcli.Command {
+	var format, prefix, stripPrefix, fromK8s, k8sNamespace, k8sContext string
+	keyColumn, valueColumn := "key", "value"
+	var dryRun bool
+	var bulk bulkFlags
+	batch := importBatch
+	return &gcli.Command{
+		Name: "import",
+		Desc: "Load keys from a dump of pb export, a JSON object, a CSV or TSV file, or Kubernetes",
+		Help: `Reads a dump made by pb export as JSON or ndjson, or a JSON
+object of keys to values as pb mset does, from a file or stdin:
+
+  pb export --prefix staging/ -o staging.json
+  pb import --strip-prefix staging/ --prefix dev/ staging.json
+
+Metadata is restored, and keys with a TTL get what was left of it; keys
+that have expired since are skipped. Keys are written in transactions of
+--batch keys, --concurrency of them at a time, so an error leaves the
+batches that were committed written.
+
+CSV and TSV files are read by the names in their first row, or by
+number, and are only imported if every row can be:
+
+  pb import --dry-run --key-column Name --value-column Value settings.csv
+
+A dotenv file, by --format dotenv or a name such as .env or prod.env,
+gives a key per variable:
+
+  pb import --format dotenv --prefix app/dev/ .env
+
+With --from-k8s, the keys of a Secret or ConfigMap are read from the
+cluster of the current kubectl context, or of the pod pb runs in, and
+those of a Secret are marked secret:
+
+  pb import --from-k8s secret/app-secrets -N prod --prefix app/prod/`,
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&format, "format", "", "", "The format of the input: "+strings.Join(importFormats, ", ")+"; by default from the file name and content")
+			c.StrOpt(&prefix, "prefix", "", "", "Put this in front of every key")
+			c.StrOpt(&stripPrefix, "strip-prefix", "", "", "Remove this prefix from the keys first")
+			c.IntOpt(&batch, "batch", "", batch, "How many keys to write per transaction")
+			c.StrOpt(&keyColumn, "key-column", "", keyColumn, "With CSV and TSV, the column of the keys, by name or number")
+			c.StrOpt(&valueColumn, "value-column", "", valueColumn, "With CSV and TSV, the column of the values, by name or number")
+			c.StrOpt(&fromK8s, "from-k8s", "", "", "Read the keys of a Kubernetes secret/NAME or configmap/NAME instead of a file")
+			c.StrOpt(&k8sNamespace, "k8s-namespace", "N", "", "With --from-k8s, the Kubernetes namespace, by default that of the context")
+			c.StrOpt(&k8sContext, "k8s-context", "", "", "With --from-k8s, the kubeconfig context, by default the current one")
+			c.BoolOpt(&dryRun, "dry-run", "", false, "Print the keys that would be imported without writing them")
+			bulk.register(c)
+			c.AddArg("file", "The file to read, stdin if none or -", false)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if format != "" && !slices.Contains(importFormats, format) {
+				return fmt.Errorf("unknown format %q, want %s", format, strings.Join(importFormats, ", "))
+			}
+			if batch < 1 {
+				return fmt.Errorf("--batch must be at least 1")
+			}
+			if err := bulk.validate(); err != nil {
+				return err
+			}
+			var input []byte
+			var err error
+			file := c.Arg("file").String()
+			switch {
+			case fromK8s != "":
+				if file != "" {
+					return fmt.Errorf("give --from-k8s or a file, not both")
+				}
+			case file == "" || file == "-":
+				input, err = readAll()
+			default:
+				if format == "" {
+					format = importFormat(file)
+				}
+				input, err = os.ReadFile(file)
+			}
+			if err != nil {
+				return err
+			}
+			var entries []dumpEntry
+			switch {
+			case fromK8s != "":
+				entries, err = k8sEntries(fromK8s, k8sNamespace, k8sContext)
+			case format == "csv":
+				entries, err = parseCSV(input, ',', keyColumn, valueColumn)
+			case format == "tsv":
+				entries, err = parseCSV(input, '\t', keyColumn, valueColumn)
+			case format == "dotenv":
+				entries, err = parseDotenv(input)
+			default:
+				entries, err = parseDump(input, format)
+			}
+			if err != nil {
+				return err
+			}
+			if len(entries) == 0 {
+				return fmt.Errorf("no keys to import")
+			}
+			// a key given twice takes its last value, as in pb mset
+			index := map[string]int{}
+			unique := entries[:0]
+			for i, e := range entries {
+				if e.Key == "" {
+					return fmt.Errorf("entry %d: key is empty", i+1)
+				}
+				e.Key = prefix + strings.TrimPrefix(e.Key, stripPrefix)
+				if j, ok := index[e.Key]; ok {
+					unique[j] = e
+					continue
+				}
+				index[e.Key] = len(unique)
+				unique = append(unique, e)
+			}
+			entries = unique
+			if dryRun {
+				for _, e := range entries {
+					fmt.Printf("import %s\n", e.Key)
+				}
+				fmt.Printf("Would import %d keys\n", len(entries))
+				return nil
+			}
+			if err := connect(); err != nil {
+				return err
+			}
+			n, err := importEntries(entries, batch, &bulk)
+			if err != nil {
+				if n > 0 {
+					err = fmt.Errorf("%w (imported %d keys before)", err, n)
+				}
+				return err
+			}
+			fmt.Printf("Imported %d keys\n", n)
+			if skipped := len(entries) - n; skipped > 0 {
+				fmt.Fprintf(os.Stderr, "Skipped %d keys that have expired\n", skipped)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gookit/gcli/v3"
+)
+
+const defaultInfisicalAPI = "https://app.infisical.com"
+
+// infisicalToken returns INFISICAL_TOKEN or, failing that, logs in with the
+// machine identity in INFISICAL_CLIENT_ID and INFISICAL_CLIENT_SECRET.
+func infisicalToken(api string) (string, error) {
+	if token := os.Getenv("INFISICAL_TOKEN"); token != "" {
+		return token, nil
+	}
+	id, secret := os.Getenv("INFISICAL_CLIENT_ID"), os.Getenv("INFISICAL_CLIENT_SECRET")
+	if id == "" {
+		return "", fmt.Errorf("set INFISICAL_TOKEN, or INFISICAL_CLIENT_ID and INFISICAL_CLIENT_SECRET of a machine identity")
+	}
+	var login struct {
+		AccessToken string `json:"accessToken"`
+	}
+	err := fetchJSON("POST", api+"/api/v1/auth/universal-auth/login", "",
+		map[string]string{"clientId": id, "clientSecret": secret}, &login)
+	return login.AccessToken, err
+}
+
+// infisicalSecrets fetches the secrets of one environment of a project.
+// Infisical has no unmasked secrets, so all are marked secret.
+func infisicalSecrets(api, token, project, env, path string) ([]pulledSecret, error) {
+	var resp struct {
+		Secrets []struct {
+			Key     string `json:"secretKey"`
+			Value   string `json:"secretValue"`
+			Comment string `json:"secretComment"`
+		} `json:"secrets"`
+	}
+	u := api + "/api/v3/secrets/raw?" + url.Values{
+		"workspaceId": {project},
+		"environment": {env},
+		"secretPath":  {path},
+	}.Encode()
+	if err := fetchJSON("GET", u, token, nil, &resp); err != nil {
+		return nil, err
+	}
+	var secrets []pulledSecret
+	for _, s := range resp.Secrets {
+		secrets = append(secrets, pulledSecret{Name: s.Key, Value: s.Value, Secret: true, Note: s.Comment})
+	}
+	return secrets, nil
+}
+
+func infisicalCommand() *gcli.Command {
+	var api, project, env, path, prefix string
+	var dryRun bool
+	return &gcli.Command{
+		Name: "infisical",
+		Desc: "Import secrets from Infisical",
+		Help: `Authenticates with INFISICAL_TOKEN, or with the universal auth machine
+identity in INFISICAL_CLIENT_ID and INFISICAL_CLIENT_SECRET.`,
+		Subs: []*gcli.Command{
+			{
+				Name: "pull",
+				Desc: "Store the secrets of an Infisical environment under a prefix",
+				Config: func(c *gcli.Command) {
+					c.StrOpt(&api, "api", "", defaultInfisicalAPI, "The Infisical server, for self-hosted instances")
+					c.StrOpt(&project, "project", "", os.Getenv("INFISICAL_PROJECT_ID"), "The project id (default $INFISICAL_PROJECT_ID)")
+					c.StrOpt(&env, "env", "e", "prod", "The environment slug")
+					c.StrOpt(&path, "path", "", "/", "The folder to pull")
+					c.StrOpt(&prefix, "prefix", "p", "", "The key prefix to store the secrets under, e.g. app/")
+					c.BoolOpt(&dryRun, "dry-run", "n", false, "Print what would be done without changing anything")
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					if project == "" {
+						return fmt.Errorf("--project is required")
+					}
+					api = strings.TrimSuffix(api, "/")
+					token, err := infisicalToken(api)
+					if err != nil {
+						return err
+					}
+					secrets, err := infisicalSecrets(api, token, project, env, path)
+					if err != nil {
+						return err
+					}
+					return storeSecrets(prefix, secrets, dryRun, false)
+				},
+			},
+		},
+	}
+}
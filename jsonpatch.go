@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// decodeJSON decodes a JSON document, keeping numbers as written.
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("data after the JSON value")
+	}
+	return doc, nil
+}
+
+// setJSONPath returns doc with the value at path replaced, creating the
+// objects leading to it. An array index one past the end appends.
+func setJSONPath(doc any, segments []string, value any) (any, error) {
+	if len(segments) == 0 {
+		return value, nil
+	}
+	seg := segments[0]
+	switch v := doc.(type) {
+	case nil:
+		field, err := setJSONPath(nil, segments[1:], value)
+		return map[string]any{seg: field}, err
+	case map[string]any:
+		field, err := setJSONPath(v[seg], segments[1:], value)
+		v[seg] = field
+		return v, err
+	case []any:
+		n, err := strconv.Atoi(seg)
+		if err != nil || n < 0 || n > len(v) {
+			return nil, fmt.Errorf("%q is not an index of an array of %d", seg, len(v))
+		}
+		if n == len(v) {
+			v = append(v, nil)
+		}
+		v[n], err = setJSONPath(v[n], segments[1:], value)
+		return v, err
+	}
+	return nil, fmt.Errorf("cannot set %s in a %T", seg, doc)
+}
+
+// mergeJSON applies patch to doc as a JSON merge patch (RFC 7386): objects
+// are merged key by key, a null deletes a key and anything else replaces.
+func mergeJSON(doc, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	d, ok := doc.(map[string]any)
+	if !ok {
+		d = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(d, k)
+		} else {
+			d[k] = mergeJSON(d[k], v)
+		}
+	}
+	return d
+}
+
+// patchJSON applies a merge patch, if any, then every path=value of sets
+// to the JSON value of key, which starts as {} if the key does not exist.
+// Values that are not valid JSON are set as strings. The write is
+// conditional on the value read, so concurrent patches are never lost.
+func patchJSON(key string, merge []byte, sets []string, ttl time.Duration) error {
+	// decodes the patch for every attempt, as applying it shares its
+	// objects with the document
+	apply := func(doc any) (any, error) {
+		if merge != nil {
+			patch, err := decodeJSON(merge)
+			if err != nil {
+				return nil, fmt.Errorf("--json-merge: %w", err)
+			}
+			doc = mergeJSON(doc, patch)
+		}
+		for _, set := range sets {
+			path, raw, ok := strings.Cut(set, "=")
+			if !ok {
+				return nil, fmt.Errorf("--json-set %q is not path=value", set)
+			}
+			segments, err := parseJSONPath(path)
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSON([]byte(raw))
+			if err != nil {
+				value = raw
+			}
+			if doc, err = setJSONPath(doc, segments, value); err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+		}
+		return doc, nil
+	}
+	for range counterAttempts {
+		stored, err := store.Get(ctx, namespace+key)
+		var current []byte
+		var doc any = map[string]any{}
+		indent := false
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return err
+		default:
+			if current, err = decryptValue(stored); err != nil {
+				return err
+			}
+			// an empty value is not a missing one
+			current = append([]byte{}, current...)
+			if len(bytes.TrimSpace(current)) == 0 {
+				break
+			}
+			if doc, err = decodeJSON(current); err != nil {
+				return fmt.Errorf("%s is not JSON: %w", key, err)
+			}
+			indent = bytes.Contains(bytes.TrimSpace(current), []byte("\n"))
+		}
+		if doc, err = apply(doc); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if indent {
+			// keeps documents written by hand readable
+			enc.SetIndent("", "  ")
+		}
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		value := buf.Bytes()
+		if !indent {
+			value = bytes.TrimSuffix(value, []byte("\n"))
+		}
+		err = putKeyValueIf(key, value, current, ttl)
+		if !errors.Is(err, errConflict) {
+			return err
+		}
+	}
+	return fmt.Errorf("%s changed %d times while being patched: %w", key, counterAttempts, errConflict)
+}
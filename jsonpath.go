@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseJSONPath splits a path such as .db.hosts[0].name, or db.hosts.0.name
+// as gjson writes it, into object keys and array indexes. A dot that is
+// part of a key is escaped with a backslash.
+func parseJSONPath(path string) ([]string, error) {
+	path = strings.TrimPrefix(path, ".")
+	var segments []string
+	var cur strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; c {
+		case '\\':
+			if i+1 < len(path) {
+				i++
+				cur.WriteByte(path[i])
+			}
+		case '.':
+			segments = append(segments, cur.String())
+			cur.Reset()
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%s: unclosed [", path)
+			}
+			if cur.Len() > 0 {
+				segments = append(segments, cur.String())
+				cur.Reset()
+			}
+			segments = append(segments, path[i+1:i+end])
+			i += end
+			if i+1 < len(path) && path[i+1] == '.' {
+				i++
+			}
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if cur.Len() > 0 || (len(path) > 0 && path[len(path)-1] == '.') {
+		segments = append(segments, cur.String())
+	}
+	return segments, nil
+}
+
+// lookupJSONPath returns the part of the JSON document value at path.
+// Strings are returned without their quotes, other values as compact JSON.
+func lookupJSONPath(key string, value []byte, path string) ([]byte, error) {
+	segments, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := decodeJSON(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not JSON: %w", key, err)
+	}
+	for i, seg := range segments {
+		parent := "the value"
+		if i > 0 {
+			parent = strings.Join(segments[:i], ".")
+		}
+		switch v := doc.(type) {
+		case map[string]any:
+			field, ok := v[seg]
+			if !ok {
+				return nil, fmt.Errorf("%s has no %s: %w", key, strings.Join(segments[:i+1], "."), sql.ErrNoRows)
+			}
+			doc = field
+		case []any:
+			n, err := strconv.Atoi(seg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s is an array, %q is not an index", key, parent, seg)
+			}
+			if n < 0 {
+				n += len(v)
+			}
+			if n < 0 || n >= len(v) {
+				return nil, fmt.Errorf("%s has no %s: %w", key, strings.Join(segments[:i+1], "."), sql.ErrNoRows)
+			}
+			doc = v[n]
+		default:
+			return nil, fmt.Errorf("%s has no %s, %s is not an object or array: %w", key, strings.Join(segments[:i+1], "."), parent, sql.ErrNoRows)
+		}
+	}
+	if s, ok := doc.(string); ok {
+		return []byte(s), nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
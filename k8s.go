@@ -0,0 +1,333 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// serviceAccountDir has the credentials of the pod pb runs in, if any.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeConfig is the part of a kubeconfig file pb understands.
+type kubeConfig struct {
+	CurrentContext string        `yaml:"current-context"`
+	Contexts       []kubeContext `yaml:"contexts"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+			TLSServerName            string `yaml:"tls-server-name"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string    `yaml:"token"`
+			TokenFile             string    `yaml:"tokenFile"`
+			ClientCertificate     string    `yaml:"client-certificate"`
+			ClientCertificateData string    `yaml:"client-certificate-data"`
+			ClientKey             string    `yaml:"client-key"`
+			ClientKeyData         string    `yaml:"client-key-data"`
+			Username              string    `yaml:"username"`
+			Password              string    `yaml:"password"`
+			Exec                  *kubeExec `yaml:"exec"`
+			AuthProvider          any       `yaml:"auth-provider"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+type kubeContext struct {
+	Name    string `yaml:"name"`
+	Context struct {
+		Cluster   string `yaml:"cluster"`
+		User      string `yaml:"user"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"context"`
+}
+
+// kubeExec is a credential plugin, such as aws eks get-token.
+type kubeExec struct {
+	APIVersion string   `yaml:"apiVersion"`
+	Command    string   `yaml:"command"`
+	Args       []string `yaml:"args"`
+	Env        []struct {
+		Name  string `yaml:"name"`
+		Value string `yaml:"value"`
+	} `yaml:"env"`
+}
+
+// credential runs the plugin and returns the token or client certificate
+// it prints.
+func (e *kubeExec) credential() (token string, cert *tls.Certificate, err error) {
+	cmd := exec.CommandContext(ctx, e.Command, e.Args...)
+	cmd.Env = os.Environ()
+	for _, env := range e.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+	info, _ := json.Marshal(map[string]any{"apiVersion": e.APIVersion, "kind": "ExecCredential", "spec": map[string]any{"interactive": false}})
+	cmd.Env = append(cmd.Env, "KUBERNETES_EXEC_INFO="+string(info))
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", e.Command, err)
+	}
+	var cred struct {
+		Status struct {
+			Token                 string `json:"token"`
+			ClientCertificateData string `json:"clientCertificateData"`
+			ClientKeyData         string `json:"clientKeyData"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(out, &cred); err != nil {
+		return "", nil, fmt.Errorf("%s: %w", e.Command, err)
+	}
+	if cred.Status.ClientCertificateData != "" {
+		c, err := tls.X509KeyPair([]byte(cred.Status.ClientCertificateData), []byte(cred.Status.ClientKeyData))
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %w", e.Command, err)
+		}
+		cert = &c
+	}
+	return cred.Status.Token, cert, nil
+}
+
+// k8sCluster is how to reach the API server of a cluster.
+type k8sCluster struct {
+	server    string
+	namespace string
+	token     string
+	username  string
+	password  string
+	client    *http.Client
+}
+
+// kubeConfigPath returns the kubeconfig kubectl would read: the first file
+// in $KUBECONFIG, or ~/.kube/config.
+func kubeConfigPath() string {
+	for _, path := range filepath.SplitList(os.Getenv("KUBECONFIG")) {
+		if path != "" {
+			return path
+		}
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".kube", "config")
+}
+
+// kubeFile reads a file named in a kubeconfig, relative to it, or decodes
+// the base64 data given instead.
+func kubeFile(dir, path, data string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if path == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return os.ReadFile(path)
+}
+
+// loadK8sCluster reads the cluster and credentials of context, or of the
+// current context, from the kubeconfig. Without a kubeconfig, inside a
+// pod, it uses the pod's service account as kubectl does.
+func loadK8sCluster(context string) (*k8sCluster, error) {
+	path := kubeConfigPath()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return inClusterK8s()
+	}
+	if err != nil {
+		return nil, err
+	}
+	var kc kubeConfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if context == "" {
+		context = kc.CurrentContext
+	}
+	if context == "" {
+		return nil, fmt.Errorf("%s has no current-context, choose one with --k8s-context", path)
+	}
+	i := slices.IndexFunc(kc.Contexts, func(c kubeContext) bool { return c.Name == context })
+	if i < 0 {
+		return nil, fmt.Errorf("%s has no context %q", path, context)
+	}
+	kctx := kc.Contexts[i].Context
+	c := &k8sCluster{namespace: kctx.Namespace}
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	dir := filepath.Dir(path)
+	found := false
+	for _, cl := range kc.Clusters {
+		if cl.Name != kctx.Cluster {
+			continue
+		}
+		found = true
+		c.server = strings.TrimSuffix(cl.Cluster.Server, "/")
+		tlsCfg.InsecureSkipVerify = cl.Cluster.InsecureSkipTLSVerify
+		tlsCfg.ServerName = cl.Cluster.TLSServerName
+		ca, err := kubeFile(dir, cl.Cluster.CertificateAuthority, cl.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", cl.Name, err)
+		}
+		if ca != nil {
+			tlsCfg.RootCAs = x509.NewCertPool()
+			if !tlsCfg.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("cluster %s: no certificates in its certificate-authority", cl.Name)
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%s has no cluster %q, of context %s", path, kctx.Cluster, context)
+	}
+	for _, u := range kc.Users {
+		if u.Name != kctx.User {
+			continue
+		}
+		if u.User.AuthProvider != nil {
+			return nil, fmt.Errorf("user %s: auth-provider is not supported, use an exec plugin", u.Name)
+		}
+		c.token, c.username, c.password = u.User.Token, u.User.Username, u.User.Password
+		if u.User.TokenFile != "" {
+			token, err := kubeFile(dir, u.User.TokenFile, "")
+			if err != nil {
+				return nil, fmt.Errorf("user %s: %w", u.Name, err)
+			}
+			c.token = strings.TrimSpace(string(token))
+		}
+		cert, err := kubeFile(dir, u.User.ClientCertificate, u.User.ClientCertificateData)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", u.Name, err)
+		}
+		key, err := kubeFile(dir, u.User.ClientKey, u.User.ClientKeyData)
+		if err != nil {
+			return nil, fmt.Errorf("user %s: %w", u.Name, err)
+		}
+		if cert != nil {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, fmt.Errorf("user %s: %w", u.Name, err)
+			}
+			tlsCfg.Certificates = []tls.Certificate{pair}
+		}
+		if u.User.Exec != nil {
+			token, pair, err := u.User.Exec.credential()
+			if err != nil {
+				return nil, fmt.Errorf("user %s: %w", u.Name, err)
+			}
+			if token != "" {
+				c.token = token
+			}
+			if pair != nil {
+				tlsCfg.Certificates = []tls.Certificate{*pair}
+			}
+		}
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsCfg
+	c.client = &http.Client{Transport: t}
+	return c, nil
+}
+
+// inClusterK8s reaches the cluster pb runs in with its service account.
+func inClusterK8s() (*k8sCluster, error) {
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, err
+	}
+	pool, err := loadCAFile("ca.crt", filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	namespace, _ := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &k8sCluster{
+		server:    "https://" + os.Getenv("KUBERNETES_SERVICE_HOST") + ":" + os.Getenv("KUBERNETES_SERVICE_PORT"),
+		namespace: strings.TrimSpace(string(namespace)),
+		token:     strings.TrimSpace(string(token)),
+		client:    &http.Client{Transport: t},
+	}, nil
+}
+
+// get decodes the object at path of the core API into out.
+func (c *k8sCluster) get(path string, out any) error {
+	req, err := newJSONRequest("GET", c.server+"/api/v1/"+path, c.token, nil)
+	if err != nil {
+		return err
+	}
+	if c.token == "" && c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return doJSONWith(c.client, req, out)
+}
+
+// k8sEntries reads the keys of the Secret or ConfigMap named by object,
+// as in kubectl: secret/NAME or configmap/NAME, in the Kubernetes
+// namespace given or else that of the context. Values that are not UTF-8
+// text are base64 encoded, and those of a Secret marked secret.
+func k8sEntries(object, k8sNamespace, k8sContext string) ([]dumpEntry, error) {
+	kind, name, ok := strings.Cut(object, "/")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("--from-k8s wants secret/NAME or configmap/NAME, not %q", object)
+	}
+	var resource string
+	switch strings.ToLower(kind) {
+	case "secret", "secrets":
+		resource = "secrets"
+	case "configmap", "configmaps", "cm":
+		resource = "configmaps"
+	default:
+		return nil, fmt.Errorf("--from-k8s reads a secret or a configmap, not a %s", kind)
+	}
+	c, err := loadK8sCluster(k8sContext)
+	if err != nil {
+		return nil, err
+	}
+	if k8sNamespace == "" {
+		k8sNamespace = c.namespace
+	}
+	if k8sNamespace == "" {
+		k8sNamespace = "default"
+	}
+	var obj struct {
+		Data       map[string]string `json:"data"`
+		BinaryData map[string]string `json:"binaryData"`
+	}
+	if err := c.get("namespaces/"+url.PathEscape(k8sNamespace)+"/"+resource+"/"+url.PathEscape(name), &obj); err != nil {
+		return nil, err
+	}
+	var entries []dumpEntry
+	for _, key := range slices.Sorted(maps.Keys(obj.Data)) {
+		if resource == "configmaps" {
+			entries = append(entries, dumpEntry{record: newRecord(key, []byte(obj.Data[key]))})
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(obj.Data[key])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		entries = append(entries, dumpEntry{record: newRecord(key, value), Meta: map[string]string{"type": "secret"}})
+	}
+	for _, key := range slices.Sorted(maps.Keys(obj.BinaryData)) {
+		entries = append(entries, dumpEntry{record: record{Key: key, Value: obj.BinaryData[key], Encoding: "base64"}})
+	}
+	return entries, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/zalando/go-keyring"
+)
+
+// keychainService is the service the passwords of DSNs are filed under in
+// the OS keychain: the macOS Keychain, the Secret Service on Linux or the
+// Windows Credential Manager.
+const keychainService = "postboard"
+
+// splitDSNPassword returns dsn without its password, and the password.
+func splitDSNPassword(driver, dsn string) (string, string, error) {
+	switch driver {
+	case "mysql":
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return "", "", err
+		}
+		password := cfg.Passwd
+		cfg.Passwd = ""
+		return cfg.FormatDSN(), password, nil
+	case "postgres", "redis":
+		u, err := url.Parse(dsn)
+		if err != nil || u.Scheme == "" {
+			return "", "", fmt.Errorf("the keychain needs a %s DSN written as a URL", driver)
+		}
+		password, _ := u.User.Password()
+		if u.User != nil && u.User.Username() != "" {
+			u.User = url.User(u.User.Username())
+		} else {
+			u.User = nil
+		}
+		return u.String(), password, nil
+	}
+	return "", "", fmt.Errorf("the %s driver has no password to keep in the keychain", driver)
+}
+
+// joinDSNPassword puts password back into a DSN split by splitDSNPassword.
+func joinDSNPassword(driver, dsn, password string) (string, error) {
+	if driver == "mysql" {
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return "", err
+		}
+		cfg.Passwd = password
+		return cfg.FormatDSN(), nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", err
+	}
+	user := ""
+	if u.User != nil {
+		user = u.User.Username()
+	}
+	u.User = url.UserPassword(user, password)
+	return u.String(), nil
+}
+
+// storeKeychainPassword moves the password of cfg.DSN to the keychain,
+// filed under the DSN without it, and returns that DSN.
+func storeKeychainPassword(cfg *Config) (string, error) {
+	dsn, password, err := splitDSNPassword(cfg.Driver, cfg.DSN)
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", errors.New("the DSN has no password to keep in the keychain")
+	}
+	if err := keyring.Set(keychainService, dsn, password); err != nil {
+		return "", fmt.Errorf("keychain: %w", err)
+	}
+	return dsn, nil
+}
+
+// keychainDSN returns cfg.DSN with the password kept in the keychain for
+// it, see Config.Keychain.
+func keychainDSN(cfg *Config) (string, error) {
+	switch cfg.Driver {
+	case "mysql", "postgres", "redis":
+	default:
+		return "", fieldErrorf("Keychain", "the %s driver has no password to keep in the keychain", cfg.Driver)
+	}
+	password, err := keyring.Get(keychainService, cfg.DSN)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return "", fieldErrorf("Keychain", "no password for %s in the keychain, run `pb config --keychain` to save it", cfg.DSN)
+	}
+	if err != nil {
+		return "", fmt.Errorf("keychain: %w", err)
+	}
+	return joinDSNPassword(cfg.Driver, cfg.DSN, password)
+}
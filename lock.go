@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// lockPrefix is where pb lock keeps its locks, one key per lock holding
+// the identity of its holder and expiring with the lock.
+const lockPrefix = "locks/"
+
+// lockRetry is how often a blocked pb lock tries again.
+const lockRetry = 500 * time.Millisecond
+
+// newHolder names the holder of a lock taken without --holder, unique to
+// this run of pb so that two runs by the same user do not both hold it.
+func newHolder() string {
+	return fmt.Sprintf("%s:%d:%s", defaultUser(), os.Getpid(), randomCode(8))
+}
+
+// isHolder reports whether the stored value of a lock names holder.
+func isHolder(stored []byte, holder string) (bool, error) {
+	current, err := decryptValue(stored)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(current, []byte(holder)), nil
+}
+
+// heldBy describes the holder of a lock that could not be taken or
+// released.
+func heldBy(name string) error {
+	holder, err := getKey(ctx, lockPrefix+name)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("lock %s is not held: %w", name, err)
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("lock %s is held by %s: %w", name, holder, errConflict)
+}
+
+// acquireLock takes the lock for holder, for ttl, trying until timeout. A
+// lock already held by holder is taken again, which extends it.
+func acquireLock(name, holder string, ttl, timeout time.Duration) error {
+	s, ok := store.(conditionalStore)
+	if !ok {
+		return fmt.Errorf("the %s driver does not support locks", sqlDriver)
+	}
+	key := lockPrefix + name
+	value, err := storedValue(key, []byte(holder))
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		taken, err := s.PutIf(ctx, namespace+key, value, ttl, func(current []byte) (bool, error) {
+			if current == nil {
+				return true, nil
+			}
+			return isHolder(current, holder)
+		})
+		cacheDelete(key)
+		if err != nil || taken {
+			return err
+		}
+		if time.Now().Add(lockRetry).After(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockRetry):
+		}
+	}
+	err = heldBy(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		// released since the last attempt
+		return fmt.Errorf("lock %s is busy: %w", name, errConflict)
+	}
+	return err
+}
+
+// releaseLock releases the lock if holder holds it, or whoever does if
+// force is set.
+func releaseLock(name, holder string, force bool) error {
+	s, ok := store.(conditionalStore)
+	if !ok {
+		return fmt.Errorf("the %s driver does not support locks", sqlDriver)
+	}
+	key := lockPrefix + name
+	released, err := s.DeleteIf(ctx, namespace+key, func(current []byte) (bool, error) {
+		if force {
+			return true, nil
+		}
+		return isHolder(current, holder)
+	})
+	cacheDelete(key)
+	if err != nil || released {
+		return err
+	}
+	return heldBy(name)
+}
+
+// runLocked runs argv while holding the lock, extending it every third of
+// ttl so that it outlives the command however long it runs, and releases
+// it when the command exits.
+func runLocked(name, holder string, ttl time.Duration, argv []string) error {
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		if err := releaseLock(name, holder, false); err != nil {
+			log.Printf("cannot release lock %s: %v", name, err)
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(ttl / 3):
+			}
+			if err := acquireLock(name, holder, ttl, 0); err != nil {
+				log.Printf("cannot extend lock %s: %v", name, err)
+			}
+		}
+	}()
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		childExitCode = exitErr.ExitCode()
+		return nil
+	}
+	return err
+}
+
+func lockCommand() *gcli.Command {
+	ttl := Duration{5 * time.Minute}
+	var timeout Duration
+	var holder string
+	var execute bool
+	return &gcli.Command{
+		Name: "lock",
+		Desc: "Take a named lock shared by everyone using the board",
+		Help: `  holder=$(pb lock --timeout 1m deploy) && ./deploy.sh; pb unlock --holder "$holder" deploy
+  pb lock --exec deploy -- ./deploy.sh
+
+Without --exec, pb lock prints the holder, which pb unlock needs. Unless
+--holder is given it is new for every run, user@host:pid:random, so the
+same user cannot take the lock twice.
+
+The lock expires after --ttl unless taken again by the same holder, which
+--exec does while the command runs, releasing it when the command exits.
+pb exits with 6 if the lock is held by someone else.`,
+		Config: func(c *gcli.Command) {
+			c.VarOpt(&ttl, "ttl", "", "Release the lock after this long if it is not taken again, default 5m")
+			c.VarOpt(&timeout, "timeout", "t", "Wait this long for the lock instead of failing at once")
+			c.StrOpt(&holder, "holder", "", "", "Who holds the lock, to take it again or pb unlock it (default new for this run)")
+			c.BoolOpt(&execute, "exec", "e", false, "Run the command after the lock name while holding the lock")
+			c.AddArg("name", "The name of the lock", true)
+			c.AddArg("command", "With --exec, the command to run and its arguments", false, true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if ttl.Duration <= 0 {
+				return fmt.Errorf("--ttl must be positive")
+			}
+			argv := c.Arg("command").Strings()
+			if len(argv) > 0 && argv[0] == "--" {
+				argv = argv[1:]
+			}
+			if execute != (len(argv) > 0) {
+				return fmt.Errorf("--exec needs a command to run, and a command needs --exec")
+			}
+			if err := connect(); err != nil {
+				return err
+			}
+			if holder == "" {
+				holder = newHolder()
+			}
+			name := c.Arg("name").String()
+			if err := acquireLock(name, holder, ttl.Duration, timeout.Duration); err != nil {
+				return err
+			}
+			if execute {
+				return runLocked(name, holder, ttl.Duration, argv)
+			}
+			fmt.Println(holder)
+			return nil
+		},
+	}
+}
+
+func unlockCommand() *gcli.Command {
+	var holder string
+	var force bool
+	return &gcli.Command{
+		Name: "unlock",
+		Desc: "Release a lock taken with pb lock",
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&holder, "holder", "", "", "Who holds the lock, as printed by pb lock")
+			c.BoolOpt(&force, "force", "f", false, "Release the lock whoever holds it")
+			c.AddArg("name", "The name of the lock", true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if holder == "" && !force {
+				return fmt.Errorf("--holder is required, as printed by pb lock, unless --force is given")
+			}
+			if err := connect(); err != nil {
+				return err
+			}
+			return releaseLock(c.Arg("name").String(), holder, force)
+		},
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// lsOrders are the columns pb ls sorts by, each falling back to the key.
+// Values over chunkSize sort by the size of their reference, as that is
+// what the key/value table holds.
+var lsOrders = map[string]string{
+	"key":     "k",
+	"size":    "LENGTH(v), k",
+	"created": "created_at, k",
+	"updated": "updated_at, k",
+}
+
+// listingStore is implemented by stores that list keys with their stat,
+// sorted by the database.
+type listingStore interface {
+	// ListStat returns up to limit keys starting with prefix, sorted by
+	// one of lsOrders, descending if reverse is set. When sorted by key,
+	// only the keys sorting after after are listed.
+	ListStat(ctx context.Context, prefix, after, order string, reverse bool, limit int) ([]keyStat, error)
+}
+
+func (sqlStore) ListStat(ctx context.Context, prefix, after, order string, reverse bool, limit int) ([]keyStat, error) {
+	where, args := hasPrefix("k"), []any{likePrefix(prefix)}
+	if order == "key" && after != "" {
+		if reverse {
+			where += " AND k < ?"
+		} else {
+			where += " AND k > ?"
+		}
+		args = append(args, after)
+	}
+	orderBy := lsOrders[order]
+	if reverse {
+		orderBy = strings.ReplaceAll(orderBy, ",", " DESC,") + " DESC"
+	}
+	rows, err := queryerFor(ctx).QueryContext(ctx, "SELECT "+statColumns+" FROM "+kvTable+" WHERE "+where+" AND "+notExpired()+" ORDER BY "+orderBy+" LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanStats(ctx, rows)
+}
+
+// listStats is ListStat for any store. Stores that do not keep when keys
+// were written have their values read for their size, and can only be
+// sorted by key or size.
+func listStats(prefix, after, order string, reverse bool, limit int) ([]keyStat, error) {
+	if after != "" {
+		after = namespace + after
+	}
+	if s, ok := store.(listingStore); ok {
+		stats, err := s.ListStat(ctx, namespace+prefix, after, order, reverse, limit)
+		for i := range stats {
+			stats[i].Key = stats[i].Key[len(namespace):]
+		}
+		return stats, err
+	}
+	if order == "created" || order == "updated" {
+		return nil, fmt.Errorf("the %s driver does not keep when keys were written", sqlDriver)
+	}
+	// the whole prefix, as the first keys by size are not the first listed
+	keys, err := store.List(ctx, namespace+prefix, "", 1<<31-1)
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]keyStat, 0, len(keys))
+	for _, key := range keys {
+		value, err := store.Get(ctx, key)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, keyStat{Key: key[len(namespace):], Size: len(value)})
+	}
+	if after != "" {
+		after = after[len(namespace):]
+		stats = slices.DeleteFunc(stats, func(st keyStat) bool {
+			return st.Key == after || (st.Key < after) != reverse
+		})
+	}
+	slices.SortFunc(stats, func(a, b keyStat) int {
+		c := 0
+		if order == "size" {
+			c = a.Size - b.Size
+		}
+		if c == 0 {
+			c = strings.Compare(a.Key, b.Key)
+		}
+		if reverse {
+			c = -c
+		}
+		return c
+	})
+	return stats[:min(limit, len(stats))], nil
+}
+
+// lsEntry is a key as pb ls and pb stat print it.
+type lsEntry struct {
+	Key       string     `json:"key" yaml:"key"`
+	Size      int        `json:"size" yaml:"size"`
+	CreatedAt *time.Time `json:"created_at,omitempty" yaml:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty" yaml:"updated_at,omitempty"`
+	UpdatedBy string     `json:"updated_by,omitempty" yaml:"updated_by,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+}
+
+func newLsEntry(st keyStat) lsEntry {
+	e := lsEntry{Key: st.Key, Size: st.Size, UpdatedBy: st.UpdatedBy, ExpiresAt: st.ExpiresAt}
+	if !st.UpdatedAt.IsZero() {
+		e.CreatedAt, e.UpdatedAt = &st.CreatedAt, &st.UpdatedAt
+	}
+	return e
+}
+
+// printEntries prints entries in format, which is structured. one prints a
+// single entry as itself rather than as a list.
+func printEntries(format outputFormat, entries []lsEntry, one bool) error {
+	switch {
+	case format != formatTable && one && len(entries) == 1:
+		return printStructured(format, entries[0])
+	case format != formatTable:
+		return printStructured(format, entries)
+	}
+	rows := make([][]string, len(entries))
+	for i, e := range entries {
+		by := e.UpdatedBy
+		if by == "" {
+			by = "-"
+		}
+		rows[i] = []string{e.Key, strconv.Itoa(e.Size), formatTime(e.CreatedAt), formatTime(e.UpdatedAt), by, formatTime(e.ExpiresAt)}
+	}
+	return printTable([]string{"KEY", "SIZE", "CREATED", "UPDATED", "BY", "EXPIRES"}, rows)
+}
+
+func lsCommand() *gcli.Command {
+	var prefix, after, order string
+	var reverse, all bool
+	var limit int
+	return &gcli.Command{
+		Name: "ls",
+		Desc: "List keys with their size and when they were written",
+		Help: `Sizes are of the values as stored, after compression and encryption.
+
+  pb ls --prefix app/prod/ --sort updated --reverse --limit 10
+
+Sorted by key, the next page starts --after the last key listed.`,
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&prefix, "prefix", "p", "", "Only list keys starting with this")
+			c.StrOpt(&order, "sort", "s", "key", "Sort by key, size, created or updated")
+			c.BoolOpt(&reverse, "reverse", "r", false, "Sort in descending order")
+			c.IntOpt(&limit, "limit", "n", listPage, "List at most this many keys")
+			c.StrOpt(&after, "after", "", "", "With --sort key, list the keys after this one")
+			c.BoolOpt(&all, "all", "a", false, "List every key however many there are")
+			formatOpt(c, "the keys", formatTable)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if _, ok := lsOrders[order]; !ok {
+				return fmt.Errorf("--sort must be key, size, created or updated")
+			}
+			if limit <= 0 {
+				return fmt.Errorf("--limit must be positive")
+			}
+			if after != "" && order != "key" {
+				return fmt.Errorf("--after needs --sort key")
+			}
+			if err := connect(); err != nil {
+				return err
+			}
+			page := limit
+			if all {
+				// other orders cannot be resumed from a key
+				page = listPage
+				if order != "key" {
+					page = 1<<31 - 1
+				}
+			}
+			var stats []keyStat
+			for {
+				list, err := listStats(prefix, after, order, reverse, page)
+				if err != nil {
+					return err
+				}
+				stats = append(stats, list...)
+				if !all || len(list) < page {
+					break
+				}
+				after = list[len(list)-1].Key
+			}
+			if !all && len(stats) == limit {
+				hint := "--all"
+				if order == "key" {
+					hint = "--after " + stats[len(stats)-1].Key + " or --all"
+				}
+				fmt.Fprintf(os.Stderr, "Showing the first %d keys, see %s\n", limit, hint)
+			}
+			format := commandFormat(formatTable)
+			if !format.structured() {
+				for _, st := range stats {
+					fmt.Println(st.Key)
+				}
+				return nil
+			}
+			entries := make([]lsEntry, len(stats))
+			for i, st := range stats {
+				entries[i] = newLsEntry(st)
+			}
+			return printEntries(format, entries, false)
+		},
+	}
+}
+
+func statCommand() *gcli.Command {
+	return &gcli.Command{
+		Name: "stat",
+		Desc: "Show the size of a key and when and by whom it was written",
+		Config: func(c *gcli.Command) {
+			formatOpt(c, "the key", formatText)
+			c.AddArg("key", "The key to show", true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			s, ok := store.(statStore)
+			if !ok {
+				return fmt.Errorf("the %s driver does not keep when keys were written", sqlDriver)
+			}
+			key := c.Arg("key").String()
+			stats, err := s.Stat(ctx, []string{namespace + key})
+			if err != nil {
+				return err
+			}
+			st, ok := stats[namespace+key]
+			if !ok {
+				return fmt.Errorf("%s: %w", key, sql.ErrNoRows)
+			}
+			st.Key = key
+			if format := commandFormat(formatText); format.structured() {
+				return printEntries(format, []lsEntry{newLsEntry(st)}, true)
+			}
+			updated := fmt.Sprintf("%s (%s ago)", st.UpdatedAt.Local().Format(time.DateTime), time.Since(st.UpdatedAt).Round(time.Second))
+			if st.UpdatedBy != "" {
+				updated += " by " + st.UpdatedBy
+			}
+			expires := "never"
+			if st.ExpiresAt != nil {
+				expires = fmt.Sprintf("%s (in %s)", st.ExpiresAt.Local().Format(time.DateTime), time.Until(*st.ExpiresAt).Round(time.Second))
+			}
+			fmt.Printf("Key:      %s\n", key)
+			fmt.Printf("Size:     %d bytes as stored\n", st.Size)
+			fmt.Printf("Created:  %s\n", st.CreatedAt.Local().Format(time.DateTime))
+			fmt.Printf("Updated:  %s\n", updated)
+			fmt.Printf("Expires:  %s\n", expires)
+			return nil
+		},
+	}
+}
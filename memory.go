@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// isMemoryDSN reports whether dsn is memory://, which selects the memory
+// driver when Driver is not set.
+func isMemoryDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, "memory://")
+}
+
+// memoryStore keeps keys in a map that is lost when pb exits. It backs the
+// memory driver, for trying pb out and for tests, and --dry-run.
+type memoryStore struct {
+	mu   sync.Mutex
+	kv   map[string][]byte
+	meta map[string]map[string]string
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{kv: map[string][]byte{}, meta: map[string]map[string]string{}}
+}
+
+func (s *memoryStore) Put(ctx context.Context, key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kv[key] = slices.Clone(value)
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.kv[key]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return slices.Clone(value), nil
+}
+
+func (s *memoryStore) List(ctx context.Context, prefix, after string, limit int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.kv {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys[:min(len(keys), limit)], nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.kv[key]
+	delete(s.kv, key)
+	return ok, nil
+}
+
+func (s *memoryStore) SetMeta(ctx context.Context, key string, fields map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.meta[key] == nil {
+		s.meta[key] = map[string]string{}
+	}
+	for name, v := range fields {
+		s.meta[key][name] = v
+	}
+	return nil
+}
+
+func (s *memoryStore) GetMeta(ctx context.Context, key string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fields := map[string]string{}
+	for name, v := range s.meta[key] {
+		fields[name] = v
+	}
+	return fields, nil
+}
+
+func (s *memoryStore) DeleteMeta(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.meta, key)
+	return nil
+}
+
+func (s *memoryStore) IncrMeta(ctx context.Context, key, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.meta[key] == nil {
+		s.meta[key] = map[string]string{}
+	}
+	var n int64
+	fmt.Sscan(s.meta[key][name], &n)
+	s.meta[key][name] = fmt.Sprint(n + 1)
+	return nil
+}
+
+func (s *memoryStore) PutIf(ctx context.Context, key string, value []byte, ttl time.Duration, match func(current []byte) (bool, error)) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.kv[key]
+	if ok {
+		// an empty value is not a missing one
+		current = append([]byte{}, current...)
+	}
+	if ok, err := match(current); !ok || err != nil {
+		return false, err
+	}
+	s.kv[key] = slices.Clone(value)
+	return true, nil
+}
+
+func (s *memoryStore) DeleteIf(ctx context.Context, key string, match func(current []byte) (bool, error)) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.kv[key]
+	if !ok {
+		return false, nil
+	}
+	if ok, err := match(append([]byte{}, current...)); !ok || err != nil {
+		return false, err
+	}
+	delete(s.kv, key)
+	return true, nil
+}
+
+func (s *memoryStore) Append(ctx context.Context, key string, data []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.kv[key]
+	if !ok || len(current) < len(encodedPrefix) || bytes.HasPrefix(current, encodedPrefix) {
+		return false, nil
+	}
+	s.kv[key] = append(slices.Clone(current), data...)
+	return true, nil
+}
+
+func (s *memoryStore) Copy(ctx context.Context, from, to string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.kv[from]
+	if !ok {
+		return false, nil
+	}
+	s.kv[to] = slices.Clone(value)
+	delete(s.meta, to)
+	if fields := s.meta[from]; fields != nil {
+		s.meta[to] = maps.Clone(fields)
+	}
+	return true, nil
+}
+
+// dryRunWrites is set by the global --dry-run flag.
+var dryRunWrites bool
+
+// dryRunStore sends writes to memory and reads from there first, falling
+// through to the real store, so that a command behaves as if its writes
+// had happened without changing the board. Deleted keys are remembered so
+// they stop showing up from the real store.
+type dryRunStore struct {
+	mem      *memoryStore
+	store    Store
+	meta     MetaStore
+	mu       sync.Mutex
+	deleted  map[string]bool
+	metaGone map[string]bool
+	// cas makes the conditional writes atomic, as mu is taken by Get and
+	// Put themselves
+	cas sync.Mutex
+}
+
+func newDryRunStore(store Store, meta MetaStore) *dryRunStore {
+	return &dryRunStore{mem: newMemoryStore(), store: store, meta: meta, deleted: map[string]bool{}, metaGone: map[string]bool{}}
+}
+
+// report tells the user about a write that was not made.
+func (s *dryRunStore) report(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "dry run: would "+format+"\n", args...)
+}
+
+func (s *dryRunStore) Put(ctx context.Context, key string, value []byte) error {
+	s.report("set %s (%d bytes)", key, len(value))
+	s.mu.Lock()
+	delete(s.deleted, key)
+	s.mu.Unlock()
+	return s.mem.Put(ctx, key, value)
+}
+
+func (s *dryRunStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if value, err := s.mem.Get(ctx, key); err != sql.ErrNoRows {
+		return value, err
+	}
+	s.mu.Lock()
+	deleted := s.deleted[key]
+	s.mu.Unlock()
+	if deleted {
+		return nil, sql.ErrNoRows
+	}
+	return s.store.Get(ctx, key)
+}
+
+func (s *dryRunStore) List(ctx context.Context, prefix, after string, limit int) ([]string, error) {
+	s.mu.Lock()
+	deleted := len(s.deleted)
+	s.mu.Unlock()
+	keys, err := s.store.List(ctx, prefix, after, limit+deleted)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	keys = slices.DeleteFunc(keys, func(k string) bool { return s.deleted[k] })
+	s.mu.Unlock()
+	added, _ := s.mem.List(ctx, prefix, after, limit)
+	keys = append(keys, added...)
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+	return keys[:min(len(keys), limit)], nil
+}
+
+func (s *dryRunStore) Delete(ctx context.Context, key string) (bool, error) {
+	_, err := s.Get(ctx, key)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	s.report("delete %s", key)
+	s.mem.Delete(ctx, key)
+	s.mu.Lock()
+	s.deleted[key] = true
+	s.mu.Unlock()
+	return true, nil
+}
+
+func (s *dryRunStore) SetMeta(ctx context.Context, key string, fields map[string]string) error {
+	return s.mem.SetMeta(ctx, key, fields)
+}
+
+func (s *dryRunStore) GetMeta(ctx context.Context, key string) (map[string]string, error) {
+	s.mu.Lock()
+	gone := s.metaGone[key]
+	s.mu.Unlock()
+	fields := map[string]string{}
+	if !gone {
+		var err error
+		if fields, err = s.meta.GetMeta(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+	changed, _ := s.mem.GetMeta(ctx, key)
+	for name, v := range changed {
+		fields[name] = v
+	}
+	return fields, nil
+}
+
+func (s *dryRunStore) DeleteMeta(ctx context.Context, key string) error {
+	s.mu.Lock()
+	s.metaGone[key] = true
+	s.mu.Unlock()
+	return s.mem.DeleteMeta(ctx, key)
+}
+
+func (s *dryRunStore) IncrMeta(ctx context.Context, key, name string) error {
+	fields, err := s.GetMeta(ctx, key)
+	if err != nil {
+		return err
+	}
+	var n int64
+	fmt.Sscan(fields[name], &n)
+	return s.mem.SetMeta(ctx, key, map[string]string{name: fmt.Sprint(n + 1)})
+}
+
+func (s *dryRunStore) PutWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.Put(ctx, key, value)
+}
+
+// PutIf and the methods below let the commands that need them run with
+// --dry-run, against the writes staged so far.
+func (s *dryRunStore) PutIf(ctx context.Context, key string, value []byte, ttl time.Duration, match func(current []byte) (bool, error)) (bool, error) {
+	s.cas.Lock()
+	defer s.cas.Unlock()
+	current, err := s.Get(ctx, key)
+	if err == sql.ErrNoRows {
+		current = nil
+	} else if err != nil {
+		return false, err
+	} else {
+		// an empty value is not a missing one
+		current = append([]byte{}, current...)
+	}
+	if ok, err := match(current); !ok || err != nil {
+		return false, err
+	}
+	return true, s.Put(ctx, key, value)
+}
+
+func (s *dryRunStore) DeleteIf(ctx context.Context, key string, match func(current []byte) (bool, error)) (bool, error) {
+	s.cas.Lock()
+	defer s.cas.Unlock()
+	current, err := s.Get(ctx, key)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if ok, err := match(append([]byte{}, current...)); !ok || err != nil {
+		return false, err
+	}
+	return s.Delete(ctx, key)
+}
+
+func (s *dryRunStore) Append(ctx context.Context, key string, data []byte) (bool, error) {
+	s.cas.Lock()
+	defer s.cas.Unlock()
+	current, err := s.Get(ctx, key)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	if err == sql.ErrNoRows || len(current) < len(encodedPrefix) || bytes.HasPrefix(current, encodedPrefix) {
+		return false, nil
+	}
+	return true, s.Put(ctx, key, append(append([]byte{}, current...), data...))
+}
+
+func (s *dryRunStore) Copy(ctx context.Context, from, to string) (bool, error) {
+	value, err := s.Get(ctx, from)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	fields, err := s.GetMeta(ctx, from)
+	if err != nil {
+		return false, err
+	}
+	if err := s.Put(ctx, to, value); err != nil {
+		return false, err
+	}
+	if err := s.DeleteMeta(ctx, to); err != nil {
+		return false, err
+	}
+	return true, s.SetMeta(ctx, to, fields)
+}
+
+func (s *dryRunStore) PutMany(ctx context.Context, kvs []keyValue) error {
+	for _, kv := range kvs {
+		if err := s.Put(ctx, kv.Key, kv.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
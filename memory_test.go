@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	for _, key := range []string{"app/b", "app/a", "db/x"} {
+		if err := s.Put(ctx, key, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, err := s.Get(ctx, "app/a"); err != nil || string(v) != "app/a" {
+		t.Errorf("Get(app/a) = %q, %v", v, err)
+	}
+	if _, err := s.Get(ctx, "nope"); err != sql.ErrNoRows {
+		t.Errorf("Get(nope) error = %v, want sql.ErrNoRows", err)
+	}
+
+	keys, err := s.List(ctx, "app/", "", 10)
+	if err != nil || !slices.Equal(keys, []string{"app/a", "app/b"}) {
+		t.Errorf("List(app/) = %q, %v", keys, err)
+	}
+	if keys, _ := s.List(ctx, "app/", "app/a", 10); !slices.Equal(keys, []string{"app/b"}) {
+		t.Errorf("List(app/, after app/a) = %q", keys)
+	}
+	if keys, _ := s.List(ctx, "", "", 2); len(keys) != 2 {
+		t.Errorf("List with limit 2 = %q", keys)
+	}
+
+	if deleted, err := s.Delete(ctx, "app/a"); !deleted || err != nil {
+		t.Errorf("Delete(app/a) = %v, %v", deleted, err)
+	}
+	if deleted, _ := s.Delete(ctx, "app/a"); deleted {
+		t.Error("Delete(app/a) again reported a deletion")
+	}
+
+	if err := s.SetMeta(ctx, "db/x", map[string]string{"type": "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	s.IncrMeta(ctx, "db/x", "reads")
+	s.IncrMeta(ctx, "db/x", "reads")
+	fields, _ := s.GetMeta(ctx, "db/x")
+	if fields["type"] != "secret" || fields["reads"] != "2" {
+		t.Errorf("GetMeta(db/x) = %v", fields)
+	}
+	s.DeleteMeta(ctx, "db/x")
+	if fields, _ := s.GetMeta(ctx, "db/x"); len(fields) != 0 {
+		t.Errorf("GetMeta after DeleteMeta = %v", fields)
+	}
+}
+
+func TestMemoryStoreCopiesValues(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	value := []byte("abc")
+	s.Put(ctx, "k", value)
+	value[0] = 'x'
+	got, _ := s.Get(ctx, "k")
+	got[1] = 'y'
+	if got, _ := s.Get(ctx, "k"); string(got) != "abc" {
+		t.Errorf("Get(k) = %q after changing the slices given and returned", got)
+	}
+}
+
+// newTestDryRun returns a dry-run wrapper over a memory store holding
+// app/a=1 with a type, and app/b=2.
+func newTestDryRun(t *testing.T) (*dryRunStore, *memoryStore) {
+	t.Helper()
+	ctx := context.Background()
+	board := newMemoryStore()
+	board.Put(ctx, "app/a", []byte("1"))
+	board.Put(ctx, "app/b", []byte("2"))
+	board.SetMeta(ctx, "app/a", map[string]string{"type": "plain"})
+	return newDryRunStore(board, board), board
+}
+
+func TestMemoryStoreConditional(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore()
+	isAbsent := func(current []byte) (bool, error) { return current == nil, nil }
+
+	if written, err := s.PutIf(ctx, "locks/x", []byte("me"), 0, isAbsent); !written || err != nil {
+		t.Errorf("PutIf(locks/x, absent) = %v, %v", written, err)
+	}
+	if written, _ := s.PutIf(ctx, "locks/x", []byte("you"), 0, isAbsent); written {
+		t.Error("PutIf(locks/x, absent) wrote over the value")
+	}
+	s.Put(ctx, "empty", nil)
+	if written, _ := s.PutIf(ctx, "empty", []byte("x"), 0, isAbsent); written {
+		t.Error("PutIf(empty, absent) took an empty value for a missing one")
+	}
+	isMe := func(current []byte) (bool, error) { return string(current) == "me", nil }
+	if deleted, err := s.DeleteIf(ctx, "locks/x", isMe); !deleted || err != nil {
+		t.Errorf("DeleteIf(locks/x, me) = %v, %v", deleted, err)
+	}
+	if deleted, _ := s.DeleteIf(ctx, "locks/x", isMe); deleted {
+		t.Error("DeleteIf(locks/x) deleted it twice")
+	}
+
+	s.Put(ctx, "log", []byte("one"))
+	if appended, err := s.Append(ctx, "log", []byte(" two")); !appended || err != nil {
+		t.Errorf("Append(log) = %v, %v", appended, err)
+	}
+	if v, _ := s.Get(ctx, "log"); string(v) != "one two" {
+		t.Errorf("Get(log) = %q", v)
+	}
+	s.Put(ctx, "packed", []byte("pb:raw:x"))
+	if appended, _ := s.Append(ctx, "packed", []byte("y")); appended {
+		t.Error("Append(packed) appended to an encoded value")
+	}
+
+	s.SetMeta(ctx, "log", map[string]string{"type": "plain"})
+	if found, err := s.Copy(ctx, "log", "log2"); !found || err != nil {
+		t.Errorf("Copy(log) = %v, %v", found, err)
+	}
+	if v, _ := s.Get(ctx, "log2"); string(v) != "one two" {
+		t.Errorf("Get(log2) = %q", v)
+	}
+	if fields, _ := s.GetMeta(ctx, "log2"); fields["type"] != "plain" {
+		t.Errorf("GetMeta(log2) = %v", fields)
+	}
+	if found, _ := s.Copy(ctx, "nope", "log2"); found {
+		t.Error("Copy(nope) found it")
+	}
+}
+
+func TestDryRunStore(t *testing.T) {
+	ctx := context.Background()
+	s, board := newTestDryRun(t)
+
+	if err := s.Put(ctx, "app/c", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := s.Get(ctx, "app/c"); err != nil || string(v) != "3" {
+		t.Errorf("Get(app/c) = %q, %v", v, err)
+	}
+	if _, err := board.Get(ctx, "app/c"); err != sql.ErrNoRows {
+		t.Error("Put reached the board")
+	}
+
+	if deleted, err := s.Delete(ctx, "app/a"); !deleted || err != nil {
+		t.Errorf("Delete(app/a) = %v, %v", deleted, err)
+	}
+	if _, err := s.Get(ctx, "app/a"); err != sql.ErrNoRows {
+		t.Errorf("Get(app/a) after Delete error = %v", err)
+	}
+	if _, err := board.Get(ctx, "app/a"); err != nil {
+		t.Error("Delete reached the board")
+	}
+	if deleted, _ := s.Delete(ctx, "app/nope"); deleted {
+		t.Error("Delete(app/nope) reported a deletion")
+	}
+
+	keys, err := s.List(ctx, "app/", "", 10)
+	if err != nil || !slices.Equal(keys, []string{"app/b", "app/c"}) {
+		t.Errorf("List(app/) = %q, %v", keys, err)
+	}
+
+	s.IncrMeta(ctx, "app/b", "reads")
+	if fields, _ := s.GetMeta(ctx, "app/b"); fields["reads"] != "1" {
+		t.Errorf("GetMeta(app/b) = %v", fields)
+	}
+	if fields, _ := board.GetMeta(ctx, "app/b"); len(fields) != 0 {
+		t.Error("IncrMeta reached the board")
+	}
+}
+
+func TestDryRunStoreConditional(t *testing.T) {
+	ctx := context.Background()
+	s, board := newTestDryRun(t)
+	isAbsent := func(current []byte) (bool, error) { return current == nil, nil }
+
+	if written, err := s.PutIf(ctx, "app/a", []byte("x"), 0, isAbsent); written || err != nil {
+		t.Errorf("PutIf(app/a, absent) = %v, %v", written, err)
+	}
+	if written, err := s.PutIf(ctx, "locks/x", []byte("me"), 0, isAbsent); !written || err != nil {
+		t.Errorf("PutIf(locks/x, absent) = %v, %v", written, err)
+	}
+	if written, _ := s.PutIf(ctx, "locks/x", []byte("you"), 0, isAbsent); written {
+		t.Error("PutIf(locks/x, absent) wrote over the staged value")
+	}
+	isMe := func(current []byte) (bool, error) { return string(current) == "me", nil }
+	if deleted, err := s.DeleteIf(ctx, "locks/x", isMe); !deleted || err != nil {
+		t.Errorf("DeleteIf(locks/x, me) = %v, %v", deleted, err)
+	}
+	if deleted, _ := s.DeleteIf(ctx, "locks/x", isMe); deleted {
+		t.Error("DeleteIf(locks/x) deleted it twice")
+	}
+	if _, err := board.Get(ctx, "locks/x"); err != sql.ErrNoRows {
+		t.Error("PutIf reached the board")
+	}
+}
+
+func TestDryRunStoreAppendCopyPutMany(t *testing.T) {
+	ctx := context.Background()
+	s, board := newTestDryRun(t)
+
+	s.Put(ctx, "log", []byte("one"))
+	if appended, err := s.Append(ctx, "log", []byte(" two")); !appended || err != nil {
+		t.Errorf("Append(log) = %v, %v", appended, err)
+	}
+	if v, _ := s.Get(ctx, "log"); string(v) != "one two" {
+		t.Errorf("Get(log) = %q", v)
+	}
+	// too short to tell it will not start with pb:, left to appendValue
+	if appended, _ := s.Append(ctx, "app/a", []byte("b:z")); appended {
+		t.Error("Append(app/a) appended to a value shorter than pb:")
+	}
+	if appended, _ := s.Append(ctx, "nope", []byte("x")); appended {
+		t.Error("Append(nope) appended to a missing key")
+	}
+
+	if found, err := s.Copy(ctx, "app/a", "app/copy"); !found || err != nil {
+		t.Errorf("Copy(app/a) = %v, %v", found, err)
+	}
+	if v, _ := s.Get(ctx, "app/copy"); string(v) != "1" {
+		t.Errorf("Get(app/copy) = %q", v)
+	}
+	if fields, _ := s.GetMeta(ctx, "app/copy"); fields["type"] != "plain" {
+		t.Errorf("GetMeta(app/copy) = %v", fields)
+	}
+	if found, _ := s.Copy(ctx, "nope", "app/copy"); found {
+		t.Error("Copy(nope) found it")
+	}
+
+	if err := s.PutMany(ctx, []keyValue{{"m/1", []byte("1")}, {"m/2", []byte("2")}}); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _ := s.List(ctx, "m/", "", 10); !slices.Equal(keys, []string{"m/1", "m/2"}) {
+		t.Errorf("List(m/) = %q", keys)
+	}
+	if keys, _ := board.List(ctx, "", "", 10); !slices.Equal(keys, []string{"app/a", "app/b"}) {
+		t.Errorf("the board has %q, want it unchanged", keys)
+	}
+}
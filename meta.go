@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// metaTable holds metadata: name/value strings attached to a key, such as
+// where a value was fetched from. Metadata is removed with its key but is
+// never part of the value itself.
+func metaTable() string {
+	return kvTable + "_meta"
+}
+
+// setMeta sets the given metadata fields of key, leaving others alone.
+func setMeta(ctx context.Context, key string, fields map[string]string) (err error) {
+	ctx, span := tracer.Start(ctx, "setMeta", trace.WithAttributes(attribute.String("pb.key", key)))
+	defer func() { endSpan(span, err) }()
+
+	return metaStore.SetMeta(ctx, namespace+key, fields)
+}
+
+// getMeta returns all metadata of key, empty if it has none.
+func getMeta(ctx context.Context, key string) (fields map[string]string, err error) {
+	ctx, span := tracer.Start(ctx, "getMeta", trace.WithAttributes(attribute.String("pb.key", key)))
+	defer func() { endSpan(span, err) }()
+
+	return metaStore.GetMeta(ctx, namespace+key)
+}
+
+func deleteMeta(ctx context.Context, key string) error {
+	return metaStore.DeleteMeta(ctx, namespace+key)
+}
+
+// incrMeta atomically adds one to a counter kept in the metadata of key,
+// starting from 1 if it is not set yet.
+func incrMeta(ctx context.Context, key, name string) (err error) {
+	ctx, span := tracer.Start(ctx, "incrMeta", trace.WithAttributes(attribute.String("pb.key", key)))
+	defer func() { endSpan(span, err) }()
+
+	return metaStore.IncrMeta(ctx, namespace+key, name)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// defaultPushInterval is how often long-running commands push metrics.
+const defaultPushInterval = time.Minute
+
+// MetricsConfig has pb push its metrics to a Prometheus Pushgateway, for
+// the commands that do not serve /metrics as pb serve does: once when the
+// command exits, and every PushInterval while it runs, as pb watch does.
+//
+//	"Metrics": {"Pushgateway": "http://pushgateway:9091"}
+type MetricsConfig struct {
+	Pushgateway string `json:"Pushgateway"`
+	// Job labels the metrics pushed. Defaults to pb.
+	Job string `json:"Job,omitempty"`
+	// PushInterval defaults to a minute.
+	PushInterval Duration `json:"PushInterval,omitzero"`
+}
+
+func (m *MetricsConfig) validate(field string) error {
+	u, err := url.Parse(m.Pushgateway)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fieldErrorf(field+".Pushgateway", "must be an http:// or https:// URL")
+	}
+	if m.PushInterval.Duration < 0 {
+		return fieldErrorf(field+".PushInterval", "must not be negative")
+	}
+	return nil
+}
+
+// metricsRegistry holds the metrics of pb, as served at /metrics and
+// pushed.
+var metricsRegistry = newMetricsRegistry()
+
+func newMetricsRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return r
+}
+
+var (
+	storeOps = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "pb_store_operations_total",
+		Help: "Reads, writes, lists and deletes of keys, by result: ok, not_found or error.",
+	}, []string{"op", "result"})
+	storeOpSeconds = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pb_store_operation_duration_seconds",
+		Help:    "How long reads, writes, lists and deletes of keys took.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"op"})
+	valueBytes = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pb_value_size_bytes",
+		Help:    "The size of the values read and written, before encryption.",
+		Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+	}, []string{"op"})
+	httpRequests = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "pb_http_requests_total",
+		Help: "The HTTP requests pb serve answered, by the route they matched.",
+	}, []string{"method", "route", "code"})
+	httpSeconds = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pb_http_request_duration_seconds",
+		Help:    "How long pb serve took to answer HTTP requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	grpcRequests = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "pb_grpc_requests_total",
+		Help: "The gRPC calls pb serve answered.",
+	}, []string{"method", "code"})
+	grpcSeconds = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pb_grpc_request_duration_seconds",
+		Help:    "How long pb serve took to answer gRPC calls, to the end of the stream for Watch.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+// observeOp records an operation on the store started at start.
+func observeOp(op string, start time.Time, err error) {
+	result := "ok"
+	if errors.Is(err, sql.ErrNoRows) {
+		result = "not_found"
+	} else if err != nil {
+		result = "error"
+	}
+	storeOps.WithLabelValues(op, result).Inc()
+	storeOpSeconds.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
+// setupMetrics adds the connection pool to the metrics and pushes them if
+// the config says where. It runs once the database is open.
+func setupMetrics(cfg *Config) {
+	if db != nil {
+		// fails if connected before, leaving the stats of that pool
+		metricsRegistry.Register(collectors.NewDBStatsCollector(db, kvTable))
+	}
+	if cfg.Metrics == nil {
+		return
+	}
+	job := cfg.Metrics.Job
+	if job == "" {
+		job = "pb"
+	}
+	pusher := push.New(cfg.Metrics.Pushgateway, job).Gatherer(metricsRegistry)
+	if host, err := os.Hostname(); err == nil {
+		pusher = pusher.Grouping("instance", host)
+	}
+	interval := cfg.Metrics.PushInterval.Duration
+	if interval == 0 {
+		interval = defaultPushInterval
+	}
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if err := pusher.PushContext(ctx); err != nil {
+					log.Printf("pushing metrics: %v", err)
+				}
+			}
+		}
+	}()
+	onExit(func() {
+		close(done)
+		// don't let an unreachable gateway hang the CLI
+		pushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := pusher.PushContext(pushCtx); err != nil {
+			log.Printf("pushing metrics: %v", err)
+		}
+	})
+}
+
+// keysCollector counts the keys on the board when scraped.
+type keysCollector struct{}
+
+var keysDesc = prometheus.NewDesc("pb_keys", "The keys on the board, in the namespace of pb serve.", nil, nil)
+
+func (keysCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- keysDesc
+}
+
+func (keysCollector) Collect(ch chan<- prometheus.Metric) {
+	n, err := countKeys()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(keysDesc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(keysDesc, prometheus.GaugeValue, float64(n))
+}
+
+// countKeys counts the keys in the namespace, in the database if it is
+// SQL and by listing them otherwise.
+func countKeys() (int, error) {
+	if db == nil {
+		keys, err := listKeysWithPrefix(ctx, "")
+		return len(keys), err
+	}
+	var n int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+kvTable+" WHERE "+hasPrefix("k")+" AND "+notExpired(), likePrefix(namespace)).Scan(&n)
+	return n, err
+}
+
+// registerMetrics serves the metrics at /metrics, to the same clients as
+// the API.
+func registerMetrics(mux *http.ServeMux) {
+	metricsRegistry.MustRegister(keysCollector{})
+	mux.HandleFunc("GET /metrics", withPrincipal(promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}).ServeHTTP))
+}
+
+// statusRecorder keeps the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// instrumented counts and times the requests to h.
+func instrumented(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		// set by the mux; requests it did not route, such as those rate
+		// limited, have none, and any method
+		method, route := r.Method, r.Pattern
+		if route == "" {
+			method, route = "", "unmatched"
+		}
+		httpRequests.WithLabelValues(method, route, strconv.Itoa(rec.code)).Inc()
+		httpSeconds.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+func grpcMetrics(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeGRPC(info.FullMethod, start, err)
+	return resp, err
+}
+
+func grpcStreamMetrics(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, ss)
+	observeGRPC(info.FullMethod, start, err)
+	return err
+}
+
+func observeGRPC(method string, start time.Time, err error) {
+	grpcRequests.WithLabelValues(method, status.Code(err).String()).Inc()
+	grpcSeconds.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// multiGetStore is implemented by stores that read many keys in one round
+// trip.
+type multiGetStore interface {
+	// GetMany returns the values of the keys that exist.
+	GetMany(ctx context.Context, keys []string) (map[string][]byte, error)
+}
+
+func (sqlStore) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	values := map[string][]byte{}
+	for batch := range slices.Chunk(keys, msetBatch) {
+		args := make([]any, len(batch))
+		for i, key := range batch {
+			args[i] = key
+		}
+		rows, err := queryerFor(ctx).QueryContext(ctx, "SELECT k, v FROM "+kvTable+" WHERE k IN (?"+strings.Repeat(", ?", len(batch)-1)+") AND "+notExpired(), args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var key string
+			var value []byte
+			if err := rows.Scan(&key, &value); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			values[key] = value
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	for key, value := range values {
+		value, err := readChunks(ctx, queryerFor(ctx), value)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// getKeys is getKey for many keys, reading those not cached in one round
+// trip where the store allows. Missing keys are left out.
+func getKeys(ctx context.Context, keys []string) (map[string][]byte, error) {
+	values := map[string][]byte{}
+	var missing []string
+	for _, key := range keys {
+		if value, ok := cacheGet(ctx, key); ok {
+			values[key] = value
+		} else {
+			missing = append(missing, namespace+key)
+		}
+	}
+	if s, ok := store.(multiGetStore); ok && len(missing) > 0 {
+		fetched, err := s.GetMany(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range fetched {
+			key = key[len(namespace):]
+			values[key] = value
+			cachePut(ctx, key, value)
+		}
+	} else {
+		for _, key := range missing {
+			value, err := store.Get(ctx, key)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			key = key[len(namespace):]
+			values[key] = value
+			cachePut(ctx, key, value)
+		}
+	}
+	for key, value := range values {
+		value, err := decryptValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if err := maskSecret(key, value); err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func mgetCommand() *gcli.Command {
+	return &gcli.Command{
+		Name: "mget",
+		Desc: "Get many keys at once",
+		Help: `Prints key=value lines, the values alone with --format raw, or records
+with --format json, yaml or table, in the order of the arguments. Keys
+that do not exist are left out and pb exits with 3 after printing the
+others.`,
+		Config: func(c *gcli.Command) {
+			formatOpt(c, "the keys", formatText)
+			c.AddArg("keys", "The keys to get", true, true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			keys := c.Arg("keys").Strings()
+			values, err := getKeys(ctx, keys)
+			if err != nil {
+				return err
+			}
+			var missing []string
+			for _, key := range keys {
+				if _, ok := values[key]; !ok && !slices.Contains(missing, key) {
+					missing = append(missing, key)
+				}
+			}
+			format := commandFormat(formatText)
+			if format.structured() {
+				records, err := keyRecords(keys, values)
+				if err != nil {
+					return err
+				}
+				if err := printRecords(format, records, false); err != nil {
+					return err
+				}
+			} else {
+				printed := map[string]bool{}
+				for _, key := range keys {
+					if value, ok := values[key]; ok && !printed[key] {
+						printed[key] = true
+						if format == formatRaw {
+							fmt.Printf("%s\n", value)
+						} else {
+							fmt.Printf("%s=%s\n", key, value)
+						}
+					}
+				}
+			}
+			if len(missing) > 0 {
+				return fmt.Errorf("%s: %w", strings.Join(missing, ", "), sql.ErrNoRows)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gookit/gcli/v3"
+)
+
+const (
+	// mirrorBatch is how many rows are copied per query.
+	mirrorBatch = 500
+	// mirrorOverlap is how far before the last change seen each poll
+	// starts reading again, to catch writes that committed late with an
+	// earlier timestamp. Re-applying a change is a no-op.
+	mirrorOverlap = 5 * time.Second
+	// mirrorReconcileEvery is how many polls pass between scans for keys
+	// deleted from the source, which the changefeed cannot see.
+	mirrorReconcileEvery = 30
+)
+
+// mirror copies changes from one board's table to another's. Values are
+// copied as stored, so encrypted values stay encrypted.
+type mirror struct {
+	from, to *sql.DB
+	// pattern is the likePrefix of the keys to copy, namespace included
+	pattern string
+	// since is the source timestamp of the newest change applied
+	since time.Time
+	bulk  *bulkFlags
+}
+
+// endpointConfig returns the config for --from or --to: a profile of the
+// config file if one has that name, else the current config with its DSN
+// replaced.
+func endpointConfig(base *Config, s string) (*Config, error) {
+	cfg := *base
+	if p, ok := base.Profiles[s]; ok {
+		cfg.overlay(p)
+		if cfg.Keychain && p.DSN != "" {
+			var err error
+			if cfg.DSN, err = keychainDSN(&cfg); err != nil {
+				return nil, err
+			}
+		}
+		return &cfg, nil
+	}
+	// a DSN given on the command line is complete by itself
+	cfg.DSN, cfg.Auth, cfg.CAFile, cfg.Discovery = s, "", "", ""
+	cfg.Keychain = false
+	if err := cfg.validateFields(""); err != nil {
+		return nil, fmt.Errorf("%q is neither a profile nor a valid DSN: %w", s, err)
+	}
+	return &cfg, nil
+}
+
+// endpointName describes an endpoint in logs without its password.
+func endpointName(s string, cfg *Config) string {
+	if s != cfg.DSN {
+		return s
+	}
+	dsn, _ := mysql.ParseDSN(cfg.DSN)
+	return dsn.Addr
+}
+
+// openBoard opens and migrates the database of cfg. Sessions use UTC so
+// that write times compare correctly between servers in different zones.
+func openBoard(cfg *Config) (*sql.DB, error) {
+	dsn, err := mysql.ParseDSN(cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	dsn.ParseTime, dsn.Loc = true, time.UTC
+	if dsn.Params == nil {
+		dsn.Params = map[string]string{}
+	}
+	dsn.Params["time_zone"] = "'+00:00'"
+	utc := *cfg
+	utc.DSN = dsn.FormatDSN()
+	conn, err := openDatabase(&utc)
+	if err != nil {
+		return nil, err
+	}
+	onExit(func() { conn.Close() })
+	// prepareDatabase works on the global connection
+	db = conn
+	return conn, prepareDatabase()
+}
+
+// change is a row read from the source.
+type change struct {
+	k       string
+	v       []byte
+	at      time.Time
+	by      sql.NullString
+	expires sql.NullTime
+}
+
+// poll applies the changes made since the last poll and returns how many
+// rows changed on the target and the replication lag.
+func (m *mirror) poll() (applied int, lag time.Duration, err error) {
+	var now time.Time
+	if err := m.from.QueryRowContext(ctx, "SELECT NOW(6)").Scan(&now); err != nil {
+		return 0, 0, err
+	}
+	cursor, cursorKey := m.since.Add(-mirrorOverlap), ""
+	if m.since.IsZero() {
+		cursor = time.Time{}
+	}
+	upsert := `INSERT INTO ` + kvTable + ` (k, v, updated_at, updated_by, expires_at) VALUES (?, ?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE
+  v = IF(VALUES(updated_at) > updated_at, VALUES(v), v),
+  updated_by = IF(VALUES(updated_at) > updated_at, VALUES(updated_by), updated_by),
+  expires_at = IF(VALUES(updated_at) > updated_at, VALUES(expires_at), expires_at),
+  updated_at = GREATEST(updated_at, VALUES(updated_at));`
+	for {
+		rows, err := m.from.QueryContext(ctx, `SELECT k, v, updated_at, updated_by, expires_at FROM `+kvTable+`
+WHERE `+hasPrefix("k")+` AND (updated_at, k) > (?, ?) ORDER BY updated_at, k LIMIT ?`,
+			m.pattern, cursor, cursorKey, mirrorBatch)
+		if err != nil {
+			return applied, 0, err
+		}
+		var batch []change
+		for rows.Next() {
+			var c change
+			if err := rows.Scan(&c.k, &c.v, &c.at, &c.by, &c.expires); err != nil {
+				rows.Close()
+				return applied, 0, err
+			}
+			batch = append(batch, c)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return applied, 0, err
+		}
+		// last-write-wins makes the order changes are applied in irrelevant
+		var mu sync.Mutex
+		err = m.bulk.each(len(batch), func(i int) error {
+			if err := copyChunks(ctx, m.from, m.to, batch[i].v); err != nil {
+				return err
+			}
+			res, err := m.to.ExecContext(ctx, upsert, batch[i].k, batch[i].v, batch[i].at, batch[i].by, batch[i].expires)
+			if err != nil {
+				return err
+			}
+			// 0 when the target already had this or a newer write
+			if affected, _ := res.RowsAffected(); affected > 0 {
+				mu.Lock()
+				applied++
+				mu.Unlock()
+			}
+			return nil
+		})
+		if err != nil {
+			return applied, 0, err
+		}
+		n := len(batch)
+		if n > 0 {
+			cursor, cursorKey = batch[n-1].at, batch[n-1].k
+			if cursor.After(m.since) {
+				m.since = cursor
+			}
+		}
+		if n < mirrorBatch {
+			break
+		}
+		// report progress while copying a large backlog
+		log.Printf("copied up to %s, lag %s", cursor.Format(time.RFC3339), now.Sub(cursor).Round(time.Millisecond))
+	}
+	if applied > 0 {
+		lag = now.Sub(m.since)
+	}
+	return applied, max(lag, 0), nil
+}
+
+// reconcile deletes keys from the target that no longer exist on the
+// source, unless the target has a write newer than the scan.
+func (m *mirror) reconcile() (deleted int, err error) {
+	var start time.Time
+	if err := m.from.QueryRowContext(ctx, "SELECT NOW(6)").Scan(&start); err != nil {
+		return 0, err
+	}
+	source := map[string]bool{}
+	rows, err := m.from.QueryContext(ctx, "SELECT k FROM "+kvTable+" WHERE "+hasPrefix("k"), m.pattern)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		source[k] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	rows, err = m.to.QueryContext(ctx, "SELECT k FROM "+kvTable+" WHERE "+hasPrefix("k")+" AND updated_at < ?", m.pattern, start)
+	if err != nil {
+		return 0, err
+	}
+	var gone []string
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !source[k] {
+			gone = append(gone, k)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, k := range gone {
+		if _, err := m.to.ExecContext(ctx, "DELETE FROM "+kvTable+" WHERE k = ? AND updated_at < ?", k, start); err != nil {
+			return deleted, err
+		}
+		if _, err := m.to.ExecContext(ctx, "DELETE FROM "+metaTable()+" WHERE k = ?", k); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+func (m *mirror) run(interval time.Duration, once bool) error {
+	for poll := 0; ; poll++ {
+		applied, lag, err := m.poll()
+		if err != nil {
+			return err
+		}
+		deleted := 0
+		if once || poll%mirrorReconcileEvery == 0 {
+			if deleted, err = m.reconcile(); err != nil {
+				return err
+			}
+		}
+		if applied > 0 || deleted > 0 {
+			log.Printf("applied %d changes, deleted %d keys, lag %s", applied, deleted, lag.Round(time.Millisecond))
+		}
+		if once {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+func mirrorCommand() *gcli.Command {
+	var from, to, prefix string
+	var once bool
+	var bulk bulkFlags
+	interval := Duration{time.Second}
+	return &gcli.Command{
+		Name: "mirror",
+		Desc: "Continuously replicate keys from one database to another",
+		Help: `--from and --to are profile names from the config file or DSNs. Both
+use the table and namespace of the current config.
+
+Changes are found by their write time and copied with last-write-wins, so
+a key written on both sides keeps the newest value. Keys deleted from the
+source are removed from the target every ` + fmt.Sprint(mirrorReconcileEvery) + ` polls. Metadata
+is not copied. Runs until interrupted unless --once is given.`,
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&from, "from", "", "", "The profile or DSN to copy from")
+			c.StrOpt(&to, "to", "", "", "The profile or DSN to copy to")
+			c.StrOpt(&prefix, "prefix", "p", "*", "The keys to copy, e.g. app/*")
+			c.VarOpt(&interval, "interval", "", "How often to poll the source for changes")
+			c.BoolOpt(&once, "once", "", false, "Copy everything once and exit, e.g. to migrate providers")
+			bulk.register(c)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if from == "" || to == "" {
+				return fmt.Errorf("--from and --to are required")
+			}
+			if err := bulk.validate(); err != nil {
+				return err
+			}
+			base, err := loadConfig(configFilePath)
+			if err != nil {
+				return err
+			}
+			fromCfg, err := endpointConfig(base, from)
+			if err != nil {
+				return err
+			}
+			toCfg, err := endpointConfig(base, to)
+			if err != nil {
+				return err
+			}
+			if fromCfg.Driver != "mysql" || toCfg.Driver != "mysql" {
+				return fmt.Errorf("pb mirror only supports the mysql driver")
+			}
+			if toCfg.ReadOnly || readOnlyFlag {
+				return fmt.Errorf("--to: %w", errReadOnly)
+			}
+			if fromCfg.DSN == toCfg.DSN {
+				return fmt.Errorf("--from and --to are the same database")
+			}
+			kvTable, namespace = base.Table, base.Namespace
+			m := &mirror{pattern: likePrefix(namespace + strings.TrimSuffix(prefix, "*")), bulk: &bulk}
+			if m.from, err = openBoard(fromCfg); err != nil {
+				return fmt.Errorf("--from: %w", err)
+			}
+			if m.to, err = openBoard(toCfg); err != nil {
+				return fmt.Errorf("--to: %w", err)
+			}
+			log.Printf("mirroring %s from %s to %s", prefix, endpointName(from, fromCfg), endpointName(to, toCfg))
+			return m.run(interval.Duration, once)
+		},
+	}
+}
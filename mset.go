@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// msetBatch is the most rows pb mset writes with one INSERT.
+const msetBatch = 100
+
+// keyValue is one key and its value as stored.
+type keyValue struct {
+	Key   string
+	Value []byte
+}
+
+// batchStore is implemented by stores that write many keys at once faster
+// than one at a time.
+type batchStore interface {
+	PutMany(ctx context.Context, kvs []keyValue) error
+}
+
+func (s sqlStore) PutMany(ctx context.Context, kvs []keyValue) error {
+	return inTx(ctx, func(ctx context.Context) error {
+		for batch := range slices.Chunk(kvs, msetBatch) {
+			args := make([]any, 0, 3*len(batch))
+			for _, kv := range batch {
+				value := kv.Value
+				if len(value) > chunkSize {
+					var err error
+					if value, err = putChunks(ctx, queryerFor(ctx), value); err != nil {
+						return err
+					}
+				}
+				if err := s.auditPut(ctx, kv.Key, value); err != nil {
+					return err
+				}
+				if err := s.archive(ctx, kv.Key, value); err != nil {
+					return err
+				}
+				args = append(args, kv.Key, value, updatedBy)
+			}
+			row := "(?, ?, " + currentTimestamp() + ", ?, NULL)"
+			_, err := queryerFor(ctx).ExecContext(ctx, `INSERT INTO `+kvTable+` (k, v, updated_at, updated_by, expires_at) VALUES `+
+				strings.Repeat(row+", ", len(batch)-1)+row+
+				onConflict("k", "v = "+inserted("v")+", updated_at = "+currentTimestamp()+", updated_by = "+inserted("updated_by")+", expires_at = NULL"), args...)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// parseKeyValues reads the input of pb mset: a JSON object, or key=value
+// lines where blank lines and lines starting with # are skipped. A key
+// given twice takes its last value.
+func parseKeyValues(input []byte) ([]keyValue, error) {
+	var kvs []keyValue
+	index := map[string]int{}
+	add := func(key string, value []byte) error {
+		if key == "" {
+			return fmt.Errorf("key is empty")
+		}
+		if i, ok := index[key]; ok {
+			kvs[i].Value = value
+			return nil
+		}
+		index[key] = len(kvs)
+		kvs = append(kvs, keyValue{key, value})
+		return nil
+	}
+	if trimmed := bytes.TrimSpace(input); bytes.HasPrefix(trimmed, []byte("{")) {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &obj); err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			// strings are stored as is, anything else as JSON
+			value := []byte(obj[key])
+			var s string
+			if json.Unmarshal(obj[key], &s) == nil {
+				value = []byte(s)
+			}
+			if err := add(key, value); err != nil {
+				return nil, err
+			}
+		}
+		return kvs, nil
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	scanner.Buffer(nil, int(maxValueSize()))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key=value", n)
+		}
+		if err := add(strings.TrimSpace(key), []byte(value)); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	return kvs, scanner.Err()
+}
+
+// putKeyValues writes every key in one transaction, with multi-row
+// inserts where the store has them.
+func putKeyValues(ctx context.Context, kvs []keyValue) error {
+	stored := make([]keyValue, len(kvs))
+	for i, kv := range kvs {
+		value, err := storedValue(kv.Key, kv.Value)
+		if err != nil {
+			return err
+		}
+		stored[i] = keyValue{namespace + kv.Key, value}
+	}
+	err := inTx(ctx, func(ctx context.Context) error {
+		if s, ok := store.(batchStore); ok {
+			return s.PutMany(ctx, stored)
+		}
+		for _, kv := range stored {
+			if err := store.Put(ctx, kv.Key, kv.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i, kv := range kvs {
+		cachePut(ctx, kv.Key, stored[i].Value)
+	}
+	return nil
+}
+
+func msetCommand() *gcli.Command {
+	return &gcli.Command{
+		Name: "mset",
+		Desc: "Set many keys from stdin in one transaction",
+		Help: `Reads key=value lines, or a JSON object of keys to values, from stdin:
+
+  printf 'app/host=db1\napp/port=5432\n' | pb mset
+  echo '{"app/host": "db1", "app/port": 5432}' | pb mset
+
+Values are everything after the first =, to the end of the line. Either
+every key is written or, on an error, none is.`,
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			input, err := readAll()
+			if err != nil {
+				return err
+			}
+			kvs, err := parseKeyValues(input)
+			if err != nil {
+				return err
+			}
+			if len(kvs) == 0 {
+				return fmt.Errorf("no keys on stdin")
+			}
+			if err := putKeyValues(ctx, kvs); err != nil {
+				return err
+			}
+			fmt.Printf("Set %d keys\n", len(kvs))
+			return nil
+		},
+	}
+}
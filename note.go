@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/gookit/gcli/v3"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"golang.org/x/term"
+)
+
+// noteMarkdown converts notes to HTML. Raw HTML in notes is not passed
+// through, so a note cannot inject scripts into the page.
+var noteMarkdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+var notePage = template.Must(template.New("note").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Key}}</title>
+<style>
+body { max-width: 48em; margin: 2em auto; padding: 0 1em; font-family: sans-serif; line-height: 1.5; }
+pre { background: #f4f4f4; padding: 1em; overflow-x: auto; }
+code { background: #f4f4f4; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: .3em .6em; }
+</style>
+</head>
+<body>
+{{.Body}}
+</body>
+</html>
+`))
+
+// renderNote renders Markdown for the terminal, wrapped to its width.
+// Output that is not a terminal gets plain text styling.
+func renderNote(md []byte) (string, error) {
+	width := 80
+	if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 {
+		width = min(w, 120)
+	}
+	r, err := glamour.NewTermRenderer(glamour.WithAutoStyle(), glamour.WithWordWrap(width))
+	if err != nil {
+		return "", err
+	}
+	return r.Render(string(md))
+}
+
+// noteHandler serves /n/{key} as an HTML page.
+func noteHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if err := requestPrincipal(r.Context()).check(key, false); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	md, err := getKey(ctx, key)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("note %s: %v", key, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	var body bytes.Buffer
+	if err := noteMarkdown.Convert(md, &body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	notePage.Execute(w, map[string]any{"Key": key, "Body": template.HTML(body.String())})
+}
+
+func noteCommand() *gcli.Command {
+	return &gcli.Command{
+		Name: "note",
+		Desc: "Work with Markdown notes, also served as /n/{key} by pb serve",
+		Subs: []*gcli.Command{
+			{
+				Name: "view",
+				Desc: "Render a Markdown value in the terminal",
+				Config: func(c *gcli.Command) {
+					c.AddArg("key", "The key of the note", true)
+					formatOpt(c, "the note", formatText)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					if err := connect(); err != nil {
+						return err
+					}
+					key := c.Arg("key").String()
+					md, err := getKey(ctx, key)
+					if err != nil {
+						return err
+					}
+					switch format := commandFormat(formatText); format {
+					case formatRaw:
+						// the Markdown itself
+						_, err := os.Stdout.Write(md)
+						return err
+					case formatJSON, formatYAML, formatTable:
+						records, err := keyRecords([]string{key}, map[string][]byte{key: md})
+						if err != nil {
+							return err
+						}
+						return printRecords(format, records, true)
+					}
+					out, err := renderNote(md)
+					if err != nil {
+						return err
+					}
+					fmt.Print(out)
+					return nil
+				},
+			},
+		},
+	}
+}
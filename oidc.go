@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// OIDCConfig has pb serve accept the ID tokens of an OpenID Connect
+// provider as bearer tokens, besides those of pb acl and pb token. The
+// identity of a token is its UserClaim, and each of its GroupsClaim; its
+// grants are those of the pb acl users of the same names:
+//
+//	"OIDC": {"Issuer": "https://accounts.google.com", "Audience": "<client id>"}
+//	pb acl add-user --no-token alice@example.com
+type OIDCConfig struct {
+	// Issuer is the URL of the provider, where its discovery document is
+	// found at /.well-known/openid-configuration.
+	Issuer string `json:"Issuer"`
+	// Audience is the client ID tokens must be issued for.
+	Audience string `json:"Audience"`
+	// UserClaim names the user. Defaults to email.
+	UserClaim string `json:"UserClaim,omitempty"`
+	// GroupsClaim, if set, is a list of names that the user also has the
+	// grants of, such as groups or roles.
+	GroupsClaim string `json:"GroupsClaim,omitempty"`
+}
+
+func (o *OIDCConfig) validate(field string) error {
+	u, err := url.Parse(o.Issuer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fieldErrorf(field+".Issuer", "must be an http:// or https:// URL")
+	}
+	if o.Audience == "" {
+		return fieldErrorf(field+".Audience", "is empty, set it to the client ID of pb serve at the provider")
+	}
+	return nil
+}
+
+var (
+	oidcConfig *OIDCConfig
+	// oidcVerifier is set by startOIDC when pb serve accepts ID tokens.
+	oidcVerifier *oidc.IDTokenVerifier
+)
+
+func setupOIDC(cfg *Config) {
+	oidcConfig = cfg.OIDC
+}
+
+// startOIDC fetches the keys of the provider in the config, if any, so
+// that authenticate accepts its ID tokens.
+func startOIDC() error {
+	if oidcConfig == nil {
+		return nil
+	}
+	if err := requireACLs(); err != nil {
+		return fmt.Errorf("OIDC: %w", err)
+	}
+	provider, err := oidc.NewProvider(ctx, oidcConfig.Issuer)
+	if err != nil {
+		return fmt.Errorf("OIDC: %w", err)
+	}
+	oidcVerifier = provider.Verifier(&oidc.Config{ClientID: oidcConfig.Audience})
+	log.Printf("accepting ID tokens from %s", oidcConfig.Issuer)
+	return nil
+}
+
+// isIDToken tells ID tokens, which are JWTs, from the tokens pb makes.
+func isIDToken(token string) bool {
+	return oidcVerifier != nil && !strings.HasPrefix(token, tokenPrefix) && strings.Count(token, ".") == 2
+}
+
+// oidcPrincipal verifies an ID token and returns its holder, with the
+// grants of the identities it names.
+func oidcPrincipal(token string) (*principal, error) {
+	idToken, err := oidcVerifier.Verify(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnauthenticated, err)
+	}
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnauthenticated, err)
+	}
+	userClaim := oidcConfig.UserClaim
+	if userClaim == "" {
+		userClaim = "email"
+	}
+	user, _ := claims[userClaim].(string)
+	if user == "" {
+		return nil, fmt.Errorf("%w: the ID token has no %s claim", errUnauthenticated, userClaim)
+	}
+	if verified, ok := claims["email_verified"].(bool); userClaim == "email" && ok && !verified {
+		return nil, fmt.Errorf("%w: the email address %s is not verified", errUnauthenticated, user)
+	}
+	names := []string{user}
+	if oidcConfig.GroupsClaim != "" {
+		groups, _ := claims[oidcConfig.GroupsClaim].([]any)
+		for _, g := range groups {
+			if name, ok := g.(string); ok && name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	p := &principal{User: user}
+	for _, name := range names {
+		grants, err := listGrants(name)
+		if err != nil {
+			return nil, err
+		}
+		p.grants = append(p.grants, grants...)
+	}
+	return p, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// jsonContent is the content of a request or response in JSON, of the
+// schema named ref.
+func jsonContent(ref string) map[string]any {
+	return map[string]any{
+		"application/json": map[string]any{
+			"schema": map[string]any{"$ref": "#/components/schemas/" + ref},
+		},
+	}
+}
+
+// apiSpec returns the OpenAPI 3 spec of the JSON API, from apiRoutes.
+func apiSpec() map[string]any {
+	paths := map[string]map[string]any{}
+	for _, route := range apiRoutes {
+		method, pattern, _ := strings.Cut(route.pattern, " ")
+		params := []any{}
+		if strings.Contains(pattern, "{key...}") {
+			params = append(params, map[string]any{
+				"name":        "key",
+				"in":          "path",
+				"required":    true,
+				"description": "The key, relative to the namespace of the server. Its slashes may be sent as they are or escaped.",
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		for _, p := range route.query {
+			params = append(params, map[string]any{
+				"name":        p.name,
+				"in":          "query",
+				"description": p.desc,
+				"schema":      map[string]any{"type": p.typ},
+			})
+		}
+		op := map[string]any{
+			"operationId": route.id,
+			"summary":     route.summary,
+			"parameters":  params,
+		}
+		if route.body != "" {
+			op["requestBody"] = map[string]any{
+				"required":    true,
+				"description": route.body,
+				"content": map[string]any{
+					"application/octet-stream": map[string]any{
+						"schema": map[string]any{"type": "string", "format": "binary"},
+					},
+				},
+			}
+		}
+		responses := map[string]any{}
+		if route.result != "" {
+			responses["200"] = map[string]any{"description": "OK", "content": jsonContent(route.result)}
+		} else {
+			responses["204"] = map[string]any{"description": "Done"}
+		}
+		for _, status := range slices.Concat(route.errors, []int{http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusInternalServerError}) {
+			responses[strconv.Itoa(status)] = map[string]any{"description": http.StatusText(status), "content": jsonContent("Error")}
+		}
+		op["responses"] = responses
+
+		path := strings.ReplaceAll(pattern, "...}", "}")
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(method)] = op
+	}
+
+	str := map[string]any{"type": "string"}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "postboard",
+			"version":     "1",
+			"description": "The JSON key/value API of pb serve.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": map[string]any{
+				"Entry": map[string]any{
+					"type":     "object",
+					"required": []string{"key", "value"},
+					"properties": map[string]any{
+						"key":      str,
+						"value":    map[string]any{"type": "string", "description": "The value, base64-encoded if it is not valid UTF-8"},
+						"encoding": map[string]any{"type": "string", "enum": []string{"base64"}, "description": "base64 when the value is base64-encoded"},
+					},
+				},
+				"KeyList": map[string]any{
+					"type":     "object",
+					"required": []string{"keys"},
+					"properties": map[string]any{
+						"keys": map[string]any{"type": "array", "items": str, "description": "The keys the token may read, so a page may be short"},
+						"next": map[string]any{"type": "string", "description": "The after of the next page, when there may be one"},
+					},
+				},
+				"Error": map[string]any{
+					"type":       "object",
+					"required":   []string{"error"},
+					"properties": map[string]any{"error": str},
+				},
+			},
+			// the token of requestToken, once the board has users
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+				"basic":  map[string]any{"type": "http", "scheme": "basic", "description": "The token as the password"},
+			},
+		},
+		"security": []any{
+			map[string]any{"bearer": []string{}},
+			map[string]any{"basic": []string{}},
+			map[string]any{},
+		},
+	}
+}
+
+// apiSpecJSON returns apiSpec as indented JSON, as checked in to
+// openapi/openapi.json.
+func apiSpecJSON() []byte {
+	// maps of strings and slices always marshal
+	b, _ := json.MarshalIndent(apiSpec(), "", "  ")
+	return append(b, '\n')
+}
+
+func openapiCommand() *gcli.Command {
+	var output string
+	return &gcli.Command{
+		Name: "openapi",
+		Desc: "Print the OpenAPI spec of the JSON API of pb serve",
+		Help: `Generates clients of the /v1 API in other languages, e.g.
+
+  pb openapi -o pb.json
+  npx @openapitools/openapi-generator-cli generate -i pb.json -g typescript-fetch -o pb-client
+
+pb serve also serves it at /v1/openapi.json, and it is checked in as
+openapi/openapi.json.`,
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&output, "output", "o", "", "Write the spec to this file instead of stdout")
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if output != "" {
+				return os.WriteFile(output, apiSpecJSON(), 0644)
+			}
+			_, err := os.Stdout.Write(apiSpecJSON())
+			return err
+		},
+	}
+}
@@ -0,0 +1,17 @@
+// Package openapi holds the OpenAPI 3 spec of the JSON key/value API
+// under /v1/, generated by pb openapi. Clients in other languages are
+// generated from openapi.json, e.g. for Python:
+//
+//	openapi-python-client generate --path openapi/openapi.json
+//
+// The gRPC interface is in package kvpb.
+package openapi
+
+import _ "embed"
+
+//go:generate go run .. openapi -o openapi.json
+
+// Spec is openapi.json.
+//
+//go:embed openapi.json
+var Spec []byte
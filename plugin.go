@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// pluginPrefix is prepended to an unknown subcommand to find the plugin
+// executable on PATH, so `pb foo` runs `pb-foo`.
+const pluginPrefix = "pb-"
+
+// childExitCode is the exit code of a program pb ran in place of doing the
+// work itself, such as a plugin, which pb exits with; -1 if there was none.
+var childExitCode = -1
+
+// pluginEnv describes the board to a plugin. Plugins should prefer these
+// variables over parsing the config file themselves.
+func pluginEnv() ([]string, error) {
+	path, err := filepath.Abs(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	env := append(os.Environ(), "POSTBOARD_CONFIG="+path, "POSTBOARD_BIN="+os.Args[0])
+	if profileFlag != "" {
+		// for the plugin running $POSTBOARD_BIN
+		env = append(env, "PB_PROFILE="+profileFlag)
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		// not configured yet; the plugin may not need the board at all
+		return env, nil
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return append(env,
+		"POSTBOARD_DRIVER="+cfg.Driver,
+		"POSTBOARD_DSN="+cfg.DSN,
+		"POSTBOARD_TABLE="+cfg.Table,
+		"POSTBOARD_NAMESPACE="+cfg.Namespace,
+	), nil
+}
+
+// runPlugin runs pb-<name> with args, passing through stdio, and returns
+// its exit code. found is false if there is no such executable.
+func runPlugin(name string, args []string) (code int, found bool, err error) {
+	bin, err := exec.LookPath(pluginPrefix + name)
+	if err != nil {
+		return 0, false, nil
+	}
+	env, err := pluginEnv()
+	if err != nil {
+		return 0, true, err
+	}
+	cmd := exec.Command(bin, args...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), true, nil
+	}
+	if err != nil {
+		return 0, true, err
+	}
+	return 0, true, nil
+}
+
+// dispatchPlugin is the app's command-not-found hook. It returns true,
+// stopping gcli from printing its "command not found" tips, when a plugin
+// handled the command.
+func dispatchPlugin(hc *gcli.HookCtx) bool {
+	name := hc.Str("name")
+	args, _ := hc.Get("args").([]string)
+	code, found, err := runPlugin(name, args)
+	if !found {
+		return false
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pb: cannot run plugin %s%s: %v\n", pluginPrefix, name, err)
+		code = 1
+	}
+	childExitCode = code
+	return true
+}
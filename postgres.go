@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresMigrations mirrors migrations for the postgres driver. An empty
+// statement is a migration that postgres does not need.
+var postgresMigrations = []string{
+	// 1: initial key/value table
+	`
+CREATE TABLE IF NOT EXISTS %[1]s (
+  k VARCHAR(255) NOT NULL PRIMARY KEY,
+  v BYTEA NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`,
+	// 2: free-form metadata attached to keys
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_meta (
+  k VARCHAR(255) NOT NULL,
+  name VARCHAR(64) NOT NULL,
+  v TEXT NOT NULL,
+  PRIMARY KEY (k, name)
+);`,
+	// 3: when each value was last written
+	`
+ALTER TABLE %[1]s ADD COLUMN updated_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6);
+CREATE INDEX %[1]s_updated_at ON %[1]s (updated_at);`,
+	// 4, 5: the encoding is chosen per database, see pb doctor
+	"",
+	"",
+	// 6: when values written with pb set --ttl expire, NULL for never
+	`
+ALTER TABLE %[1]s ADD COLUMN expires_at TIMESTAMP(6);
+CREATE INDEX %[1]s_expires_at ON %[1]s (expires_at);`,
+	// 7: values replaced or deleted, for pb history
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_history (
+  k VARCHAR(255) NOT NULL,
+  version INT NOT NULL,
+  v BYTEA NOT NULL,
+  updated_at TIMESTAMP(6) NOT NULL,
+  PRIMARY KEY (k, version)
+);`,
+	// 8, 9: BYTEA has no 64KB limit
+	"",
+	"",
+	// 10: the chunks of large values, see putChunks
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_chunks (
+  id BYTEA NOT NULL,
+  seq INT NOT NULL,
+  v BYTEA NOT NULL,
+  PRIMARY KEY (id, seq)
+);`,
+	// 11: who last wrote each value, see Config.User
+	`
+ALTER TABLE %[1]s ADD COLUMN updated_by VARCHAR(255);`,
+	// 12: every set and delete, for pb audit
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_audit (
+  id BIGSERIAL PRIMARY KEY,
+  k VARCHAR(255) NOT NULL,
+  action VARCHAR(16) NOT NULL,
+  value_hash CHAR(64),
+  old_version INT,
+  changed_by VARCHAR(255),
+  changed_at TIMESTAMP(6) NOT NULL
+);
+CREATE INDEX %[1]s_audit_k ON %[1]s_audit (k);
+CREATE INDEX %[1]s_audit_changed_at ON %[1]s_audit (changed_at);`,
+	// 13: who may use pb serve, see pb acl
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_users (
+  name VARCHAR(255) PRIMARY KEY,
+  token_hash CHAR(64) NOT NULL UNIQUE,
+  created_at TIMESTAMP(6) NOT NULL
+);`,
+	// 14: the prefixes they may read or write
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_grants (
+  name VARCHAR(255) NOT NULL,
+  prefix VARCHAR(255) NOT NULL,
+  access VARCHAR(16) NOT NULL,
+  PRIMARY KEY (name, prefix)
+);`,
+	// 15: bearer tokens for pb serve, see pb token
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_tokens (
+  id VARCHAR(16) PRIMARY KEY,
+  token_hash CHAR(64) NOT NULL UNIQUE,
+  name VARCHAR(255),
+  prefix VARCHAR(255) NOT NULL,
+  access VARCHAR(16) NOT NULL,
+  created_at TIMESTAMP(6) NOT NULL,
+  expires_at TIMESTAMP(6)
+);`,
+}
+
+// isPostgresDSN reports whether dsn is a postgres URL, which selects the
+// postgres driver when Driver is not set.
+func isPostgresDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// newPostgresConnector returns a connector for a postgres DSN, either a URL
+// or key=value pairs.
+func newPostgresConnector(cfg *Config) (driver.Connector, error) {
+	conf, err := pgx.ParseConfig(cfg.DSN)
+	if err != nil {
+		return nil, fieldErrorf("DSN", "%v", err)
+	}
+	if conf.ConnectTimeout == 0 {
+		conf.ConnectTimeout = cfg.ConnectTimeout.Duration
+	}
+	return pgConnector{stdlib.GetConnector(*conf)}, nil
+}
+
+// pgConnector hands out connections that accept the ? placeholders used
+// by every statement in pb.
+type pgConnector struct {
+	driver.Connector
+}
+
+func (c pgConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pgConn{conn.(*stdlib.Conn)}, nil
+}
+
+// pgConn rewrites placeholders on the way to the pgx connection.
+type pgConn struct {
+	*stdlib.Conn
+}
+
+func (c pgConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(rebind(query))
+}
+
+func (c pgConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.Conn.PrepareContext(ctx, rebind(query))
+}
+
+func (c pgConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.ExecContext(ctx, rebind(query), args)
+}
+
+func (c pgConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.Conn.QueryContext(ctx, rebind(query), args)
+}
+
+// rebind numbers the ? placeholders of query as $1, $2..., leaving
+// question marks in string literals alone.
+func rebind(query string) string {
+	if !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n, quoted := 0, false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gookit/gcli/v3"
+	"github.com/gookit/gcli/v3/gflag"
+)
+
+// pushSettle is how long a file must stay quiet before it is uploaded, so
+// that a build writing a file in several steps is pushed once.
+const pushSettle = 300 * time.Millisecond
+
+type pusher struct {
+	dir      string
+	prefix   string
+	includes []string
+	excludes []string
+	delete   bool
+	// pushed remembers the hash last uploaded per path to skip no-op writes
+	pushed map[string]string
+}
+
+// rel returns the slash separated path of p inside the watched directory
+// and whether it should be pushed.
+func (p *pusher) rel(name string) (string, bool) {
+	rel, err := filepath.Rel(p.dir, name)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if matchAny(p.excludes, rel) {
+		return rel, false
+	}
+	return rel, len(p.includes) == 0 || matchAny(p.includes, rel)
+}
+
+func (p *pusher) push(name string) error {
+	rel, ok := p.rel(name)
+	if !ok {
+		return nil
+	}
+	b, err := os.ReadFile(name)
+	if os.IsNotExist(err) {
+		return p.remove(name)
+	}
+	if err != nil {
+		return err
+	}
+	hash := hashBytes(b)
+	if p.pushed[rel] == hash {
+		return nil
+	}
+	if err := putKeyValue(ctx, p.prefix+rel, b); err != nil {
+		return err
+	}
+	p.pushed[rel] = hash
+	log.Printf("pushed %s -> %s (%d bytes)", rel, p.prefix+rel, len(b))
+	return nil
+}
+
+func (p *pusher) remove(name string) error {
+	rel, ok := p.rel(name)
+	if !ok || !p.delete {
+		return nil
+	}
+	delete(p.pushed, rel)
+	if deleted, err := deleteKey(ctx, p.prefix+rel); err != nil || !deleted {
+		return err
+	}
+	log.Printf("deleted %s", p.prefix+rel)
+	return nil
+}
+
+// addTree watches dir and every directory below it, since fsnotify only
+// reports changes to the immediate children of a watched directory. Files
+// found on the way are pushed.
+func (p *pusher) addTree(w *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if rel, _ := p.rel(name); rel != "." && matchAny(p.excludes, rel) {
+				return filepath.SkipDir
+			}
+			return w.Add(name)
+		}
+		if d.Type().IsRegular() {
+			return p.push(name)
+		}
+		return nil
+	})
+}
+
+func (p *pusher) run() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := p.addTree(w, p.dir); err != nil {
+		return err
+	}
+	log.Printf("watching %s, pushing to %s", p.dir, p.prefix)
+
+	pending := map[string]time.Time{}
+	tick := time.NewTicker(pushSettle / 3)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-w.Errors:
+			return err
+		case ev := <-w.Events:
+			if ev.Has(fsnotify.Create) {
+				if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+					if err := p.addTree(w, ev.Name); err != nil {
+						log.Printf("watch %s: %v", ev.Name, err)
+					}
+					continue
+				}
+			}
+			pending[ev.Name] = time.Now()
+		case now := <-tick.C:
+			for name, at := range pending {
+				if now.Sub(at) < pushSettle {
+					continue
+				}
+				delete(pending, name)
+				if err := p.push(name); err != nil {
+					log.Printf("push %s: %v", name, err)
+				}
+			}
+		}
+	}
+}
+
+func pushOnChangeCommand() *gcli.Command {
+	var includes, excludes gflag.Strings
+	var del bool
+	return &gcli.Command{
+		Name: "push-on-change",
+		Desc: "Upload files as keys whenever they change",
+		Help: `Pushes every matching file under dir once, then watches dir and uploads
+each file again after it changes. Runs until interrupted.`,
+		Config: func(c *gcli.Command) {
+			c.VarOpt(&includes, "include", "i", "Glob of files to push, may be repeated (default all)")
+			c.VarOpt(&excludes, "exclude", "x", "Glob of files or directories to skip, may be repeated")
+			c.BoolOpt(&del, "delete", "", false, "Delete the key when its file is removed")
+			c.AddArg("dir", "The directory to watch", true)
+			c.AddArg("prefix", "The key prefix to upload to, e.g. artifacts/", true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			p := &pusher{
+				dir:      filepath.Clean(c.Arg("dir").String()),
+				prefix:   c.Arg("prefix").String(),
+				includes: includes,
+				excludes: excludes,
+				delete:   del,
+				pushed:   map[string]string{},
+			}
+			if fi, err := os.Stat(p.dir); err != nil {
+				return err
+			} else if !fi.IsDir() {
+				return fmt.Errorf("%s is not a directory", p.dir)
+			}
+			if err := connect(); err != nil {
+				return err
+			}
+			return p.run()
+		},
+	}
+}
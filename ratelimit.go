@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// rateSweepInterval is how often the buckets of clients that have gone
+// quiet are dropped.
+const rateSweepInterval = time.Minute
+
+var errRateLimited = errors.New("too many requests")
+
+// RateLimitConfig limits how fast clients of pb serve may make requests,
+// each having a bucket of Burst requests refilled at the rate given. Every
+// request counts against its address, and those with a token against the
+// token too:
+//
+//	"RateLimit": {"PerIP": 20, "PerToken": 50, "Burst": 100}
+type RateLimitConfig struct {
+	// PerIP is the requests per second allowed from one address, 0 for no
+	// limit. Behind a proxy, all clients share the address of the proxy.
+	PerIP float64 `json:"PerIP,omitempty"`
+	// PerToken is the requests per second allowed with one token, 0 for
+	// no limit.
+	PerToken float64 `json:"PerToken,omitempty"`
+	// Burst is how many requests a client may make at once. Defaults to a
+	// second's worth.
+	Burst int `json:"Burst,omitempty"`
+}
+
+func (l *RateLimitConfig) validate(field string) error {
+	if l.PerIP < 0 {
+		return fieldErrorf(field+".PerIP", "must not be negative")
+	}
+	if l.PerToken < 0 {
+		return fieldErrorf(field+".PerToken", "must not be negative")
+	}
+	if l.PerIP == 0 && l.PerToken == 0 {
+		return fieldErrorf(field+".PerIP", "is 0 as is PerToken, remove RateLimit to disable rate limiting")
+	}
+	if l.Burst < 0 {
+		return fieldErrorf(field+".Burst", "must not be negative")
+	}
+	return nil
+}
+
+// rateLimiter keeps a token bucket per client.
+type rateLimiter struct {
+	limit   rate.Limit
+	burst   int
+	mu      sync.Mutex
+	buckets map[string]*rate.Limiter
+}
+
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	if perSecond == 0 {
+		return nil
+	}
+	if burst == 0 {
+		burst = int(math.Ceil(perSecond))
+	}
+	return &rateLimiter{limit: rate.Limit(perSecond), burst: burst, buckets: map[string]*rate.Limiter{}}
+}
+
+// reserve takes a request from the bucket of client, returning how long
+// to wait instead if it is empty.
+func (l *rateLimiter) reserve(client string) time.Duration {
+	l.mu.Lock()
+	b, ok := l.buckets[client]
+	if !ok {
+		b = rate.NewLimiter(l.limit, l.burst)
+		l.buckets[client] = b
+	}
+	l.mu.Unlock()
+	r := b.Reserve()
+	if d := r.Delay(); d > 0 {
+		r.Cancel()
+		return d
+	}
+	return 0
+}
+
+// sweep drops the buckets that are full again, which are as good as new.
+func (l *rateLimiter) sweep() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for client, b := range l.buckets {
+		if b.Tokens() >= float64(l.burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+var (
+	rateLimitConfig *RateLimitConfig
+	// ipLimiter and tokenLimiter are set by startRateLimits, when limited.
+	ipLimiter, tokenLimiter *rateLimiter
+)
+
+func setupRateLimit(cfg *Config) {
+	rateLimitConfig = cfg.RateLimit
+}
+
+// startRateLimits makes the buckets of the limits in the config, if any,
+// and drops those of quiet clients until pb is interrupted.
+func startRateLimits() {
+	if rateLimitConfig == nil {
+		return
+	}
+	ipLimiter = newRateLimiter(rateLimitConfig.PerIP, rateLimitConfig.Burst)
+	tokenLimiter = newRateLimiter(rateLimitConfig.PerToken, rateLimitConfig.Burst)
+	go func() {
+		t := time.NewTicker(rateSweepInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				for _, l := range []*rateLimiter{ipLimiter, tokenLimiter} {
+					if l != nil {
+						l.sweep()
+					}
+				}
+			}
+		}
+	}()
+}
+
+// rateLimit counts a request from addr with token against the limits,
+// returning how long to wait before retrying if it is over one.
+func rateLimit(addr, token string) time.Duration {
+	if ipLimiter != nil {
+		ip, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			ip = addr
+		}
+		if d := ipLimiter.reserve(ip); d > 0 {
+			return d
+		}
+	}
+	if tokenLimiter != nil && token != "" {
+		// the bucket outlives the request, so keep no copy of the token
+		return tokenLimiter.reserve(hashToken(token))
+	}
+	return 0
+}
+
+// rateLimited answers requests over the limits with 429 Too Many Requests
+// rather than passing them to h.
+func rateLimited(h http.Handler) http.Handler {
+	if ipLimiter == nil && tokenLimiter == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d := rateLimit(r.RemoteAddr, requestToken(r)); d > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+			apiError(w, r, http.StatusTooManyRequests, fmt.Errorf("%w, retry in %s", errRateLimited, d.Round(time.Millisecond)))
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// grpcRateLimited fails calls over the limits with ResourceExhausted.
+func grpcRateLimited(ctx context.Context) error {
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	if d := rateLimit(addr, grpcToken(ctx)); d > 0 {
+		return status.Errorf(codes.ResourceExhausted, "%v, retry in %s", errRateLimited, d.Round(time.Millisecond))
+	}
+	return nil
+}
+
+func grpcRateLimit(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := grpcRateLimited(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func grpcStreamRateLimit(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := grpcRateLimited(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
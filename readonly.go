@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// errReadOnly is returned by every write to a read-only board.
+var errReadOnly = errors.New("the board is read-only, see ReadOnly in the config and pb --read-only")
+
+// readOnlyFlag is set by the global --read-only flag.
+var readOnlyFlag bool
+
+// readOnly is set by connect from pb --read-only and Config.ReadOnly, for
+// the commands that write to the database without going through store.
+var readOnly bool
+
+// readOnlyStore rejects every write to the stores it wraps, including the
+// optional ones, so that no code path falls back to a plain Put.
+type readOnlyStore struct {
+	Store
+	MetaStore
+}
+
+func (readOnlyStore) Put(context.Context, string, []byte) error {
+	return errReadOnly
+}
+
+func (readOnlyStore) Delete(context.Context, string) (bool, error) {
+	return false, errReadOnly
+}
+
+func (readOnlyStore) SetMeta(context.Context, string, map[string]string) error {
+	return errReadOnly
+}
+
+func (readOnlyStore) DeleteMeta(context.Context, string) error {
+	return errReadOnly
+}
+
+func (readOnlyStore) IncrMeta(context.Context, string, string) error {
+	return errReadOnly
+}
+
+func (readOnlyStore) PutWithTTL(context.Context, string, []byte, time.Duration) error {
+	return errReadOnly
+}
+
+func (readOnlyStore) PutIf(context.Context, string, []byte, time.Duration, func([]byte) (bool, error)) (bool, error) {
+	return false, errReadOnly
+}
+
+func (readOnlyStore) DeleteIf(context.Context, string, func([]byte) (bool, error)) (bool, error) {
+	return false, errReadOnly
+}
+
+func (readOnlyStore) PutMany(context.Context, []keyValue) error {
+	return errReadOnly
+}
+
+func (readOnlyStore) Append(context.Context, string, []byte) (bool, error) {
+	return false, errReadOnly
+}
+
+func (readOnlyStore) Copy(context.Context, string, string) (bool, error) {
+	return false, errReadOnly
+}
+
+// sqlReads are the optional interfaces of sqlStore that only read.
+type sqlReads interface {
+	multiGetStore
+	statStore
+	listingStore
+	versionedStore
+	auditingStore
+}
+
+// readOnlySQLStore is a read-only sqlStore, which keeps its reads.
+type readOnlySQLStore struct {
+	readOnlyStore
+	sqlReads
+}
+
+// newReadOnlyStore wraps store and meta so that writes fail with
+// errReadOnly.
+func newReadOnlyStore(store Store, meta MetaStore) (Store, MetaStore) {
+	s := readOnlyStore{store, meta}
+	if reads, ok := store.(sqlStore); ok {
+		return readOnlySQLStore{s, reads}, s
+	}
+	return s, s
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+)
+
+// ageMagic and gpgMagic prefix values encrypted to recipients with
+// pb set -r, which readers decrypt with their own private keys.
+var (
+	ageMagic = []byte("pb:age:")
+	gpgMagic = []byte("pb:gpg:")
+)
+
+// recipients is set by pb set -r. Values are then encrypted to them
+// instead of with the symmetric key.
+var recipients *recipientSet
+
+// identityFile is Encryption.IdentityFile of the config.
+var identityFile string
+
+type recipientSet struct {
+	age []age.Recipient
+	// gpg holds the recipient arguments of gpg --encrypt
+	gpg []string
+}
+
+// parseRecipients resolves the arguments of pb set -r. Each is an age or
+// SSH public key, a file of them, a file with an armored PGP public key,
+// or else a GPG key ID or email in the local keyring. One value cannot be
+// encrypted with both age and GPG.
+func parseRecipients(args []string) (*recipientSet, error) {
+	rs := &recipientSet{}
+	for _, arg := range args {
+		b, err := os.ReadFile(arg)
+		switch {
+		case err == nil && bytes.Contains(b, []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")):
+			rs.gpg = append(rs.gpg, "--recipient-file", arg)
+		case err == nil || strings.HasPrefix(arg, "age1") || strings.HasPrefix(arg, "ssh-"):
+			if err != nil {
+				b = []byte(arg)
+			}
+			list, err := parseAgeRecipients(string(b))
+			if err != nil {
+				return nil, fmt.Errorf("recipient %s: %w", arg, err)
+			}
+			rs.age = append(rs.age, list...)
+		default:
+			rs.gpg = append(rs.gpg, "--recipient", arg)
+		}
+	}
+	if len(rs.age) > 0 && len(rs.gpg) > 0 {
+		return nil, errors.New("-r cannot mix age and GPG recipients")
+	}
+	return rs, nil
+}
+
+// parseAgeRecipients parses age and SSH public keys, one per line, as in
+// the recipients files of age -R.
+func parseAgeRecipients(s string) ([]age.Recipient, error) {
+	var list []age.Recipient
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r age.Recipient
+		var err error
+		if strings.HasPrefix(line, "ssh-") {
+			r, err = agessh.ParseRecipient(line)
+		} else {
+			r, err = age.ParseX25519Recipient(line)
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	if len(list) == 0 {
+		return nil, errors.New("no public keys found")
+	}
+	return list, nil
+}
+
+func (rs *recipientSet) encrypt(value []byte) ([]byte, error) {
+	if len(rs.gpg) > 0 {
+		// the keys were chosen explicitly, there is no web of trust to consult
+		out, err := runGPG(value, append([]string{"--encrypt", "--trust-model", "always"}, rs.gpg...)...)
+		if err != nil {
+			return nil, err
+		}
+		return append(append([]byte{}, gpgMagic...), out...), nil
+	}
+	var buf bytes.Buffer
+	buf.Write(ageMagic)
+	w, err := age.Encrypt(&buf, rs.age...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// runGPG runs gpg non-interactively on stdin. Decrypting asks gpg-agent
+// for the passphrase of the private key as usual.
+func runGPG(stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "gpg", append([]string{"--batch", "--yes", "--quiet"}, args...)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("gpg: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+// ageIdentities loads the private keys for values encrypted with age, from
+// POSTBOARD_IDENTITY or Encryption.IdentityFile: an age key file or an
+// unencrypted SSH private key.
+var ageIdentities = sync.OnceValues(func() ([]age.Identity, error) {
+	path := os.Getenv("POSTBOARD_IDENTITY")
+	if path == "" {
+		path = identityFile
+	}
+	if path == "" {
+		return nil, errors.New("value is encrypted with age, set POSTBOARD_IDENTITY or Encryption.IdentityFile in the config to your private key to read it")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Contains(b, []byte("PRIVATE KEY-----")) {
+		id, err := agessh.ParseIdentity(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return []age.Identity{id}, nil
+	}
+	ids, err := age.ParseIdentities(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ids, nil
+})
+
+// decryptForRecipient opens a value encrypted to recipients, reporting
+// whether value was one.
+func decryptForRecipient(value []byte) ([]byte, bool, error) {
+	switch {
+	case bytes.HasPrefix(value, ageMagic):
+		ids, err := ageIdentities()
+		if err != nil {
+			return nil, true, err
+		}
+		r, err := age.Decrypt(bytes.NewReader(value[len(ageMagic):]), ids...)
+		if err != nil {
+			return nil, true, fmt.Errorf("cannot decrypt value: %w", err)
+		}
+		plain, err := io.ReadAll(r)
+		return plain, true, err
+	case bytes.HasPrefix(value, gpgMagic):
+		plain, err := runGPG(value[len(gpgMagic):], "--decrypt")
+		return plain, true, err
+	}
+	return nil, false, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// isRedisDSN reports whether dsn is a redis:// or rediss:// URL, which
+// selects the redis driver when Driver is not set.
+func isRedisDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, "redis://") || strings.HasPrefix(dsn, "rediss://")
+}
+
+// redisStore keeps each value in a string key and the metadata of a key
+// in a hash, both under the table name so that boards using different
+// Tables can share a server. There are no transactions: inTx applies
+// writes one by one.
+type redisStore struct {
+	client *redis.Client
+}
+
+func newRedisStore(cfg *Config) (*redisStore, error) {
+	opts, err := redis.ParseURL(cfg.DSN)
+	if err != nil {
+		return nil, fieldErrorf("DSN", "%v", err)
+	}
+	opts.DialTimeout = cfg.ConnectTimeout.Duration
+	if cfg.MaxOpenConns > 0 {
+		opts.PoolSize = cfg.MaxOpenConns
+	}
+	if cfg.MaxIdleConns > 0 {
+		opts.MaxIdleConns = cfg.MaxIdleConns
+	}
+	opts.ConnMaxLifetime = cfg.ConnMaxLifetime.Duration
+	if cfg.CAFile != "" {
+		pool, err := loadCAFile("CAFile", cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if opts.TLSConfig == nil {
+			host, _, _ := strings.Cut(opts.Addr, ":")
+			opts.TLSConfig = &tls.Config{ServerName: host}
+		}
+		opts.TLSConfig.RootCAs = pool
+	}
+	return &redisStore{redis.NewClient(opts)}, nil
+}
+
+func (s *redisStore) key(key string) string {
+	return kvTable + ":" + key
+}
+
+func (s *redisStore) metaKey(key string) string {
+	return metaTable() + ":" + key
+}
+
+func (s *redisStore) Put(ctx context.Context, key string, value []byte) error {
+	return s.PutWithTTL(ctx, key, value, 0)
+}
+
+// PutWithTTL stores value under key, to be deleted by Redis after ttl
+// unless ttl is 0. Metadata expires with the key.
+func (s *redisStore) PutWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := s.client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, s.key(key), value, ttl)
+		if ttl > 0 {
+			p.PExpire(ctx, s.metaKey(key), ttl)
+		} else {
+			p.Persist(ctx, s.metaKey(key))
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.client.Get(ctx, s.key(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, sql.ErrNoRows
+	}
+	return value, err
+}
+
+// List scans for the keys under prefix. SCAN visits keys in no particular
+// order, so with more than limit keys the ones returned are arbitrary.
+func (s *redisStore) List(ctx context.Context, prefix, after string, limit int) ([]string, error) {
+	match := redisGlobEscaper.Replace(s.key(prefix)) + "*"
+	var keys []string
+	// SCAN is unordered, so every page scans the whole prefix
+	iter := s.client.Scan(ctx, 0, match, 1000).Iterator()
+	for iter.Next(ctx) {
+		if key := strings.TrimPrefix(iter.Val(), kvTable+":"); key > after {
+			keys = append(keys, key)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+	return keys[:min(len(keys), limit)], nil
+}
+
+// redisGlobEscaper quotes the characters SCAN MATCH treats as patterns.
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+func (s *redisStore) Delete(ctx context.Context, key string) (bool, error) {
+	n, err := s.client.Del(ctx, s.key(key)).Result()
+	return n > 0, err
+}
+
+func (s *redisStore) SetMeta(ctx context.Context, key string, fields map[string]string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	if err := s.client.HSet(ctx, s.metaKey(key), fields).Err(); err != nil {
+		return err
+	}
+	return s.expireMeta(ctx, key)
+}
+
+// expireMeta gives the metadata of key the same expiry as its value.
+func (s *redisStore) expireMeta(ctx context.Context, key string) error {
+	ttl, err := s.client.PTTL(ctx, s.key(key)).Result()
+	if err != nil || ttl <= 0 {
+		return err
+	}
+	return s.client.PExpire(ctx, s.metaKey(key), ttl).Err()
+}
+
+func (s *redisStore) GetMeta(ctx context.Context, key string) (map[string]string, error) {
+	return s.client.HGetAll(ctx, s.metaKey(key)).Result()
+}
+
+func (s *redisStore) DeleteMeta(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.metaKey(key)).Err()
+}
+
+func (s *redisStore) IncrMeta(ctx context.Context, key, name string) error {
+	if err := s.client.HIncrBy(ctx, s.metaKey(key), name, 1).Err(); err != nil {
+		return err
+	}
+	return s.expireMeta(ctx, key)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// s3MetaDir holds the metadata of each key as a JSON object, beside the
+// values under the prefix of the DSN.
+const s3MetaDir = ".pb-meta/"
+
+// isS3DSN reports whether dsn is an s3:// URL, which selects the s3 driver
+// when Driver is not set.
+func isS3DSN(dsn string) bool {
+	return strings.HasPrefix(dsn, "s3://")
+}
+
+// s3Location is a parsed s3 DSN such as
+// s3://bucket/prefix/?region=eu-west-1&endpoint=http://localhost:9000.
+type s3Location struct {
+	bucket, prefix   string
+	region, endpoint string
+}
+
+func parseS3DSN(dsn string) (*s3Location, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("expected s3://bucket/prefix/")
+	}
+	loc := &s3Location{
+		bucket:   u.Host,
+		prefix:   strings.TrimPrefix(u.Path, "/"),
+		region:   u.Query().Get("region"),
+		endpoint: u.Query().Get("endpoint"),
+	}
+	for name := range u.Query() {
+		if name != "region" && name != "endpoint" {
+			return nil, fmt.Errorf("unknown parameter %q, expected region or endpoint", name)
+		}
+	}
+	return loc, nil
+}
+
+// s3Store keeps each key as an object named by the prefix of the DSN and
+// the key, so large values are not limited by a database column and the
+// bucket can be browsed with other tools. Table is not used. There are
+// no transactions: inTx applies writes one by one.
+type s3Store struct {
+	client         *s3.Client
+	bucket, prefix string
+}
+
+func newS3Store(cfg *Config) (*s3Store, error) {
+	loc, err := parseS3DSN(cfg.DSN)
+	if err != nil {
+		return nil, fieldErrorf("DSN", "%v", err)
+	}
+	var opts []func(*awsconfig.LoadOptions) error
+	if loc.region != "" {
+		opts = append(opts, awsconfig.WithRegion(loc.region))
+	}
+	if cfg.CAFile != "" {
+		pool, err := loadCAFile("CAFile", cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, awsconfig.WithHTTPClient(awshttp.NewBuildableClient().WithTransportOptions(func(t *http.Transport) {
+			t.TLSClientConfig = &tls.Config{RootCAs: pool}
+		})))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if loc.endpoint != "" {
+			// MinIO and most other S3-compatible servers only serve
+			// path-style URLs
+			o.BaseEndpoint = aws.String(loc.endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &s3Store{client: client, bucket: loc.bucket, prefix: loc.prefix}, nil
+}
+
+func (s *s3Store) object(key string) *string {
+	return aws.String(s.prefix + key)
+}
+
+func (s *s3Store) metaObject(key string) *string {
+	return aws.String(s.prefix + s3MetaDir + key)
+}
+
+// isNotFound reports whether err is S3's answer for a missing object,
+// which is NoSuchKey for GET and a bare 404 for HEAD.
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	return errors.As(err, &noSuchKey) || errors.As(err, &notFound)
+}
+
+// isPreconditionFailed reports whether a conditional write lost a race.
+func isPreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed"
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, value []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.object(key),
+		Body:   bytes.NewReader(value),
+	})
+	return err
+}
+
+func (s *s3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	value, _, err := s.read(ctx, s.object(key))
+	return value, err
+}
+
+// read returns the content and ETag of an object, or sql.ErrNoRows.
+func (s *s3Store) read(ctx context.Context, object *string) ([]byte, *string, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(s.bucket), Key: object})
+	if isNotFound(err) {
+		return nil, nil, sql.ErrNoRows
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer out.Body.Close()
+	b, err := io.ReadAll(out.Body)
+	return b, out.ETag, err
+}
+
+func (s *s3Store) List(ctx context.Context, prefix, after string, limit int) ([]string, error) {
+	var keys []string
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: s.object(prefix),
+	}
+	if after != "" {
+		// S3 lists in UTF-8 binary order, as pb compares keys
+		input.StartAfter = s.object(after)
+	}
+	pages := s3.NewListObjectsV2Paginator(s.client, input)
+	for pages.HasMorePages() && len(keys) < limit {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			key := strings.TrimPrefix(aws.ToString(obj.Key), s.prefix)
+			if strings.HasPrefix(key, s3MetaDir) || len(keys) == limit {
+				continue
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) (bool, error) {
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(s.bucket), Key: s.object(key)})
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: s.object(key)})
+	return err == nil, err
+}
+
+// updateMeta applies fn to the metadata of key and writes it back, only
+// if nobody else wrote it in between, retrying otherwise.
+func (s *s3Store) updateMeta(ctx context.Context, key string, fn func(fields map[string]string)) error {
+	for {
+		fields := map[string]string{}
+		b, etag, err := s.read(ctx, s.metaObject(key))
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(b, &fields); err != nil {
+				return fmt.Errorf("metadata of %s: %w", key, err)
+			}
+		case err != sql.ErrNoRows:
+			return err
+		}
+		fn(fields)
+		if b, err = json.Marshal(fields); err != nil {
+			return err
+		}
+		in := &s3.PutObjectInput{
+			Bucket:      aws.String(s.bucket),
+			Key:         s.metaObject(key),
+			Body:        bytes.NewReader(b),
+			ContentType: aws.String("application/json"),
+		}
+		if etag != nil {
+			in.IfMatch = etag
+		} else {
+			in.IfNoneMatch = aws.String("*")
+		}
+		_, err = s.client.PutObject(ctx, in)
+		if !isPreconditionFailed(err) {
+			return err
+		}
+	}
+}
+
+func (s *s3Store) SetMeta(ctx context.Context, key string, fields map[string]string) error {
+	return s.updateMeta(ctx, key, func(m map[string]string) {
+		for name, v := range fields {
+			m[name] = v
+		}
+	})
+}
+
+func (s *s3Store) GetMeta(ctx context.Context, key string) (map[string]string, error) {
+	fields := map[string]string{}
+	b, _, err := s.read(ctx, s.metaObject(key))
+	if err == sql.ErrNoRows {
+		return fields, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fields, json.Unmarshal(b, &fields)
+}
+
+func (s *s3Store) DeleteMeta(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(s.bucket), Key: s.metaObject(key)})
+	return err
+}
+
+func (s *s3Store) IncrMeta(ctx context.Context, key, name string) error {
+	return s.updateMeta(ctx, key, func(m map[string]string) {
+		var n int64
+		fmt.Sscan(m[name], &n)
+		m[name] = fmt.Sprint(n + 1)
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// migrations lists the schema changes in the order they were introduced.
+// The schema version of a board is the number of migrations applied to it,
+// so new statements must only ever be appended, to sqliteMigrations and
+// postgresMigrations too.
+// %[1]s stands for the key/value table.
+var migrations = []string{
+	// 1: initial key/value table
+	`
+CREATE TABLE IF NOT EXISTS %[1]s (
+  k VARCHAR(255) NOT NULL,
+  v BLOB NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (k)
+);`,
+	// 2: free-form metadata attached to keys
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_meta (
+  k VARCHAR(255) NOT NULL,
+  name VARCHAR(64) NOT NULL,
+  v TEXT NOT NULL,
+  PRIMARY KEY (k, name)
+);`,
+	// 3: when each value was last written, for pb mirror
+	`
+ALTER TABLE %[1]s
+  ADD COLUMN updated_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+  ADD INDEX updated_at (updated_at);`,
+	// 4: keys and metadata in utf8mb4 whatever the server default, so that
+	// emoji and CJK text round-trip; keys compare byte for byte
+	`
+ALTER TABLE %[1]s CONVERT TO CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;`,
+	// 5: the same for metadata
+	`
+ALTER TABLE %[1]s_meta CONVERT TO CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;`,
+	// 6: when values written with pb set --ttl expire, NULL for never
+	`
+ALTER TABLE %[1]s
+  ADD COLUMN expires_at TIMESTAMP(6) NULL DEFAULT NULL,
+  ADD INDEX expires_at (expires_at);`,
+	// 7: values replaced or deleted, for pb history
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_history (
+  k VARCHAR(255) NOT NULL,
+  version INT NOT NULL,
+  v BLOB NOT NULL,
+  updated_at TIMESTAMP(6) NOT NULL,
+  PRIMARY KEY (k, version)
+) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;`,
+	// 8: values over 64KB; larger ones than a statement can carry are
+	// split into chunks
+	`
+ALTER TABLE %[1]s MODIFY v LONGBLOB NOT NULL;`,
+	// 9: the same for history
+	`
+ALTER TABLE %[1]s_history MODIFY v LONGBLOB NOT NULL;`,
+	// 10: the chunks of large values, see putChunks
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_chunks (
+  id VARBINARY(64) NOT NULL,
+  seq INT NOT NULL,
+  v LONGBLOB NOT NULL,
+  PRIMARY KEY (id, seq)
+);`,
+	// 11: who last wrote each value, see Config.User
+	`
+ALTER TABLE %[1]s ADD COLUMN updated_by VARCHAR(255) NULL DEFAULT NULL;`,
+	// 12: every set and delete, for pb audit
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_audit (
+  id BIGINT NOT NULL AUTO_INCREMENT,
+  k VARCHAR(255) NOT NULL,
+  action VARCHAR(16) NOT NULL,
+  value_hash CHAR(64) NULL,
+  old_version INT NULL,
+  changed_by VARCHAR(255) NULL,
+  changed_at TIMESTAMP(6) NOT NULL,
+  PRIMARY KEY (id),
+  INDEX k (k),
+  INDEX changed_at (changed_at)
+) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;`,
+	// 13: who may use pb serve, see pb acl
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_users (
+  name VARCHAR(255) NOT NULL,
+  token_hash CHAR(64) NOT NULL,
+  created_at TIMESTAMP(6) NOT NULL,
+  PRIMARY KEY (name),
+  UNIQUE INDEX token_hash (token_hash)
+) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;`,
+	// 14: the prefixes they may read or write
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_grants (
+  name VARCHAR(255) NOT NULL,
+  prefix VARCHAR(255) NOT NULL,
+  access VARCHAR(16) NOT NULL,
+  PRIMARY KEY (name, prefix)
+) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;`,
+	// 15: bearer tokens for pb serve, see pb token
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_tokens (
+  id VARCHAR(16) NOT NULL,
+  token_hash CHAR(64) NOT NULL,
+  name VARCHAR(255) NULL,
+  prefix VARCHAR(255) NOT NULL,
+  access VARCHAR(16) NOT NULL,
+  created_at TIMESTAMP(6) NOT NULL,
+  expires_at TIMESTAMP(6) NULL,
+  PRIMARY KEY (id),
+  UNIQUE INDEX token_hash (token_hash)
+) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin;`,
+}
+
+// driverMigrations returns the migrations written for the current driver.
+func driverMigrations() []string {
+	switch sqlDriver {
+	case "sqlite":
+		return sqliteMigrations
+	case "postgres":
+		return postgresMigrations
+	}
+	return migrations
+}
+
+// schemaVersion is the schema version this binary expects.
+func schemaVersion() int {
+	return len(driverMigrations())
+}
+
+// schemaTable records the migrations applied to kvTable. Boards using the
+// default table keep the historical postboard_schema name.
+func schemaTable() string {
+	if kvTable == defaultTable {
+		return "postboard_schema"
+	}
+	return kvTable + "_schema"
+}
+
+// currentSchemaVersion returns the schema version recorded in the database,
+// 0 if the board has never been migrated.
+func currentSchemaVersion() (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, "SELECT version FROM "+schemaTable()+" ORDER BY version DESC LIMIT 1").Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
+
+func prepareDatabase() error {
+	var createTblStmt = `
+CREATE TABLE IF NOT EXISTS ` + schemaTable() + ` (
+  version INT NOT NULL,
+  applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (version)
+);`
+	if _, err := db.ExecContext(ctx, createTblStmt); err != nil {
+		return err
+	}
+	current, err := currentSchemaVersion()
+	if err != nil {
+		return err
+	}
+	for version := current + 1; version <= schemaVersion(); version++ {
+		if stmt := driverMigrations()[version-1]; stmt != "" {
+			if _, err := db.ExecContext(ctx, fmt.Sprintf(stmt, kvTable)); err != nil {
+				return fmt.Errorf("migrate schema to version %d: %w", version, err)
+			}
+		}
+		if _, err := db.ExecContext(ctx, "INSERT INTO "+schemaTable()+" (version) VALUES (?)", version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gookit/gcli/v3"
+	"go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// scriptBuiltins are the board operations available to scripts, named after
+// the store operations they call.
+var scriptBuiltins = starlark.StringDict{
+	"get":    starlark.NewBuiltin("get", scriptGet),
+	"put":    starlark.NewBuiltin("put", scriptPut),
+	"delete": starlark.NewBuiltin("delete", scriptDelete),
+	"scan":   starlark.NewBuiltin("scan", scriptScan),
+	"json":   json.Module,
+}
+
+// threadContext returns the context runScript gave thread, which is bound
+// to the transaction of pb script --tx.
+func threadContext(thread *starlark.Thread) context.Context {
+	return thread.Local("ctx").(context.Context)
+}
+
+// get(key, default=None) returns the value of key as a string.
+func scriptGet(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	var def starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "default?", &def); err != nil {
+		return nil, err
+	}
+	value, err := getKey(threadContext(thread), key)
+	if err == sql.ErrNoRows {
+		return def, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(value), nil
+}
+
+// put(key, value) sets key.
+func scriptPut(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key, value string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key, "value", &value); err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, fmt.Errorf("%s: key is empty", b.Name())
+	}
+	return starlark.None, putKeyValue(threadContext(thread), key, []byte(value))
+}
+
+// delete(key) removes key and returns whether it existed.
+func scriptDelete(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var key string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "key", &key); err != nil {
+		return nil, err
+	}
+	deleted, err := deleteKey(threadContext(thread), key)
+	return starlark.Bool(deleted), err
+}
+
+// scan(prefix="") returns the keys starting with prefix.
+func scriptScan(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var prefix string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "prefix?", &prefix); err != nil {
+		return nil, err
+	}
+	keys, err := listKeysWithPrefix(threadContext(thread), prefix)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]starlark.Value, len(keys))
+	for i, key := range keys {
+		list[i] = starlark.String(key)
+	}
+	return starlark.NewList(list), nil
+}
+
+// runScript executes a Starlark program against the board. argv is exposed
+// to the script as a list of strings.
+func runScript(ctx context.Context, filename string, src []byte, argv []string) error {
+	predeclared := starlark.StringDict{}
+	for name, v := range scriptBuiltins {
+		predeclared[name] = v
+	}
+	list := make([]starlark.Value, len(argv))
+	for i, arg := range argv {
+		list[i] = starlark.String(arg)
+	}
+	predeclared["argv"] = starlark.NewList(list)
+
+	thread := &starlark.Thread{
+		Name:  filename,
+		Print: func(_ *starlark.Thread, msg string) { fmt.Println(msg) },
+	}
+	thread.SetLocal("ctx", ctx)
+	// stop the script at the next instruction when pb is interrupted
+	stop := context.AfterFunc(ctx, func() { thread.Cancel("interrupted") })
+	defer stop()
+
+	opts := &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true}
+	_, err := starlark.ExecFileOptions(opts, thread, filename, src, predeclared)
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		return fmt.Errorf("%s", evalErr.Backtrace())
+	}
+	return err
+}
+
+func scriptCommand() *gcli.Command {
+	var useTx bool
+	return &gcli.Command{
+		Name: "script",
+		Desc: "Run a Starlark script with get/put/delete/scan access to the board",
+		Help: `Scripts see these builtins besides the Starlark core:
+
+  get(key, default=None)  value of key as a string
+  put(key, value)         set key
+  delete(key)             remove key, returns whether it existed
+  scan(prefix="")         keys starting with prefix
+  json                    json.encode/json.decode
+  argv                    extra command line arguments
+
+Example, renaming app/* to svc/* and bumping a JSON field:
+
+  for k in scan("app/"):
+      doc = json.decode(get(k))
+      doc["version"] = 2
+      put("svc/" + k[len("app/"):], json.encode(doc))
+      delete(k)`,
+		Config: func(c *gcli.Command) {
+			c.BoolOpt(&useTx, "tx", "", false, "Run the whole script in one transaction, rolled back on error")
+			c.AddArg("file", "The script to run, - for stdin", true)
+			c.AddArg("args", "Arguments passed to the script as argv", false, true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			filename := c.Arg("file").String()
+			var src []byte
+			var err error
+			if filename == "-" {
+				filename = "<stdin>"
+				src, err = io.ReadAll(os.Stdin)
+			} else {
+				src, err = os.ReadFile(filename)
+			}
+			if err != nil {
+				return err
+			}
+			if err := connect(); err != nil {
+				return err
+			}
+			argv := c.Arg("args").Array()
+			if useTx {
+				return inTx(ctx, func(ctx context.Context) error { return runScript(ctx, filename, src, argv) })
+			}
+			return runScript(ctx, filename, src, argv)
+		},
+	}
+}
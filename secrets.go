@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// pulledSecret is one value fetched from an external secrets manager.
+type pulledSecret struct {
+	Name  string
+	Value string
+	// Secret is false for values the source shows in the clear, such as
+	// unmasked Doppler secrets.
+	Secret bool
+	Note   string
+}
+
+// storeSecrets stores each secret at prefix+name in one transaction,
+// recording in metadata whether it is secret so that CI mode masks it. With
+// del, the other keys under prefix are deleted, mirroring the source.
+func storeSecrets(prefix string, secrets []pulledSecret, dryRun, del bool) error {
+	var stale []string
+	if !dryRun || del {
+		if err := connect(); err != nil {
+			return err
+		}
+	}
+	if del {
+		keys, err := listKeysWithPrefix(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		pulled := map[string]bool{}
+		for _, s := range secrets {
+			pulled[prefix+s.Name] = true
+		}
+		for _, key := range keys {
+			if !pulled[key] {
+				stale = append(stale, key)
+			}
+		}
+	}
+	if dryRun {
+		for _, s := range secrets {
+			fmt.Printf("pull %s -> %s\n", s.Name, prefix+s.Name)
+		}
+		for _, key := range stale {
+			fmt.Printf("delete %s\n", key)
+		}
+		return nil
+	}
+	return inTx(ctx, func(ctx context.Context) error {
+		for _, key := range stale {
+			if _, err := deleteKey(ctx, key); err != nil {
+				return err
+			}
+			fmt.Printf("delete %s\n", key)
+		}
+		for _, s := range secrets {
+			key := prefix + s.Name
+			if err := putKeyValue(ctx, key, []byte(s.Value)); err != nil {
+				return err
+			}
+			meta := map[string]string{"type": "plain"}
+			if s.Secret {
+				meta["type"] = "secret"
+			}
+			if s.Note != "" {
+				meta["description"] = s.Note
+			}
+			if err := setMeta(ctx, key, meta); err != nil {
+				return err
+			}
+			fmt.Printf("pull %s -> %s\n", s.Name, key)
+		}
+		return nil
+	})
+}
+
+// newJSONRequest builds an API request with a bearer token and, if body is
+// not nil, a JSON body.
+func newJSONRequest(method, url, token string, body any) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	return req, nil
+}
+
+// doJSON sends req and decodes the JSON response into out.
+func doJSON(req *http.Request, out any) error {
+	return doJSONWith(http.DefaultClient, req, out)
+}
+
+// doJSONWith is doJSON with client.
+func doJSONWith(client *http.Client, req *http.Request, out any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// fetchJSON sends a request with a bearer token and decodes the JSON
+// response into out.
+func fetchJSON(method, url, token string, body any, out any) error {
+	req, err := newJSONRequest(method, url, token, body)
+	if err != nil {
+		return err
+	}
+	return doJSON(req, out)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gookit/gcli/v3"
+	"golang.org/x/net/webdav"
+)
+
+// davPrefix is where the WebDAV tree is mounted.
+const davPrefix = "/dav"
+
+func serveCommand() *gcli.Command {
+	var listen, grpcListen string
+	var withWebDAV bool
+	return &gcli.Command{
+		Name: "serve",
+		Desc: "Serve the board over HTTP",
+		Help: `Serves short links at /s/, notes at /n/, gists at /g/ and the keys
+shared with pb share at /share/, a web UI to browse and edit keys at /ui,
+Prometheus metrics at /metrics, and a JSON API:
+
+  GET    /v1/kv?prefix=app/   list keys
+  GET    /v1/kv/{key}         {"key": ..., "value": ...}
+  PUT    /v1/kv/{key}         set the value to the request body
+  DELETE /v1/kv/{key}         delete the key
+
+Its OpenAPI spec is at /v1/openapi.json, to generate clients; see
+pb openapi.
+
+Once users or tokens are added with pb acl or pb token, requests to the
+API, notes and WebDAV need a token, and only reach the keys it grants.
+With OIDC in the config, the ID tokens of the provider are taken too:
+
+  "OIDC": {"Issuer": "https://login.example.com", "Audience": "<client id>"}
+
+With --grpc, the same operations and a streaming Watch are also served
+over gRPC; see kvpb/kv.proto.
+
+With TLS in the config, pb serve speaks HTTPS and gRPC over TLS, and
+with ClientCAFile only to clients with a certificate from that CA:
+
+  "TLS": {"CertFile": "pb.pem", "KeyFile": "pb-key.pem", "ClientCAFile": "ca.pem"}
+
+RateLimit in the config answers clients making requests too fast, by
+address and by token, with 429 Too Many Requests:
+
+  "RateLimit": {"PerIP": 20, "PerToken": 50, "Burst": 100}
+
+Webhooks in the config are notified of changes while pb serve runs:
+
+  "Webhooks": [{"Prefix": "app/prod/", "URL": "https://ci.example.com/hook", "Secret": "..."}]`,
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&listen, "listen", "l", ":8080", "The address to listen on")
+			c.StrOpt(&grpcListen, "grpc", "", "", "Also serve the gRPC API on this address, e.g. :9090")
+			c.BoolOpt(&withWebDAV, "webdav", "", false, "Expose the keyspace over WebDAV at "+davPrefix+"/")
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			if err := startOIDC(); err != nil {
+				return err
+			}
+			tlsCfg, err := serverTLS()
+			if err != nil {
+				return err
+			}
+			runWebhooks()
+			startRateLimits()
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /s/{code}", shortHandler)
+			mux.HandleFunc("GET /n/{key...}", withPrincipal(noteHandler))
+			mux.HandleFunc("GET /g/{id}", gistHandler)
+			mux.HandleFunc("GET /g/{id}/{name}", gistHandler)
+			mux.HandleFunc("GET "+sharePath+"{token}", shareHandler)
+			registerAPI(mux)
+			registerMetrics(mux)
+			mux.HandleFunc("GET /ui", uiHandler)
+			if withWebDAV {
+				mux.HandleFunc(davPrefix+"/", withPrincipal(davAccess(&webdav.Handler{
+					Prefix:     davPrefix,
+					FileSystem: newKVFS(),
+					LockSystem: webdav.NewMemLS(),
+					Logger: func(r *http.Request, err error) {
+						if err != nil {
+							log.Printf("webdav %s %s: %v", r.Method, r.URL.Path, err)
+						}
+					},
+				})))
+				log.Printf("serving WebDAV at %s://%s%s/", scheme(tlsCfg), listen, davPrefix)
+			}
+			handler := instrumented(rateLimited(mux))
+			if grpcListen == "" {
+				return serveHTTP(listen, handler, tlsCfg)
+			}
+			errc := make(chan error, 2)
+			go func() { errc <- serveHTTP(listen, handler, tlsCfg) }()
+			go func() { errc <- serveGRPC(grpcListen, tlsCfg) }()
+			// either server failing to start stops pb
+			if err := <-errc; err != nil {
+				return err
+			}
+			return <-errc
+		},
+	}
+}
+
+// serveHTTP runs an HTTP server until pb is interrupted, then gives
+// in-flight requests a few seconds to finish. It serves HTTPS if tlsCfg is
+// set.
+func serveHTTP(addr string, handler http.Handler, tlsCfg *tls.Config) error {
+	srv := &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsCfg}
+	log.Printf("listening on %s://%s", scheme(tlsCfg), addr)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	var err error
+	if tlsCfg != nil {
+		// the certificate is in TLSConfig
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func scheme(tlsCfg *tls.Config) string {
+	if tlsCfg != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gookit/gcli/v3"
+)
+
+const (
+	sharePrefix = "shares/"
+	// shareTokenLen makes share tokens as hard to guess as a 128-bit key.
+	shareTokenLen = 22
+	// sharePath is where pb serve redeems share tokens.
+	sharePath = "/share/"
+)
+
+var errShareExpired = errors.New("share token has expired")
+
+// shareRecord is stored at shares/<hash of the token>, so that the token
+// itself, which is all it takes to read the key, is only ever shown once.
+type shareRecord struct {
+	Key       string    `json:"key"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// createShare returns a token that reads key once within ttl.
+func createShare(key string, ttl time.Duration) (string, error) {
+	if _, err := getKey(ctx, key); err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	b, err := json.Marshal(shareRecord{Key: key, CreatedBy: updatedBy, CreatedAt: now, ExpiresAt: now.Add(ttl)})
+	if err != nil {
+		return "", err
+	}
+	token := randomCode(shareTokenLen)
+	return token, putKeyValue(ctx, sharePrefix+hashToken(token), b)
+}
+
+// redeemShare returns the key of token and its current value, deleting the
+// token first so that of concurrent redeemers only one gets the value.
+func redeemShare(token string) (string, []byte, error) {
+	recordKey := sharePrefix + hashToken(token)
+	b, err := getKey(ctx, recordKey)
+	if err != nil {
+		return "", nil, err
+	}
+	deleted, err := deleteKey(ctx, recordKey)
+	if err != nil {
+		return "", nil, err
+	}
+	if !deleted {
+		return "", nil, sql.ErrNoRows
+	}
+	var record shareRecord
+	if err := json.Unmarshal(b, &record); err != nil {
+		return "", nil, fmt.Errorf("share token has a corrupt record: %w", err)
+	}
+	if time.Now().After(record.ExpiresAt) {
+		return "", nil, errShareExpired
+	}
+	value, err := getKey(ctx, record.Key)
+	return record.Key, value, err
+}
+
+// shareHandler serves /share/{token} as the raw value of the key shared,
+// once.
+func shareHandler(w http.ResponseWriter, r *http.Request) {
+	key, value, err := redeemShare(r.PathValue("token"))
+	switch {
+	case err == sql.ErrNoRows:
+		http.Error(w, "no such share token, or it was used already", http.StatusNotFound)
+		return
+	case err == errShareExpired:
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	case err != nil:
+		log.Printf("share: %v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	// the value is a secret and gone from here once read
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", key[strings.LastIndex(key, "/")+1:]))
+	w.Write(value)
+}
+
+// fetchShareURL redeems a share token through pb serve, for recipients
+// who have no config.
+func fetchShareURL(url string) ([]byte, error) {
+	client, err := serveClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func shareCommand() *gcli.Command {
+	ttl := "24h"
+	var server string
+	return &gcli.Command{
+		Name: "share",
+		Desc: "Print a token that lets someone read a key once, without the DSN",
+		Help: `The token expires after --ttl, or once it is used:
+
+  pb share --ttl 1h --server https://pb.example.com db/password
+  pb fetch <token>                                # with access to the board
+  pb fetch https://pb.example.com/share/<token>   # through pb serve
+
+The recipient gets the value of the key at the time they fetch it.`,
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&ttl, "ttl", "", ttl, "How long the token can be used, e.g. 30m, 12h or 3d")
+			c.StrOpt(&server, "server", "", "", "Print the URL to fetch the key from pb serve at this address rather than the token")
+			c.AddArg("key", "The key to share", true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			d, err := parseTTL(ttl)
+			if err != nil {
+				return err
+			}
+			if d <= 0 {
+				return fmt.Errorf("--ttl must be positive")
+			}
+			if err := connect(); err != nil {
+				return err
+			}
+			token, err := createShare(c.Arg("key").String(), d)
+			if err != nil {
+				return err
+			}
+			if server != "" {
+				fmt.Println(strings.TrimSuffix(server, "/") + sharePath + token)
+				return nil
+			}
+			fmt.Println(token)
+			return nil
+		},
+	}
+}
+
+func fetchCommand() *gcli.Command {
+	var output string
+	return &gcli.Command{
+		Name: "fetch",
+		Desc: "Read the key shared with pb share, using up the token",
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&output, "output", "o", "", "Write the value to this file, or to stdout as is with -")
+			c.AddArg("token", "The token from pb share, or its URL on pb serve", true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			token := c.Arg("token").String()
+			var value []byte
+			var err error
+			if strings.HasPrefix(token, "http://") || strings.HasPrefix(token, "https://") {
+				value, err = fetchShareURL(token)
+			} else {
+				if err := connect(); err != nil {
+					return err
+				}
+				_, value, err = redeemShare(token)
+				if err == sql.ErrNoRows {
+					err = fmt.Errorf("no such share token, or it was used already: %w", err)
+				}
+			}
+			if err != nil {
+				return err
+			}
+			switch output {
+			case "-":
+				_, err = os.Stdout.Write(value)
+				return err
+			case "":
+				fmt.Println(string(value))
+				return nil
+			}
+			// values are often credentials
+			return os.WriteFile(output, value, 0600)
+		},
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gookit/gcli/v3"
+)
+
+const (
+	shortPrefix  = "short/"
+	shortCodeLen = 6
+	shortAlpha   = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// randomCode returns n random letters and digits, avoiding ones that are
+// easily confused when read aloud or typed from a screenshot.
+func randomCode(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		b[i] = shortAlpha[int(b[i])%len(shortAlpha)]
+	}
+	return string(b)
+}
+
+// shorten stores target under a new code, or under code if given.
+func shorten(target, code string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q is not an http(s) URL", target)
+	}
+	if code != "" {
+		if strings.ContainsAny(code, "/?#") {
+			return "", fmt.Errorf("code %q must not contain /, ? or #", code)
+		}
+		if _, err := getKey(ctx, shortPrefix+code); err == nil {
+			return "", fmt.Errorf("code %q is already taken", code)
+		} else if err != sql.ErrNoRows {
+			return "", err
+		}
+	} else {
+		for {
+			code = randomCode(shortCodeLen)
+			_, err := getKey(ctx, shortPrefix+code)
+			if err == sql.ErrNoRows {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := putKeyValue(ctx, shortPrefix+code, []byte(target)); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// shortHandler redirects /s/{code} to the stored URL and counts the hit.
+func shortHandler(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+	target, err := getKey(ctx, shortPrefix+code)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("short %s: %v", code, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := incrMeta(ctx, shortPrefix+code, "hits"); err != nil {
+		log.Printf("count hit of %s: %v", code, err)
+	}
+	http.Redirect(w, r, string(target), http.StatusFound)
+}
+
+func shortCommand() *gcli.Command {
+	var code string
+	var stats bool
+	return &gcli.Command{
+		Name: "short",
+		Desc: "Shorten a URL, served as /s/{code} by pb serve",
+		Config: func(c *gcli.Command) {
+			c.StrOpt(&code, "code", "c", "", "Use this code instead of a random one")
+			c.BoolOpt(&stats, "stats", "s", false, "Show the target and hit count of a code instead")
+			c.AddArg("url", "The URL to shorten, or the code with --stats", true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			if stats {
+				code := c.Arg("url").String()
+				target, err := getKey(ctx, shortPrefix+code)
+				if err != nil {
+					return err
+				}
+				meta, err := getMeta(ctx, shortPrefix+code)
+				if err != nil {
+					return err
+				}
+				hits := meta["hits"]
+				if hits == "" {
+					hits = "0"
+				}
+				fmt.Printf("%s -> %s (%s hits)\n", code, target, hits)
+				return nil
+			}
+			code, err := shorten(c.Arg("url").String(), code)
+			if err != nil {
+				return err
+			}
+			fmt.Println(code)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// forceQuitTimeout bounds how long pb waits for in-flight work to wind down
+// after an interrupt before it cleans up and exits anyway.
+const forceQuitTimeout = 10 * time.Second
+
+// ctx is cancelled when pb receives SIGINT or SIGTERM. Database calls and
+// long-running loops use it so an interrupt stops work promptly; transactions
+// begun with it are rolled back by database/sql once it is cancelled.
+var ctx = context.Background()
+
+var (
+	cleanupMu sync.Mutex
+	cleanups  []func()
+)
+
+// onExit registers fn to run before pb exits, including when it is
+// interrupted. Cleanups run in reverse order of registration, so a file can
+// be flushed before the connection it was fed from is closed.
+func onExit(fn func()) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+	cleanups = append(cleanups, fn)
+}
+
+// runCleanups runs the registered cleanups once; later calls are no-ops.
+func runCleanups() {
+	cleanupMu.Lock()
+	fns := cleanups
+	cleanups = nil
+	cleanupMu.Unlock()
+	for i := len(fns) - 1; i >= 0; i-- {
+		fns[i]()
+	}
+}
+
+// handleSignals installs the SIGINT/SIGTERM handler. The first signal
+// cancels ctx and lets the running command return on its own; a second
+// signal, or the command not returning in time, forces an exit after
+// running cleanups.
+func handleSignals() {
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		fmt.Fprintf(os.Stderr, "\nreceived %s, stopping (press Ctrl-C again to force quit)\n", sig)
+		cancel()
+		select {
+		case <-sigs:
+		case <-time.After(forceQuitTimeout):
+		}
+		runCleanups()
+		os.Exit(exitInterrupted)
+	}()
+}
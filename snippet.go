@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gookit/gcli/v3"
+	"github.com/gookit/gcli/v3/gflag"
+)
+
+const snippetPrefix = "snippets/"
+
+type snippet struct {
+	name    string
+	command string
+	desc    string
+	tags    []string
+}
+
+func loadSnippet(name string) (*snippet, error) {
+	command, err := getKey(ctx, snippetPrefix+name)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := getMeta(ctx, snippetPrefix+name)
+	if err != nil {
+		return nil, err
+	}
+	s := &snippet{name: name, command: string(command), desc: meta["description"]}
+	if meta["tags"] != "" {
+		s.tags = strings.Split(meta["tags"], ",")
+	}
+	return s, nil
+}
+
+// fuzzyScore matches the letters of query, in order, anywhere in text and
+// scores the match higher when letters are adjacent or start a word. It
+// returns -1 if text does not contain every letter of query.
+func fuzzyScore(query, text string) int {
+	q := []rune(strings.ToLower(query))
+	t := []rune(strings.ToLower(text))
+	if len(q) == 0 {
+		return 0
+	}
+	score, qi, prev := 0, 0, -2
+	for ti := 0; ti < len(t) && qi < len(q); ti++ {
+		if t[ti] != q[qi] {
+			continue
+		}
+		score++
+		if ti == prev+1 {
+			score += 3
+		}
+		if ti == 0 || !unicode.IsLetter(t[ti-1]) && !unicode.IsDigit(t[ti-1]) {
+			score += 2
+		}
+		prev = ti
+		qi++
+	}
+	if qi < len(q) {
+		return -1
+	}
+	return score
+}
+
+func printSnippet(s *snippet) {
+	fmt.Printf("%s", s.name)
+	if len(s.tags) > 0 {
+		fmt.Printf(" [%s]", strings.Join(s.tags, ", "))
+	}
+	if s.desc != "" {
+		fmt.Printf(" - %s", s.desc)
+	}
+	fmt.Printf("\n    %s\n", strings.ReplaceAll(strings.TrimRight(s.command, "\n"), "\n", "\n    "))
+}
+
+// snippetRecord is a snippet as pb snippet search --format json and yaml
+// print it.
+type snippetRecord struct {
+	Name        string   `json:"name" yaml:"name"`
+	Command     string   `json:"command" yaml:"command"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// printSnippets prints snippets in format: with raw, only their commands.
+func printSnippets(format outputFormat, snippets []*snippet) error {
+	switch format {
+	case formatJSON, formatYAML:
+		records := []snippetRecord{}
+		for _, s := range snippets {
+			records = append(records, snippetRecord{s.name, s.command, s.desc, s.tags})
+		}
+		return printStructured(format, records)
+	case formatTable:
+		rows := make([][]string, len(snippets))
+		for i, s := range snippets {
+			rows[i] = []string{s.name, strings.Join(s.tags, ","), tableCell(s.desc, 40), tableCell(s.command, 60)}
+		}
+		return printTable([]string{"NAME", "TAGS", "DESCRIPTION", "COMMAND"}, rows)
+	}
+	for _, s := range snippets {
+		if format == formatRaw {
+			fmt.Println(strings.TrimRight(s.command, "\n"))
+		} else {
+			printSnippet(s)
+		}
+	}
+	return nil
+}
+
+// shellCommand runs a snippet with the user's shell. Extra arguments become
+// the snippet's positional parameters $1, $2, ...
+func shellCommand(command string, args []string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", append([]string{"/C", command}, args...)...)
+	}
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	return exec.Command(shell, append([]string{"-c", command, "pb-snippet"}, args...)...)
+}
+
+func snippetCommand() *gcli.Command {
+	var desc string
+	var tags gflag.Strings
+	var quiet bool
+	return &gcli.Command{
+		Name: "snippet",
+		Desc: "Share shell snippets as a team cookbook",
+		Subs: []*gcli.Command{
+			{
+				Name: "add",
+				Desc: "Save a snippet, reading the command from stdin if not given",
+				Config: func(c *gcli.Command) {
+					c.StrOpt(&desc, "desc", "d", "", "What the snippet does")
+					c.VarOpt(&tags, "tag", "t", "A tag to find the snippet by, may be repeated")
+					c.AddArg("name", "The name of the snippet", true)
+					c.AddArg("command", "The shell command", false)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					name := c.Arg("name").String()
+					command := c.Arg("command").String()
+					if command == "" {
+						b, err := io.ReadAll(os.Stdin)
+						if err != nil {
+							return err
+						}
+						command = string(b)
+					}
+					if strings.TrimSpace(command) == "" {
+						return fmt.Errorf("command is empty")
+					}
+					for _, tag := range tags {
+						if strings.Contains(tag, ",") {
+							return fmt.Errorf("tag %q must not contain a comma", tag)
+						}
+					}
+					if err := connect(); err != nil {
+						return err
+					}
+					if err := putKeyValue(ctx, snippetPrefix+name, []byte(command)); err != nil {
+						return err
+					}
+					return setMeta(ctx, snippetPrefix+name, map[string]string{
+						"description": desc,
+						"tags":        strings.Join(tags, ","),
+					})
+				},
+			},
+			{
+				Name: "search",
+				Desc: "Fuzzy-search snippets by name, tag and description",
+				Config: func(c *gcli.Command) {
+					c.AddArg("query", "What to look for, empty to list all", false)
+					formatOpt(c, "the snippets", formatText)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					if err := connect(); err != nil {
+						return err
+					}
+					keys, err := listKeysWithPrefix(ctx, snippetPrefix)
+					if err != nil {
+						return err
+					}
+					query := c.Arg("query").String()
+					type match struct {
+						s     *snippet
+						score int
+					}
+					var matches []match
+					for _, key := range keys {
+						s, err := loadSnippet(strings.TrimPrefix(key, snippetPrefix))
+						if err != nil {
+							return err
+						}
+						best := -1
+						for _, text := range append([]string{s.name, s.desc}, s.tags...) {
+							best = max(best, fuzzyScore(query, text))
+						}
+						if best >= 0 {
+							matches = append(matches, match{s, best})
+						}
+					}
+					sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+					snippets := make([]*snippet, len(matches))
+					for i, m := range matches {
+						snippets[i] = m.s
+					}
+					return printSnippets(commandFormat(formatText), snippets)
+				},
+			},
+			{
+				Name: "run",
+				Desc: "Run a snippet, passing extra arguments as $1, $2, ...",
+				Config: func(c *gcli.Command) {
+					c.BoolOpt(&quiet, "quiet", "q", false, "Don't echo the command before running it")
+					c.AddArg("name", "The name of the snippet", true)
+					c.AddArg("args", "Arguments for the snippet", false, true)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					if err := connect(); err != nil {
+						return err
+					}
+					s, err := loadSnippet(c.Arg("name").String())
+					if err != nil {
+						return err
+					}
+					if !quiet {
+						fmt.Fprintf(os.Stderr, "$ %s\n", strings.TrimRight(s.command, "\n"))
+					}
+					cmd := shellCommand(s.command, c.Arg("args").Array())
+					cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+					err = cmd.Run()
+					var exitErr *exec.ExitError
+					if errors.As(err, &exitErr) {
+						childExitCode = exitErr.ExitCode()
+						return nil
+					}
+					return err
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations mirrors migrations for the sqlite driver so that schema
+// versions mean the same thing on both. An empty statement is a migration
+// that sqlite does not need.
+var sqliteMigrations = []string{
+	// 1: initial key/value table
+	`
+CREATE TABLE IF NOT EXISTS %[1]s (
+  k TEXT NOT NULL PRIMARY KEY,
+  v BLOB NOT NULL,
+  created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);`,
+	// 2: free-form metadata attached to keys
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_meta (
+  k TEXT NOT NULL,
+  name TEXT NOT NULL,
+  v TEXT NOT NULL,
+  PRIMARY KEY (k, name)
+);`,
+	// 3: when each value was last written; sqlite only allows constant
+	// defaults here, writes always set it
+	`
+ALTER TABLE %[1]s ADD COLUMN updated_at TEXT NOT NULL DEFAULT '1970-01-01 00:00:00';
+CREATE INDEX %[1]s_updated_at ON %[1]s (updated_at);`,
+	// 4, 5: sqlite text is always UTF-8
+	"",
+	"",
+	// 6: when values written with pb set --ttl expire, NULL for never
+	`
+ALTER TABLE %[1]s ADD COLUMN expires_at TEXT;
+CREATE INDEX %[1]s_expires_at ON %[1]s (expires_at);`,
+	// 7: values replaced or deleted, for pb history
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_history (
+  k TEXT NOT NULL,
+  version INTEGER NOT NULL,
+  v BLOB NOT NULL,
+  updated_at TEXT NOT NULL,
+  PRIMARY KEY (k, version)
+);`,
+	// 8, 9: BLOB has no 64KB limit here
+	"",
+	"",
+	// 10: the chunks of large values, see putChunks
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_chunks (
+  id BLOB NOT NULL,
+  seq INTEGER NOT NULL,
+  v BLOB NOT NULL,
+  PRIMARY KEY (id, seq)
+);`,
+	// 11: who last wrote each value, see Config.User
+	`
+ALTER TABLE %[1]s ADD COLUMN updated_by TEXT;`,
+	// 12: every set and delete, for pb audit
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_audit (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  k TEXT NOT NULL,
+  action TEXT NOT NULL,
+  value_hash TEXT,
+  old_version INTEGER,
+  changed_by TEXT,
+  changed_at TEXT NOT NULL
+);
+CREATE INDEX %[1]s_audit_k ON %[1]s_audit (k);
+CREATE INDEX %[1]s_audit_changed_at ON %[1]s_audit (changed_at);`,
+	// 13: who may use pb serve, see pb acl
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_users (
+  name TEXT NOT NULL PRIMARY KEY,
+  token_hash TEXT NOT NULL UNIQUE,
+  created_at TEXT NOT NULL
+);`,
+	// 14: the prefixes they may read or write
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_grants (
+  name TEXT NOT NULL,
+  prefix TEXT NOT NULL,
+  access TEXT NOT NULL,
+  PRIMARY KEY (name, prefix)
+);`,
+	// 15: bearer tokens for pb serve, see pb token
+	`
+CREATE TABLE IF NOT EXISTS %[1]s_tokens (
+  id TEXT NOT NULL PRIMARY KEY,
+  token_hash TEXT NOT NULL UNIQUE,
+  name TEXT,
+  prefix TEXT NOT NULL,
+  access TEXT NOT NULL,
+  created_at TEXT NOT NULL,
+  expires_at TEXT
+);`,
+}
+
+// openSQLite opens the database file at path, creating it if needed.
+func openSQLite(path string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	// wait for other pb processes instead of failing with SQLITE_BUSY
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	// keys are case sensitive, as they are in utf8mb4_bin on MySQL
+	params.Add("_pragma", "case_sensitive_like(1)")
+	params.Set("_txlock", "immediate")
+	return sql.Open("sqlite", "file:"+filepath.ToSlash(path)+"?"+params.Encode())
+}
+
+// sqlitePath returns the database file of cfg with a leading ~ expanded.
+func sqlitePath(cfg *Config) string {
+	path := cfg.Path
+	if rest, ok := strings.CutPrefix(path, "~"); ok {
+		home, _ := os.UserHomeDir()
+		path = filepath.Join(home, rest)
+	}
+	return path
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Store holds the keys and values of a board. Keys given to a Store
+// already include the namespace. putKeyValue and friends add hooks,
+// encryption, caching and tracing on top, so a Store only moves bytes.
+type Store interface {
+	// Put creates or replaces the value of key.
+	Put(ctx context.Context, key string, value []byte) error
+	// Get returns the value of key, or sql.ErrNoRows if there is none.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// List returns up to limit keys starting with prefix that sort after
+	// after, in order, so that callers can page through them.
+	List(ctx context.Context, prefix, after string, limit int) ([]string, error)
+	// Delete removes key and reports whether it existed.
+	Delete(ctx context.Context, key string) (bool, error)
+}
+
+// MetaStore holds the metadata of keys, see setMeta. Keys include the
+// namespace, as for Store.
+type MetaStore interface {
+	// SetMeta sets the given fields of key, leaving others alone.
+	SetMeta(ctx context.Context, key string, fields map[string]string) error
+	// GetMeta returns all fields of key, empty if it has none.
+	GetMeta(ctx context.Context, key string) (map[string]string, error)
+	// DeleteMeta removes all fields of key.
+	DeleteMeta(ctx context.Context, key string) error
+	// IncrMeta atomically adds one to the counter in field name.
+	IncrMeta(ctx context.Context, key, name string) error
+}
+
+// expiringStore is implemented by stores that can delete a key by
+// themselves once it has lived for ttl, see pb set --ttl.
+type expiringStore interface {
+	PutWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// maxValueSize is the largest value, as stored, that the current driver
+// accepts: a LONGBLOB column on MySQL, and the limits of the server or
+// format elsewhere. SQL drivers split values larger than chunkSize into
+// several rows, so these are not bound by the size of a statement.
+func maxValueSize() int64 {
+	switch sqlDriver {
+	case "mysql":
+		return 1<<32 - 1
+	case "postgres":
+		return 1<<30 - 1
+	case "sqlite":
+		return 1e9
+	case "redis":
+		return 512 << 20
+	case "tikv":
+		// the default raft-entry-max-size
+		return 8 << 20
+	}
+	return 5 << 30
+}
+
+// store and metaStore are what the key/value and metadata helpers use,
+// set up by connect.
+var (
+	store     Store     = sqlStore{}
+	metaStore MetaStore = sqlStore{}
+)
+
+// sqlStore keeps keys in kvTable, on MySQL, sqlite or postgres. It runs
+// its statements on queryerFor(ctx), so it takes part in the transaction
+// inTx bound ctx to.
+// Expired rows are skipped until pb gc deletes them.
+type sqlStore struct{}
+
+func (s sqlStore) Put(ctx context.Context, key string, value []byte) error {
+	return s.put(ctx, key, value, "NULL")
+}
+
+func (s sqlStore) PutWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.put(ctx, key, value, afterNow(), ttl.Microseconds())
+}
+
+// put writes value to key with expires_at set to expires, given its args,
+// together with its chunks, its audit record and the history of the value
+// it replaces, all in one transaction.
+func (s sqlStore) put(ctx context.Context, key string, value []byte, expires string, args ...any) error {
+	return inTx(ctx, func(ctx context.Context) error {
+		tx := queryerFor(ctx)
+		if len(value) > chunkSize {
+			ref, err := putChunks(ctx, tx, value)
+			if err != nil {
+				return err
+			}
+			value = ref
+		}
+		if err := s.auditPut(ctx, key, value); err != nil {
+			return err
+		}
+		if err := s.archive(ctx, key, value); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO `+kvTable+` (k, v, updated_at, updated_by, expires_at) VALUES (?, ?, `+currentTimestamp()+`, ?, `+expires+`)`+
+			onConflict("k", "v = "+inserted("v")+", updated_at = "+currentTimestamp()+", updated_by = "+inserted("updated_by")+", expires_at = "+inserted("expires_at")),
+			append([]any{key, value, updatedBy}, args...)...)
+		return err
+	})
+}
+
+func (sqlStore) Get(ctx context.Context, key string) (value []byte, err error) {
+	if err = queryerFor(ctx).QueryRowContext(ctx, `SELECT v FROM `+kvTable+` WHERE k = ? AND `+notExpired(), key).Scan(&value); err != nil {
+		return nil, err
+	}
+	return readChunks(ctx, queryerFor(ctx), value)
+}
+
+func (sqlStore) List(ctx context.Context, prefix, after string, limit int) ([]string, error) {
+	rows, err := queryerFor(ctx).QueryContext(ctx, "SELECT k FROM "+kvTable+" WHERE "+hasPrefix("k")+" AND k > ? AND "+notExpired()+" ORDER BY k LIMIT ?", likePrefix(prefix), after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s sqlStore) Delete(ctx context.Context, key string) (bool, error) {
+	deleted := false
+	err := inTx(ctx, func(ctx context.Context) error {
+		if err := s.auditDelete(ctx, key); err != nil {
+			return err
+		}
+		if err := s.archive(ctx, key, nil); err != nil {
+			return err
+		}
+		res, err := queryerFor(ctx).ExecContext(ctx, "DELETE FROM "+kvTable+" WHERE k = ?", key)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		deleted = n > 0
+		return err
+	})
+	return deleted, err
+}
+
+func (sqlStore) SetMeta(ctx context.Context, key string, fields map[string]string) error {
+	stmt := `INSERT INTO ` + metaTable() + ` (k, name, v) VALUES (?, ?, ?)` + onConflict("k, name", "v = "+inserted("v"))
+	for name, v := range fields {
+		if _, err := queryerFor(ctx).ExecContext(ctx, stmt, key, name, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sqlStore) GetMeta(ctx context.Context, key string) (map[string]string, error) {
+	rows, err := queryerFor(ctx).QueryContext(ctx, "SELECT name, v FROM "+metaTable()+" WHERE k = ?", key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	fields := map[string]string{}
+	for rows.Next() {
+		var name, v string
+		if err := rows.Scan(&name, &v); err != nil {
+			return nil, err
+		}
+		fields[name] = v
+	}
+	return fields, rows.Err()
+}
+
+func (sqlStore) DeleteMeta(ctx context.Context, key string) error {
+	_, err := queryerFor(ctx).ExecContext(ctx, "DELETE FROM "+metaTable()+" WHERE k = ?", key)
+	return err
+}
+
+func (sqlStore) IncrMeta(ctx context.Context, key, name string) error {
+	stmt := `INSERT INTO ` + metaTable() + ` (k, name, v) VALUES (?, ?, '1')` + onConflict("k, name", "v = "+castInt("v")+" + 1")
+	_, err := queryerFor(ctx).ExecContext(ctx, stmt, key, name)
+	return err
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gookit/gcli/v3"
+	"github.com/gookit/gcli/v3/gflag"
+)
+
+// syncStateFile records, inside the synced directory, the hash of every
+// file as of the last sync. It is the common ancestor that tells a local
+// edit apart from a remote one.
+const syncStateFile = ".pbsync.json"
+
+type syncState struct {
+	Prefix string            `json:"prefix"`
+	Hashes map[string]string `json:"hashes"`
+}
+
+type syncer struct {
+	dir     string
+	prefix  string
+	ignores []string
+	dryRun  bool
+}
+
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// matchAny reports whether the slash separated path rel, or its base name,
+// matches one of the glob patterns.
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *syncer) ignored(rel string) bool {
+	return rel == syncStateFile || matchAny(s.ignores, rel)
+}
+
+func (s *syncer) loadState() (*syncState, error) {
+	state := &syncState{Prefix: s.prefix, Hashes: map[string]string{}}
+	b, err := os.ReadFile(filepath.Join(s.dir, syncStateFile))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, fmt.Errorf("%s: %w", syncStateFile, err)
+	}
+	if state.Prefix != s.prefix {
+		return nil, fmt.Errorf("%s was last synced with %q, not %q; remove %s to start over",
+			s.dir, state.Prefix, s.prefix, filepath.Join(s.dir, syncStateFile))
+	}
+	return state, nil
+}
+
+func (s *syncer) saveState(state *syncState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dir, syncStateFile), b, 0644)
+}
+
+// localHashes hashes every file under the directory, keyed by slash
+// separated relative path.
+func (s *syncer) localHashes() (map[string]string, error) {
+	hashes := map[string]string{}
+	err := filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if s.ignored(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		hashes[rel] = hashBytes(b)
+		return nil
+	})
+	return hashes, err
+}
+
+func (s *syncer) remoteHashes() (map[string]string, error) {
+	keys, err := listKeysWithPrefix(ctx, s.prefix)
+	if err != nil {
+		return nil, err
+	}
+	hashes := map[string]string{}
+	for _, key := range keys {
+		rel := strings.TrimPrefix(key, s.prefix)
+		if rel == "" || s.ignored(rel) {
+			continue
+		}
+		value, err := getKey(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		hashes[rel] = hashBytes(value)
+	}
+	return hashes, nil
+}
+
+// run syncs once and returns the number of conflicts left unresolved.
+func (s *syncer) run() (conflicts int, err error) {
+	state, err := s.loadState()
+	if err != nil {
+		return 0, err
+	}
+	local, err := s.localHashes()
+	if err != nil {
+		return 0, err
+	}
+	remote, err := s.remoteHashes()
+	if err != nil {
+		return 0, err
+	}
+
+	paths := map[string]bool{}
+	for _, m := range []map[string]string{local, remote, state.Hashes} {
+		for p := range m {
+			paths[p] = true
+		}
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	for _, rel := range sorted {
+		if err := ctx.Err(); err != nil {
+			return conflicts, err
+		}
+		l, r, base := local[rel], remote[rel], state.Hashes[rel]
+		// synced is what both sides hold once the action is done
+		synced := l
+		var err error
+		switch {
+		case l == r:
+			// in sync, possibly changed identically on both sides
+		case r == base:
+			err = s.push(rel, l == "")
+		case l == base:
+			err = s.pull(rel, r == "")
+			synced = r
+		default:
+			conflicts++
+			fmt.Printf("conflict %s: changed both locally and in %s, resolve by hand\n", rel, s.prefix)
+			continue
+		}
+		if err != nil {
+			return conflicts, err
+		}
+		if synced == "" {
+			delete(state.Hashes, rel)
+		} else {
+			state.Hashes[rel] = synced
+		}
+	}
+	if s.dryRun {
+		return conflicts, nil
+	}
+	return conflicts, s.saveState(state)
+}
+
+func (s *syncer) push(rel string, deleted bool) error {
+	key := s.prefix + rel
+	if deleted {
+		fmt.Printf("delete %s\n", key)
+		if s.dryRun {
+			return nil
+		}
+		_, err := deleteKey(ctx, key)
+		return err
+	}
+	fmt.Printf("push %s -> %s\n", rel, key)
+	if s.dryRun {
+		return nil
+	}
+	b, err := os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+	return putKeyValue(ctx, key, b)
+}
+
+func (s *syncer) pull(rel string, deleted bool) error {
+	p := filepath.Join(s.dir, filepath.FromSlash(rel))
+	if deleted {
+		fmt.Printf("remove %s\n", p)
+		if s.dryRun {
+			return nil
+		}
+		return os.Remove(p)
+	}
+	fmt.Printf("pull %s%s -> %s\n", s.prefix, rel, p)
+	if s.dryRun {
+		return nil
+	}
+	value, err := getKey(ctx, s.prefix+rel)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, value, 0644)
+}
+
+func syncCommand() *gcli.Command {
+	var watch, dryRun bool
+	interval := Duration{5 * time.Second}
+	var ignores gflag.Strings
+	return &gcli.Command{
+		Name: "sync",
+		Desc: "Mirror a local directory to a key prefix in both directions",
+		Help: `Files changed locally are pushed, keys changed on the board are pulled,
+and a path changed on both sides since the last sync is reported as a
+conflict and left alone. The last synced state is kept in ` + syncStateFile + `
+inside the directory.`,
+		Config: func(c *gcli.Command) {
+			c.BoolOpt(&watch, "watch", "w", false, "Keep syncing until interrupted")
+			c.VarOpt(&interval, "interval", "", "How often to sync with --watch")
+			c.VarOpt(&ignores, "ignore", "", "Glob of paths to leave alone, may be repeated")
+			c.BoolOpt(&dryRun, "dry-run", "n", false, "Print what would be done without changing anything")
+			c.AddArg("dir", "The local directory", true)
+			c.AddArg("prefix", "The key prefix, e.g. app/prod/", true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			s := &syncer{
+				dir:     c.Arg("dir").String(),
+				prefix:  c.Arg("prefix").String(),
+				ignores: ignores,
+				dryRun:  dryRun,
+			}
+			if s.prefix != "" && !strings.HasSuffix(s.prefix, "/") {
+				s.prefix += "/"
+			}
+			if err := os.MkdirAll(s.dir, 0755); err != nil {
+				return err
+			}
+			if err := connect(); err != nil {
+				return err
+			}
+			for {
+				conflicts, err := s.run()
+				if err != nil {
+					return err
+				}
+				if !watch {
+					if conflicts > 0 {
+						return fmt.Errorf("%d conflicts", conflicts)
+					}
+					return nil
+				}
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-time.After(interval.Duration):
+				}
+			}
+		},
+	}
+}
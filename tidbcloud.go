@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	tidbCloudAPI = "https://serverless.tidbapi.com/v1beta1"
+	// tidbCloudCA is the root TiDB Cloud Serverless certificates chain to.
+	tidbCloudCA = "https://letsencrypt.org/certs/isrgrootx1.pem"
+)
+
+// tidbCloudClient calls the TiDB Cloud Serverless API, which authenticates
+// API keys with HTTP digest auth.
+type tidbCloudClient struct {
+	publicKey, privateKey string
+}
+
+type tidbCluster struct {
+	ClusterID   string `json:"clusterId"`
+	DisplayName string `json:"displayName"`
+	State       string `json:"state"`
+	UserPrefix  string `json:"userPrefix"`
+	Endpoints   struct {
+		Public struct {
+			Host string `json:"host"`
+			Port int    `json:"port"`
+		} `json:"public"`
+	} `json:"endpoints"`
+}
+
+func (t *tidbCloudClient) do(method, path string, body, out any) error {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	send := func(auth string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, tidbCloudAPI+path, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		return http.DefaultClient.Do(req)
+	}
+	resp, err := send("")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		auth, err := t.digest(resp.Header.Get("WWW-Authenticate"), method, path)
+		if err != nil {
+			return err
+		}
+		if resp, err = send(auth); err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("TiDB Cloud %s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// digest answers an HTTP digest challenge (RFC 7616, MD5 with qop=auth).
+func (t *tidbCloudClient) digest(challenge, method, path string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Digest") {
+		return "", fmt.Errorf("TiDB Cloud rejected the API key")
+	}
+	p := map[string]string{}
+	for _, kv := range strings.Split(params, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		p[k] = strings.Trim(v, `"`)
+	}
+	h := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	uri := "/v1beta1" + path
+	cnonce := randomCode(16)
+	ha1 := h(t.publicKey + ":" + p["realm"] + ":" + t.privateKey)
+	ha2 := h(method + ":" + uri)
+	response := h(strings.Join([]string{ha1, p["nonce"], "00000001", cnonce, "auth", ha2}, ":"))
+	auth := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", qop=auth, nc=00000001, cnonce="%s", response="%s"`,
+		t.publicKey, p["realm"], p["nonce"], uri, cnonce, response)
+	if p["opaque"] != "" {
+		auth += fmt.Sprintf(`, opaque="%s"`, p["opaque"])
+	}
+	return auth, nil
+}
+
+// findOrCreateCluster returns the cluster named name, creating it in region
+// if there is none, once it is ready to accept connections.
+func (t *tidbCloudClient) findOrCreateCluster(name, region string) (cluster *tidbCluster, created bool, err error) {
+	var list struct {
+		Clusters []*tidbCluster `json:"clusters"`
+	}
+	if err := t.do("GET", "/clusters?pageSize=100", nil, &list); err != nil {
+		return nil, false, err
+	}
+	for _, c := range list.Clusters {
+		if c.DisplayName == name {
+			cluster = c
+		}
+	}
+	if cluster == nil {
+		fmt.Printf("Creating TiDB Cloud Serverless cluster %s in %s...\n", name, region)
+		body := map[string]any{"displayName": name, "region": map[string]string{"name": "regions/" + region}}
+		if err := t.do("POST", "/clusters", body, &cluster); err != nil {
+			return nil, false, err
+		}
+		created = true
+	}
+	for cluster.State != "ACTIVE" {
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case <-time.After(3 * time.Second):
+		}
+		if err := t.do("GET", "/clusters/"+cluster.ClusterID, nil, &cluster); err != nil {
+			return nil, false, err
+		}
+	}
+	return cluster, created, nil
+}
+
+// setupTiDBCloud finds or creates a cluster and returns a profile that
+// connects to it as root.
+func setupTiDBCloud(name, region string) (*Config, error) {
+	t := &tidbCloudClient{publicKey: os.Getenv("TIDB_CLOUD_PUBLIC_KEY"), privateKey: os.Getenv("TIDB_CLOUD_PRIVATE_KEY")}
+	if t.publicKey == "" || t.privateKey == "" {
+		return nil, errors.New("set TIDB_CLOUD_PUBLIC_KEY and TIDB_CLOUD_PRIVATE_KEY to an API key, created under Organization Settings > API Keys")
+	}
+	cluster, created, err := t.findOrCreateCluster(name, region)
+	if err != nil {
+		return nil, err
+	}
+	password := os.Getenv("TIDB_CLOUD_PASSWORD")
+	if created {
+		// a new cluster has no root password until one is set
+		b := make([]byte, 16)
+		rand.Read(b)
+		password = hex.EncodeToString(b)
+		if err := t.do("PUT", "/clusters/"+cluster.ClusterID+"/password", map[string]string{"password": password}, nil); err != nil {
+			return nil, err
+		}
+	} else if password == "" {
+		if ciMode {
+			return nil, fmt.Errorf("cluster %s exists, set TIDB_CLOUD_PASSWORD to its root password", name)
+		}
+		fmt.Printf("Cluster %s exists, enter its root password:\n", name)
+		if password, err = readPassword(); err != nil {
+			return nil, err
+		}
+	}
+
+	caFile := filepath.Join(filepath.Dir(configFilePath), "tidb-cloud-ca.pem")
+	if err := download(tidbCloudCA, caFile); err != nil {
+		return nil, fmt.Errorf("fetch CA: %w", err)
+	}
+	dsn := mysql.NewConfig()
+	dsn.User = cluster.UserPrefix + ".root"
+	dsn.Passwd = password
+	dsn.Net = "tcp"
+	dsn.Addr = fmt.Sprintf("%s:%d", cluster.Endpoints.Public.Host, cluster.Endpoints.Public.Port)
+	dsn.DBName = "test"
+	return &Config{DSN: dsn.FormatDSN(), CAFile: caFile}, nil
+}
+
+// addTiDBCloudProfile writes a profile named name for the cluster to the
+// config file, selecting it unless the file already has a working setup.
+func addTiDBCloudProfile(cluster, region, name string) error {
+	profile, err := setupTiDBCloud(cluster, region)
+	if err != nil {
+		return err
+	}
+	config := &Config{}
+	if f, err := os.Open(configFilePath); err == nil {
+		config, err = decodeConfig(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if config.Profiles == nil {
+		config.Profiles = map[string]*Config{}
+	}
+	config.Profiles[name] = profile
+	if config.DSN == "" && config.Profile == "" {
+		config.Profile = name
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	if err := saveConfigToFile(config, configFilePath); err != nil {
+		return err
+	}
+	fmt.Printf("Saved profile %s for cluster %s to %s\n", name, cluster, configFilePath)
+	if config.Profile != name {
+		fmt.Printf("Set \"Profile\": %q in the config to use it by default\n", name)
+	}
+	return nil
+}
+
+func download(url, path string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// tikvScanLimit is the most keys TiKV returns from one scan.
+const tikvScanLimit = 10240
+
+// isTiKVDSN reports whether dsn is a tikv:// list of PD endpoints, which
+// selects the tikv driver when Driver is not set.
+func isTiKVDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, "tikv://")
+}
+
+// pdAddrs returns the PD endpoints of a DSN such as
+// tikv://pd1:2379,pd2:2379.
+func pdAddrs(dsn string) ([]string, error) {
+	list := strings.TrimSuffix(strings.TrimPrefix(dsn, "tikv://"), "/")
+	var addrs []string
+	for _, addr := range strings.Split(list, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	if !isTiKVDSN(dsn) || len(addrs) == 0 {
+		return nil, fmt.Errorf("expected tikv://pd1:2379,pd2:2379")
+	}
+	return addrs, nil
+}
+
+// prefixEnd returns the first key after every key starting with prefix.
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// prefix is all 0xff, scan to the end
+	return nil
+}
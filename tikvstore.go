@@ -0,0 +1,147 @@
+//go:build !darwin || cgo
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/pingcap/log"
+	"github.com/tikv/client-go/v2/config"
+	"github.com/tikv/client-go/v2/rawkv"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+)
+
+// tikvStore talks to TiKV's raw key/value API through PD, without a SQL
+// layer. Keys live under the table name so that boards using different
+// Tables can share a cluster. There are no transactions: inTx applies
+// writes one by one.
+type tikvStore struct {
+	client *rawkv.Client
+}
+
+func newTiKVStore(cfg *Config) (*tikvStore, error) {
+	addrs, err := pdAddrs(cfg.DSN)
+	if err != nil {
+		return nil, fieldErrorf("DSN", "%v", err)
+	}
+	// the client logs every retry to stderr; pb reports the final error
+	log.ReplaceGlobals(zap.NewNop(), nil)
+	ctx, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout.Duration)
+	defer cancel()
+	client, err := rawkv.NewClientWithOpts(ctx, addrs,
+		rawkv.WithSecurity(config.Security{ClusterSSLCA: cfg.CAFile}),
+		// the PD client retries for minutes by default
+		rawkv.WithPDOptions(pd.WithCustomTimeoutOption(cfg.ConnectTimeout.Duration), pd.WithMaxErrorRetry(3)))
+	if err != nil {
+		return nil, err
+	}
+	// IncrMeta needs compare-and-swap, which TiKV only guarantees if every
+	// write to the key goes through the atomic path
+	client.SetAtomicForCAS(true)
+	return &tikvStore{client}, nil
+}
+
+func (s *tikvStore) key(key string) []byte {
+	return []byte(kvTable + "\x00" + key)
+}
+
+// metaKey is where field name of key is kept. The separator after key
+// keeps the fields of a from showing up in a scan for those of ab.
+func (s *tikvStore) metaKey(key, name string) []byte {
+	return []byte(metaTable() + "\x00" + key + "\x00" + name)
+}
+
+func (s *tikvStore) Close() error {
+	return s.client.Close()
+}
+
+func (s *tikvStore) ClusterID() uint64 {
+	return s.client.ClusterID()
+}
+
+func (s *tikvStore) Put(ctx context.Context, key string, value []byte) error {
+	return s.client.Put(ctx, s.key(key), value)
+}
+
+func (s *tikvStore) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.client.Get(ctx, s.key(key))
+	if err == nil && value == nil {
+		return nil, sql.ErrNoRows
+	}
+	return value, err
+}
+
+func (s *tikvStore) List(ctx context.Context, prefix, after string, limit int) ([]string, error) {
+	start := s.key(prefix)
+	end := prefixEnd(start)
+	if after != "" && bytes.Compare(s.key(after), start) >= 0 {
+		// the smallest key after it
+		start = append(s.key(after), 0)
+	}
+	keys, _, err := s.client.Scan(ctx, start, end, min(limit, tikvScanLimit), rawkv.ScanKeyOnly())
+	if err != nil {
+		return nil, err
+	}
+	list := make([]string, len(keys))
+	for i, k := range keys {
+		list[i] = string(k[len(kvTable)+1:])
+	}
+	return list, nil
+}
+
+func (s *tikvStore) Delete(ctx context.Context, key string) (bool, error) {
+	value, err := s.client.Get(ctx, s.key(key))
+	if err != nil || value == nil {
+		return false, err
+	}
+	return true, s.client.Delete(ctx, s.key(key))
+}
+
+func (s *tikvStore) SetMeta(ctx context.Context, key string, fields map[string]string) error {
+	for name, v := range fields {
+		if err := s.client.Put(ctx, s.metaKey(key, name), []byte(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *tikvStore) GetMeta(ctx context.Context, key string) (map[string]string, error) {
+	start := s.metaKey(key, "")
+	keys, values, err := s.client.Scan(ctx, start, prefixEnd(start), tikvScanLimit)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]string{}
+	for i, k := range keys {
+		fields[string(k[len(start):])] = string(values[i])
+	}
+	return fields, nil
+}
+
+func (s *tikvStore) DeleteMeta(ctx context.Context, key string) error {
+	start := s.metaKey(key, "")
+	return s.client.DeleteRange(ctx, start, prefixEnd(start))
+}
+
+func (s *tikvStore) IncrMeta(ctx context.Context, key, name string) error {
+	k := s.metaKey(key, name)
+	for {
+		old, err := s.client.Get(ctx, k)
+		if err != nil {
+			return err
+		}
+		var n int64
+		if old != nil {
+			fmt.Sscan(string(old), &n)
+		}
+		_, ok, err := s.client.CompareAndSwap(ctx, k, old, fmt.Appendf(nil, "%d", n+1))
+		if err != nil || ok {
+			return err
+		}
+	}
+}
@@ -0,0 +1,24 @@
+//go:build darwin && !cgo
+
+package main
+
+import "errors"
+
+// tikvStore is unavailable: the TiKV client reads system statistics
+// through cgo on macOS.
+type tikvStore struct {
+	Store
+	MetaStore
+}
+
+func newTiKVStore(cfg *Config) (*tikvStore, error) {
+	return nil, errors.New("the tikv driver needs a pb built with cgo on macOS")
+}
+
+func (s *tikvStore) Close() error {
+	return nil
+}
+
+func (s *tikvStore) ClusterID() uint64 {
+	return 0
+}
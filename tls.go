@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"os"
+)
+
+// TLSConfig has pb serve speak HTTPS, and gRPC over TLS, and pb talk to
+// it so, as pb fetch does with a URL. For mutual TLS between sites, each
+// side gets a certificate from the same CA:
+//
+//	"TLS": {"CertFile": "pb.pem", "KeyFile": "pb-key.pem", "CAFile": "ca.pem", "ClientCAFile": "ca.pem"}
+type TLSConfig struct {
+	// CertFile and KeyFile are a PEM certificate and its key: the one pb
+	// serve presents, and the client certificate pb presents to pb serve.
+	CertFile string `json:"CertFile,omitempty"`
+	KeyFile  string `json:"KeyFile,omitempty"`
+	// ClientCAFile has pb serve require a client certificate signed by a
+	// CA in this PEM bundle.
+	ClientCAFile string `json:"ClientCAFile,omitempty"`
+	// CAFile is a PEM bundle the certificate of pb serve is verified
+	// against instead of the system roots.
+	CAFile string `json:"CAFile,omitempty"`
+}
+
+func (t *TLSConfig) validate(field string) error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fieldErrorf(field+".KeyFile", "CertFile and KeyFile must be set together")
+	}
+	if t.ClientCAFile != "" && t.CertFile == "" {
+		return fieldErrorf(field+".CertFile", "is empty, pb serve needs a certificate to ask for those of clients")
+	}
+	for _, f := range []struct{ name, path string }{
+		{"CertFile", t.CertFile}, {"KeyFile", t.KeyFile}, {"ClientCAFile", t.ClientCAFile}, {"CAFile", t.CAFile},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			return fieldErrorf(field+"."+f.name, "%v", err)
+		}
+	}
+	return nil
+}
+
+var tlsConfig *TLSConfig
+
+func setupTLS(cfg *Config) {
+	tlsConfig = cfg.TLS
+}
+
+// serverTLS returns the TLS config of pb serve, or nil to serve in the
+// clear.
+func serverTLS() (*tls.Config, error) {
+	if tlsConfig == nil || tlsConfig.CertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+	if err != nil {
+		return nil, fieldErrorf("TLS.CertFile", "%v", err)
+	}
+	c := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if tlsConfig.ClientCAFile != "" {
+		if c.ClientCAs, err = loadCAFile("TLS.ClientCAFile", tlsConfig.ClientCAFile); err != nil {
+			return nil, err
+		}
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// serveClient returns the HTTP client for pb serve, presenting the
+// certificate of the config, if there is a config: pb fetch works without.
+func serveClient() (*http.Client, error) {
+	if _, err := os.Stat(configFilePath); err != nil {
+		return http.DefaultClient, nil
+	}
+	cfg, err := loadConfig(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.TLS == nil {
+		return http.DefaultClient, nil
+	}
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLS.CAFile != "" {
+		if c.RootCAs, err = loadCAFile("TLS.CAFile", cfg.TLS.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.TLS.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			return nil, fieldErrorf("TLS.CertFile", "%v", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = c
+	return &http.Client{Transport: t}, nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// tokenIDLen is the length of the public id of a token, by which it is
+// listed and revoked.
+const tokenIDLen = 8
+
+func tokensTable() string {
+	return kvTable + "_tokens"
+}
+
+// apiToken is a bearer token for pb serve. A token of a user has their
+// grants, narrowed to Prefix and Access; a token of no user has exactly
+// those.
+type apiToken struct {
+	ID        string     `json:"id" yaml:"id"`
+	User      string     `json:"user,omitempty" yaml:"user,omitempty"`
+	Prefix    string     `json:"prefix" yaml:"prefix"`
+	Access    string     `json:"access" yaml:"access"`
+	CreatedAt time.Time  `json:"created_at" yaml:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+}
+
+// createToken stores a token for t, filling in its id, and returns the
+// token, which is only stored hashed. It expires after ttl unless it is
+// 0.
+func createToken(t *apiToken, ttl time.Duration) (string, error) {
+	token, hash := newToken()
+	t.ID = randomCode(tokenIDLen)
+	what := fmt.Sprintf("create a %s token for %q", t.Access, t.Prefix)
+	return token, aclWrite(what, func() error {
+		var user *string
+		if t.User != "" {
+			var one int
+			err := queryerFor(ctx).QueryRowContext(ctx, "SELECT 1 FROM "+usersTable()+" WHERE name = ?", t.User).Scan(&one)
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("no user %s, add them with pb acl add-user: %w", t.User, err)
+			}
+			if err != nil {
+				return err
+			}
+			user = &t.User
+		}
+		expires, args := "NULL", []any{t.ID, hash, user, namespace + t.Prefix, t.Access}
+		if ttl != 0 {
+			expires, args = afterNow(), append(args, ttl.Microseconds())
+		}
+		_, err := queryerFor(ctx).ExecContext(ctx, "INSERT INTO "+tokensTable()+" (id, token_hash, name, prefix, access, created_at, expires_at) VALUES (?, ?, ?, ?, ?, "+currentTimestamp()+", "+expires+")", args...)
+		return err
+	})
+}
+
+// revokeToken deletes the token with id.
+func revokeToken(id string) error {
+	return aclWrite("revoke token "+id, func() error {
+		res, err := queryerFor(ctx).ExecContext(ctx, "DELETE FROM "+tokensTable()+" WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err == nil && n == 0 {
+			err = fmt.Errorf("no token %s: %w", id, sql.ErrNoRows)
+		}
+		return err
+	})
+}
+
+// listTokens returns the tokens that have not expired, with their prefixes
+// including the namespace.
+func listTokens() ([]apiToken, error) {
+	if err := requireACLs(); err != nil {
+		return nil, err
+	}
+	rows, err := queryerFor(ctx).QueryContext(ctx, "SELECT id, name, prefix, access, created_at, expires_at FROM "+tokensTable()+" WHERE "+notExpired()+" ORDER BY created_at, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []apiToken
+	for rows.Next() {
+		var t apiToken
+		var user, expires *string
+		var created string
+		if err := rows.Scan(&t.ID, &user, &t.Prefix, &t.Access, &created, &expires); err != nil {
+			return nil, err
+		}
+		if user != nil {
+			t.User = *user
+		}
+		if t.CreatedAt, err = parseDBTime(created); err != nil {
+			return nil, err
+		}
+		if expires != nil {
+			at, err := parseDBTime(*expires)
+			if err != nil {
+				return nil, err
+			}
+			t.ExpiresAt = &at
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// tokenPrincipal returns the principal holding the token with hash, or
+// sql.ErrNoRows if there is none or it has expired.
+func tokenPrincipal(hash string) (*principal, error) {
+	var id, prefix, access string
+	var user *string
+	err := queryerFor(ctx).QueryRowContext(ctx, "SELECT id, name, prefix, access FROM "+tokensTable()+" WHERE token_hash = ? AND "+notExpired(), hash).
+		Scan(&id, &user, &prefix, &access)
+	if err != nil {
+		return nil, err
+	}
+	scope := &aclGrant{Prefix: prefix, Access: access}
+	if user == nil {
+		return &principal{User: "token " + id, grants: []aclGrant{*scope}}, nil
+	}
+	grants, err := listGrants(*user)
+	if err != nil {
+		return nil, err
+	}
+	return &principal{User: *user, grants: grants, scope: scope}, nil
+}
+
+func tokenCommand() *gcli.Command {
+	var user, prefix, ttl string
+	var readOnlyToken bool
+	return &gcli.Command{
+		Name: "token",
+		Desc: "Manage the bearer tokens pb serve accepts",
+		Help: `Once the board has a token or a user, pb serve only answers requests
+carrying one. A token can be limited to reading, and to the keys under a
+prefix; with --user it also keeps to the grants of that user, see pb acl:
+
+  pb token create --read-only --prefix app/prod/ --ttl 30d
+  curl -H "Authorization: Bearer $TOKEN" http://localhost:8080/v1/kv/app/prod/db_host`,
+		Subs: []*gcli.Command{
+			{
+				Name: "create",
+				Desc: "Create a token and print it",
+				Config: func(c *gcli.Command) {
+					c.StrOpt(&user, "user", "u", "", "Act as this user of pb acl, within their grants")
+					c.StrOpt(&prefix, "prefix", "p", "", "Only give access to the keys starting with this")
+					c.BoolOpt(&readOnlyToken, "read-only", "r", false, "Only let the token read keys")
+					c.StrOpt(&ttl, "ttl", "", "", "Expire the token after this long, e.g. 12h or 30d; never by default")
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					var d time.Duration
+					if ttl != "" {
+						var err error
+						if d, err = parseTTL(ttl); err != nil {
+							return err
+						}
+						if d <= 0 {
+							return fmt.Errorf("--ttl must be positive")
+						}
+					}
+					t := &apiToken{User: user, Prefix: strings.TrimSuffix(prefix, "*"), Access: accessWrite}
+					if readOnlyToken {
+						t.Access = accessRead
+					}
+					if err := connect(); err != nil {
+						return err
+					}
+					token, err := createToken(t, d)
+					if err != nil || dryRunWrites {
+						return err
+					}
+					fmt.Fprintf(os.Stderr, "Created token %s; it is not stored and cannot be shown again:\n", t.ID)
+					fmt.Println(token)
+					return nil
+				},
+			},
+			{
+				Name: "list",
+				Desc: "List the tokens that have not expired",
+				Config: func(c *gcli.Command) {
+					formatOpt(c, "the tokens", formatText)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					if err := connect(); err != nil {
+						return err
+					}
+					all, err := listTokens()
+					if err != nil {
+						return err
+					}
+					// the tokens of other namespaces are not for this board
+					tokens := []apiToken{}
+					for _, t := range all {
+						if prefix, ok := strings.CutPrefix(t.Prefix, namespace); ok {
+							t.Prefix = prefix
+							tokens = append(tokens, t)
+						}
+					}
+					switch format := commandFormat(formatText); format {
+					case formatJSON, formatYAML:
+						return printStructured(format, tokens)
+					case formatTable:
+						rows := make([][]string, len(tokens))
+						for i, t := range tokens {
+							rows[i] = []string{t.ID, auditCell(t.User), t.Prefix, t.Access, formatTime(&t.CreatedAt), formatTime(t.ExpiresAt)}
+						}
+						return printTable([]string{"ID", "USER", "PREFIX", "ACCESS", "CREATED", "EXPIRES"}, rows)
+					}
+					for _, t := range tokens {
+						line := fmt.Sprintf("%s\t%s\t%q", t.ID, t.Access, t.Prefix)
+						if t.User != "" {
+							line += "\tas " + t.User
+						}
+						if t.ExpiresAt != nil {
+							line += "\texpires " + t.ExpiresAt.Local().Format(time.DateTime)
+						}
+						fmt.Println(line)
+					}
+					return nil
+				},
+			},
+			{
+				Name: "revoke",
+				Desc: "Delete a token, by the id pb token list shows",
+				Config: func(c *gcli.Command) {
+					c.AddArg("id", "The id of the token", true)
+				},
+				Func: func(c *gcli.Command, args []string) error {
+					if err := connect(); err != nil {
+						return err
+					}
+					return revokeToken(c.Arg("id").String())
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is a no-op until setupTracing installs an exporting provider.
+var tracer = otel.Tracer("github.com/c4pt0r/postboard")
+
+// commandName is the subcommand being run, used to name the root span.
+var commandName string
+
+// setupTracing exports spans to the OTLP/HTTP endpoint from the config, if
+// one is set, and starts a root span for the command. When pb runs under a
+// traced CI job or service, TRACEPARENT in the environment makes the
+// command a child of the caller's trace.
+func setupTracing(cfg *Config) error {
+	if cfg.OTLPEndpoint == "" {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+	if err != nil {
+		return err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "pb"))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	onExit(func() {
+		// don't let an unreachable collector hang the CLI
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		tp.Shutdown(shutdownCtx)
+	})
+
+	carrier := propagation.MapCarrier{
+		"traceparent": os.Getenv("TRACEPARENT"),
+		"tracestate":  os.Getenv("TRACESTATE"),
+	}
+	ctx = otel.GetTextMapPropagator().Extract(ctx, carrier)
+	var span trace.Span
+	ctx, span = tracer.Start(ctx, "pb "+commandName)
+	onExit(func() { span.End() })
+	return nil
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gookit/gcli/v3"
+)
+
+var (
+	tuiCursorStyle = lipgloss.NewStyle().Reverse(true)
+	tuiDirStyle    = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("4"))
+	tuiTitleStyle  = lipgloss.NewStyle().Bold(true)
+	tuiDimStyle    = lipgloss.NewStyle().Faint(true)
+	tuiErrorStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+)
+
+// treeRow is a line of the key tree: a directory, which is a prefix
+// ending in /, or a key.
+type treeRow struct {
+	path  string
+	name  string
+	depth int
+	dir   bool
+}
+
+// tuiValue is a value loaded for the preview.
+type tuiValue struct {
+	value []byte
+	err   error
+}
+
+type valueMsg struct {
+	key string
+	tuiValue
+}
+
+type keysMsg struct {
+	keys []string
+	err  error
+}
+
+type editedMsg struct {
+	key string
+	err error
+}
+
+// editExec runs pb edit from the TUI, which gives it the terminal.
+type editExec struct {
+	key string
+}
+
+func (e editExec) Run() error {
+	return editKey(e.key, false)
+}
+
+// the editor uses the terminal directly
+func (editExec) SetStdin(io.Reader)  {}
+func (editExec) SetStdout(io.Writer) {}
+func (editExec) SetStderr(io.Writer) {}
+
+// tuiModel is the state of pb ui.
+type tuiModel struct {
+	prefix   string
+	keys     []string
+	expanded map[string]bool
+	values   map[string]tuiValue
+	cursor   int
+	offset   int
+	width    int
+	height   int
+	// query filters the keys, which are then listed flat
+	query     string
+	searching bool
+	// deleting is the key waiting for y to be deleted
+	deleting string
+	status   string
+}
+
+func loadKeys(prefix string) tea.Cmd {
+	return func() tea.Msg {
+		keys, err := listKeysWithPrefix(ctx, prefix)
+		return keysMsg{keys, err}
+	}
+}
+
+func loadValue(key string) tea.Cmd {
+	return func() tea.Msg {
+		value, err := getKey(ctx, key)
+		return valueMsg{key, tuiValue{value, err}}
+	}
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return loadKeys(m.prefix)
+}
+
+// rows returns the lines of the key list: the matches of the query, best
+// first, or the tree of keys with collapsed directories hiding theirs.
+func (m *tuiModel) rows() []treeRow {
+	var rows []treeRow
+	if m.query != "" {
+		scores := map[string]int{}
+		for _, key := range m.keys {
+			if score := fuzzyScore(m.query, key); score >= 0 {
+				scores[key] = score
+				rows = append(rows, treeRow{path: key, name: key})
+			}
+		}
+		// best first, then shortest
+		slices.SortStableFunc(rows, func(a, b treeRow) int {
+			if c := scores[b.path] - scores[a.path]; c != 0 {
+				return c
+			}
+			return len(a.path) - len(b.path)
+		})
+		return rows
+	}
+	emitted := map[string]bool{}
+	for _, key := range m.keys {
+		rel := strings.TrimPrefix(key, m.prefix)
+		parts := strings.Split(rel, "/")
+		dir, visible := m.prefix, true
+		for i, part := range parts[:len(parts)-1] {
+			dir += part + "/"
+			if !emitted[dir] {
+				emitted[dir] = true
+				rows = append(rows, treeRow{path: dir, name: part + "/", depth: i, dir: true})
+			}
+			if !m.expanded[dir] {
+				visible = false
+				break
+			}
+		}
+		if visible {
+			rows = append(rows, treeRow{path: key, name: parts[len(parts)-1], depth: len(parts) - 1})
+		}
+	}
+	return rows
+}
+
+// selected returns the row under the cursor, if any.
+func (m *tuiModel) selected() (treeRow, bool) {
+	rows := m.rows()
+	if m.cursor >= len(rows) {
+		return treeRow{}, false
+	}
+	return rows[m.cursor], true
+}
+
+// listHeight is the number of rows shown, below the title and above the
+// status line.
+func (m *tuiModel) listHeight() int {
+	return max(m.height-2, 1)
+}
+
+// move moves the cursor and loads the value under it.
+func (m *tuiModel) move(to int) tea.Cmd {
+	n := len(m.rows())
+	m.cursor = max(min(to, n-1), 0)
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if h := m.listHeight(); m.cursor >= m.offset+h {
+		m.offset = m.cursor - h + 1
+	}
+	if row, ok := m.selected(); ok && !row.dir {
+		if _, ok := m.values[row.path]; !ok {
+			return loadValue(row.path)
+		}
+	}
+	return nil
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		return m, m.move(m.cursor)
+	case keysMsg:
+		if msg.err != nil {
+			m.status = msg.err.Error()
+			return m, nil
+		}
+		m.keys, m.values = msg.keys, map[string]tuiValue{}
+		return m, m.move(m.cursor)
+	case valueMsg:
+		m.values[msg.key] = msg.tuiValue
+		return m, nil
+	case editedMsg:
+		m.status = "Edited " + msg.key
+		if msg.err != nil {
+			m.status = msg.err.Error()
+		}
+		return m, loadKeys(m.prefix)
+	case tea.KeyMsg:
+		if m.searching {
+			return m, m.updateSearch(msg)
+		}
+		if m.deleting != "" {
+			key := m.deleting
+			m.deleting = ""
+			if msg.String() != "y" {
+				m.status = ""
+				return m, nil
+			}
+			if _, err := deleteKey(ctx, key); err != nil {
+				m.status = err.Error()
+				return m, nil
+			}
+			m.status = "Deleted " + key
+			return m, loadKeys(m.prefix)
+		}
+		m.status = ""
+		row, ok := m.selected()
+		switch msg.String() {
+		case "q", "ctrl+c":
+			return m, tea.Quit
+		case "up", "k":
+			return m, m.move(m.cursor - 1)
+		case "down", "j":
+			return m, m.move(m.cursor + 1)
+		case "pgup":
+			return m, m.move(m.cursor - m.listHeight())
+		case "pgdown", " ":
+			return m, m.move(m.cursor + m.listHeight())
+		case "home", "g":
+			return m, m.move(0)
+		case "end", "G":
+			return m, m.move(len(m.rows()) - 1)
+		case "enter":
+			if ok && row.dir {
+				m.expanded[row.path] = !m.expanded[row.path]
+				return m, m.move(m.cursor)
+			}
+		case "right", "l":
+			if ok && row.dir {
+				m.expanded[row.path] = true
+				return m, m.move(m.cursor)
+			}
+		case "left", "h":
+			if ok && row.dir && m.expanded[row.path] {
+				delete(m.expanded, row.path)
+			} else if ok && m.query == "" {
+				// jump to the directory holding the row
+				parent := row.path[:strings.LastIndex(strings.TrimSuffix(row.path, "/"), "/")+1]
+				for i, r := range m.rows() {
+					if r.dir && r.path == parent {
+						return m, m.move(i)
+					}
+				}
+			}
+			return m, m.move(m.cursor)
+		case "/":
+			m.searching = true
+			return m, nil
+		case "esc":
+			m.query = ""
+			return m, m.move(0)
+		case "r":
+			return m, loadKeys(m.prefix)
+		case "e":
+			if ok && !row.dir {
+				return m, tea.Exec(editExec{row.path}, func(err error) tea.Msg {
+					return editedMsg{row.path, err}
+				})
+			}
+		case "d":
+			if ok && !row.dir {
+				m.deleting = row.path
+			}
+		}
+	}
+	return m, nil
+}
+
+// updateSearch handles a key typed in the search line.
+func (m *tuiModel) updateSearch(msg tea.KeyMsg) tea.Cmd {
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.searching = false
+		return nil
+	case tea.KeyEsc, tea.KeyCtrlC:
+		m.searching, m.query = false, ""
+	case tea.KeyBackspace:
+		if m.query != "" {
+			_, size := utf8.DecodeLastRuneInString(m.query)
+			m.query = m.query[:len(m.query)-size]
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.query += string(msg.Runes)
+	}
+	return m.move(0)
+}
+
+// preview renders the value under the cursor.
+func (m *tuiModel) preview(width, height int) string {
+	row, ok := m.selected()
+	if !ok {
+		return ""
+	}
+	if row.dir {
+		n := 0
+		for _, key := range m.keys {
+			if strings.HasPrefix(key, row.path) {
+				n++
+			}
+		}
+		return tuiTitleStyle.Render(row.path) + "\n\n" + tuiDimStyle.Render(fmt.Sprintf("%d keys", n))
+	}
+	v, ok := m.values[row.path]
+	var body string
+	switch {
+	case !ok:
+		body = tuiDimStyle.Render("Loading...")
+	case v.err == sql.ErrNoRows:
+		body = tuiDimStyle.Render("Deleted")
+	case v.err != nil:
+		body = tuiErrorStyle.Render(v.err.Error())
+	case !utf8.Valid(v.value):
+		body = tuiDimStyle.Render(fmt.Sprintf("Binary value, %d bytes", len(v.value)))
+	default:
+		lines := strings.Split(strings.ReplaceAll(string(v.value), "\t", "    "), "\n")
+		for i, line := range lines[:min(len(lines), height-2)] {
+			if utf8.RuneCountInString(line) > width {
+				lines[i] = string([]rune(line)[:width])
+			}
+		}
+		body = strings.Join(lines[:min(len(lines), height-2)], "\n")
+	}
+	return tuiTitleStyle.Render(row.path) + "\n\n" + body
+}
+
+func (m *tuiModel) View() string {
+	if m.width == 0 {
+		return ""
+	}
+	listWidth := max(m.width*2/5, 20)
+	rows := m.rows()
+	var list []string
+	for i := m.offset; i < min(m.offset+m.listHeight(), len(rows)); i++ {
+		row := rows[i]
+		name := row.name
+		if row.dir {
+			mark := "▸ "
+			if m.expanded[row.path] {
+				mark = "▾ "
+			}
+			name = mark + name
+		} else {
+			name = "  " + name
+		}
+		line := strings.Repeat("  ", row.depth) + name
+		if utf8.RuneCountInString(line) > listWidth-1 {
+			line = string([]rune(line)[:listWidth-2]) + "…"
+		}
+		switch {
+		case i == m.cursor:
+			line = tuiCursorStyle.Render(line)
+		case row.dir:
+			line = tuiDirStyle.Render(line)
+		}
+		list = append(list, line)
+	}
+	if len(rows) == 0 {
+		list = append(list, tuiDimStyle.Render("No keys"))
+	}
+	left := lipgloss.NewStyle().Width(listWidth).Height(m.listHeight()).Render(strings.Join(list, "\n"))
+	right := lipgloss.NewStyle().Width(m.width - listWidth - 2).Height(m.listHeight()).PaddingLeft(2).
+		Render(m.preview(m.width-listWidth-4, m.listHeight()))
+	title := tuiTitleStyle.Render(fmt.Sprintf("postboard %s", m.prefix)) + tuiDimStyle.Render(fmt.Sprintf("  %d keys", len(m.keys)))
+	status := tuiDimStyle.Render("↑↓ move  ←→ fold  / search  e edit  d delete  r reload  q quit")
+	switch {
+	case m.searching:
+		status = "/" + m.query + "█"
+	case m.deleting != "":
+		status = tuiErrorStyle.Render(fmt.Sprintf("Delete %s? y/n", m.deleting))
+	case m.status != "":
+		status = m.status
+	case m.query != "":
+		status = fmt.Sprintf("/%s  %d matches, esc to clear", m.query, len(rows))
+	}
+	return title + "\n" + lipgloss.JoinHorizontal(lipgloss.Top, left, right) + "\n" + status
+}
+
+func tuiCommand() *gcli.Command {
+	return &gcli.Command{
+		Name: "ui",
+		Desc: "Browse, edit and delete keys in the terminal",
+		Help: `Keys are shown as a tree split on /. Type / to search them by fuzzy
+match, e to edit the selected key in $EDITOR and d to delete it.`,
+		Config: func(c *gcli.Command) {
+			c.AddArg("prefix", "Only browse the keys starting with this", false)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			if err := connect(); err != nil {
+				return err
+			}
+			m := &tuiModel{
+				prefix:   strings.TrimSuffix(c.Arg("prefix").String(), "*"),
+				expanded: map[string]bool{},
+				values:   map[string]tuiValue{},
+			}
+			_, err := tea.NewProgram(m, tea.WithAltScreen(), tea.WithContext(ctx)).Run()
+			return err
+		},
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// uiPage is the web UI served at /ui. It is a single page that works
+// through the JSON API, so it can do nothing the API cannot.
+//
+//go:embed ui.html
+var uiPage []byte
+
+func uiHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(uiPage)
+}
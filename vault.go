@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gookit/gcli/v3"
+)
+
+var errVaultNotFound = errors.New("not found")
+
+// vaultClient talks to the Vault HTTP API directly; pb only needs to read
+// and write one KV secret, which does not warrant the Vault SDK.
+type vaultClient struct {
+	addr  string
+	token string
+	// namespace is the Vault Enterprise namespace, if any
+	namespace string
+}
+
+func (v *vaultClient) do(method, path string, body any, out any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(v.addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), r)
+	if err != nil {
+		return err
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("vault %s %s: %w", method, path, errVaultNotFound)
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		if len(e.Errors) == 0 {
+			return fmt.Errorf("vault %s %s: %s", method, path, resp.Status)
+		}
+		return fmt.Errorf("vault %s %s: %s: %s", method, path, resp.Status, strings.Join(e.Errors, "; "))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// newVaultClient authenticates with VAULT_TOKEN or, failing that, with the
+// AppRole in VAULT_ROLE_ID and VAULT_SECRET_ID.
+func newVaultClient(addr string) (*vaultClient, error) {
+	if addr == "" {
+		return nil, fmt.Errorf("no Vault address, use --addr or set VAULT_ADDR")
+	}
+	v := &vaultClient{addr: addr, token: os.Getenv("VAULT_TOKEN"), namespace: os.Getenv("VAULT_NAMESPACE")}
+	if v.token != "" {
+		return v, nil
+	}
+	roleID, secretID := os.Getenv("VAULT_ROLE_ID"), os.Getenv("VAULT_SECRET_ID")
+	if roleID == "" {
+		return nil, fmt.Errorf("set VAULT_TOKEN, or VAULT_ROLE_ID and VAULT_SECRET_ID to log in with AppRole")
+	}
+	mount := os.Getenv("VAULT_APPROLE_MOUNT")
+	if mount == "" {
+		mount = "approle"
+	}
+	var login struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	err := v.do("POST", "auth/"+mount+"/login", map[string]string{"role_id": roleID, "secret_id": secretID}, &login)
+	if err != nil {
+		return nil, err
+	}
+	v.token = login.Auth.ClientToken
+	return v, nil
+}
+
+// isKVv2 reports whether path addresses a KV version 2 secret, whose API
+// path has data/ after the mount, e.g. secret/data/app.
+func isKVv2(path string) bool {
+	return strings.Contains(path, "/data/")
+}
+
+// read returns the fields of the secret at path. Values that are not
+// strings are returned as JSON.
+func (v *vaultClient) read(path string) (map[string]string, error) {
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := v.do("GET", path, nil, &resp); err != nil {
+		return nil, err
+	}
+	fields := resp.Data
+	if isKVv2(path) {
+		fields = nil
+		if err := json.Unmarshal(resp.Data["data"], &fields); err != nil {
+			return nil, fmt.Errorf("vault %s: unexpected response: %w", path, err)
+		}
+	}
+	values := map[string]string{}
+	for name, raw := range fields {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			values[name] = s
+		} else {
+			values[name] = string(raw)
+		}
+	}
+	return values, nil
+}
+
+// write replaces the secret at path with values, as a new version on KV v2.
+func (v *vaultClient) write(path string, values map[string]string) error {
+	var body any = values
+	if isKVv2(path) {
+		body = map[string]any{"data": values}
+	}
+	return v.do("POST", path, body, nil)
+}
+
+// tree reads every secret under dir, a folder such as secret/data/app/, by
+// its path relative to dir.
+func (v *vaultClient) tree(dir string) (map[string]map[string]string, error) {
+	// KV v2 lists under metadata/ rather than data/
+	listDir := dir
+	if isKVv2(dir) {
+		listDir = strings.Replace(dir, "/data/", "/metadata/", 1)
+	}
+	secrets := map[string]map[string]string{}
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		var resp struct {
+			Data struct {
+				Keys []string `json:"keys"`
+			} `json:"data"`
+		}
+		err := v.do("GET", listDir+rel+"?list=true", nil, &resp)
+		if errors.Is(err, errVaultNotFound) {
+			// an empty folder
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, name := range resp.Data.Keys {
+			if strings.HasSuffix(name, "/") {
+				if err := walk(rel + name); err != nil {
+					return err
+				}
+				continue
+			}
+			values, err := v.read(dir + rel + name)
+			if errors.Is(err, errVaultNotFound) {
+				// its latest version is deleted
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			secrets[rel+name] = values
+		}
+		return nil
+	}
+	return secrets, walk("")
+}
+
+func vaultCommand() *gcli.Command {
+	var addr, prefix string
+	var dryRun, recursive, del bool
+	config := func(c *gcli.Command) {
+		c.StrOpt(&addr, "addr", "", os.Getenv("VAULT_ADDR"), "The Vault server (default $VAULT_ADDR)")
+		c.StrOpt(&prefix, "prefix", "p", "", "The key prefix holding the secret's fields, e.g. app/")
+		c.BoolOpt(&recursive, "recursive", "r", false, "Copy every secret under path, a folder such as secret/data/app/")
+		c.BoolOpt(&del, "delete", "", false, "Delete the keys, or with push -r the secrets, that the other side does not have")
+		c.BoolOpt(&dryRun, "dry-run", "n", false, "Print what would be done without changing anything")
+		c.AddArg("path", "The secret's API path, e.g. secret/data/app for KV v2", true)
+	}
+	return &gcli.Command{
+		Name: "vault",
+		Desc: "Copy a HashiCorp Vault KV secret to or from a key prefix",
+		Help: `Each field of the secret is one key under the prefix. Authenticates with
+VAULT_TOKEN, or with AppRole using VAULT_ROLE_ID and VAULT_SECRET_ID
+(VAULT_APPROLE_MOUNT if not mounted at approle/). VAULT_NAMESPACE is
+honoured.
+
+With -r, every secret under a folder is copied, field F of the secret
+at folder/S being the key prefix+S/F, so that a KV tree and a prefix can
+be kept in sync while moving from one to the other:
+
+  pb vault pull -r --delete --prefix app/ secret/data/app/
+  pb vault push -r --delete --prefix app/ secret/data/app/
+
+push writes only the secrets that differ, as new versions, and --delete
+deletes the latest version of those with no keys.`,
+		Subs: []*gcli.Command{
+			{
+				Name:   "pull",
+				Desc:   "Store each field of a Vault secret as a secret key",
+				Config: config,
+				Func: func(c *gcli.Command, args []string) error {
+					path := c.Arg("path").String()
+					if del && prefix == "" {
+						return fmt.Errorf("--prefix is required with --delete, mirroring onto the whole board is never intended")
+					}
+					v, err := newVaultClient(addr)
+					if err != nil {
+						return err
+					}
+					var secrets []pulledSecret
+					if !recursive {
+						values, err := v.read(path)
+						if err != nil {
+							return err
+						}
+						for _, name := range slices.Sorted(maps.Keys(values)) {
+							secrets = append(secrets, pulledSecret{Name: name, Value: values[name], Secret: true})
+						}
+						return storeSecrets(prefix, secrets, dryRun, del)
+					}
+					tree, err := v.tree(strings.TrimSuffix(path, "/") + "/")
+					if err != nil {
+						return err
+					}
+					for _, rel := range slices.Sorted(maps.Keys(tree)) {
+						for _, name := range slices.Sorted(maps.Keys(tree[rel])) {
+							if strings.Contains(name, "/") {
+								return fmt.Errorf("%s%s: field %q has a / and cannot be a key", path, rel, name)
+							}
+							secrets = append(secrets, pulledSecret{Name: rel + "/" + name, Value: tree[rel][name], Secret: true})
+						}
+					}
+					return storeSecrets(prefix, secrets, dryRun, del)
+				},
+			},
+			{
+				Name:   "push",
+				Desc:   "Replace a Vault secret with the keys under a prefix",
+				Config: config,
+				Func: func(c *gcli.Command, args []string) error {
+					path := c.Arg("path").String()
+					if prefix == "" {
+						return fmt.Errorf("--prefix is required, pushing the whole board is never intended")
+					}
+					if err := connect(); err != nil {
+						return err
+					}
+					dryRun = dryRun || dryRunWrites
+					keys, err := listKeysWithPrefix(ctx, prefix)
+					if err != nil {
+						return err
+					}
+					if recursive {
+						return vaultPushTree(addr, strings.TrimSuffix(path, "/")+"/", prefix, keys, dryRun, del)
+					}
+					values := map[string]string{}
+					for _, key := range keys {
+						value, err := getKey(ctx, key)
+						if err != nil {
+							return err
+						}
+						values[strings.TrimPrefix(key, prefix)] = string(value)
+						fmt.Printf("push %s -> %s\n", key, strings.TrimPrefix(key, prefix))
+					}
+					if dryRun {
+						return nil
+					}
+					v, err := newVaultClient(addr)
+					if err != nil {
+						return err
+					}
+					return v.write(path, values)
+				},
+			},
+		},
+	}
+}
+
+// vaultPushTree writes keys, under prefix, to the secrets under dir: key
+// prefix+S/F to field F of the secret at dir+S. Secrets whose fields are
+// already those are left alone, and with del, the secrets under dir with no
+// keys are deleted.
+func vaultPushTree(addr, dir, prefix string, keys []string, dryRun, del bool) error {
+	want := map[string]map[string]string{}
+	for _, key := range keys {
+		path := strings.TrimPrefix(key, prefix)
+		i := strings.LastIndex(path, "/")
+		if i < 0 {
+			return fmt.Errorf("%s is not in a secret, keys are %sSECRET/FIELD", key, prefix)
+		}
+		rel, name := path[:i], path[i+1:]
+		value, err := getKey(ctx, key)
+		if err != nil {
+			return err
+		}
+		if want[rel] == nil {
+			want[rel] = map[string]string{}
+		}
+		want[rel][name] = string(value)
+	}
+	v, err := newVaultClient(addr)
+	if err != nil {
+		return err
+	}
+	have, err := v.tree(dir)
+	if err != nil {
+		return err
+	}
+	for _, rel := range slices.Sorted(maps.Keys(want)) {
+		if maps.Equal(want[rel], have[rel]) {
+			continue
+		}
+		fmt.Printf("push %s%s/ -> %s%s\n", prefix, rel, dir, rel)
+		if dryRun {
+			continue
+		}
+		if err := v.write(dir+rel, want[rel]); err != nil {
+			return err
+		}
+	}
+	if !del {
+		return nil
+	}
+	for _, rel := range slices.Sorted(maps.Keys(have)) {
+		if _, ok := want[rel]; ok {
+			continue
+		}
+		fmt.Printf("delete %s%s\n", dir, rel)
+		if dryRun {
+			continue
+		}
+		if err := v.do("DELETE", dir+rel, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gookit/gcli/v3"
+)
+
+// keyEvent is a write or delete seen by watchKeys.
+type keyEvent struct {
+	Key string
+	// Stored is the new value as stored, which only those that need it
+	// decrypt, so that the webhooks are sent for values pb cannot read.
+	Stored  []byte
+	Deleted bool
+}
+
+// Value returns the new value, decrypted and decompressed.
+func (e keyEvent) Value() ([]byte, error) {
+	return decryptValue(e.Stored)
+}
+
+// watchSnapshot returns the stored values of key, or of every key starting
+// with it if prefix is set.
+func watchSnapshot(key string, prefix bool) (map[string][]byte, error) {
+	keys := []string{key}
+	if prefix {
+		var err error
+		if keys, err = listKeysWithPrefix(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+	values := map[string][]byte{}
+	for _, k := range keys {
+		// the store, not the cache, sees writes made by other processes
+		v, err := store.Get(ctx, namespace+k)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[k] = v
+	}
+	return values, nil
+}
+
+// watchKeys polls key, or every key starting with it if prefix is set,
+// every interval and calls fn for each change until done is cancelled, pb
+// is interrupted or fn fails. Several writes between polls are seen as one.
+func watchKeys(done context.Context, key string, prefix bool, interval time.Duration, fn func(keyEvent) error) error {
+	prev, err := watchSnapshot(key, prefix)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-done.Done():
+			return nil
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+		next, err := watchSnapshot(key, prefix)
+		if err != nil {
+			return err
+		}
+		var events []keyEvent
+		for k, v := range next {
+			if old, ok := prev[k]; !ok || !bytes.Equal(old, v) {
+				events = append(events, keyEvent{Key: k, Stored: v})
+			}
+		}
+		for k := range prev {
+			if _, ok := next[k]; !ok {
+				events = append(events, keyEvent{Key: k, Deleted: true})
+			}
+		}
+		slices.SortFunc(events, func(a, b keyEvent) int { return bytes.Compare([]byte(a.Key), []byte(b.Key)) })
+		for _, e := range events {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		prev = next
+	}
+}
+
+// watchRecord is a change as pb watch --format json and yaml print it,
+// one document per change.
+type watchRecord struct {
+	Key      string `json:"key" yaml:"key"`
+	Value    string `json:"value,omitempty" yaml:"value,omitempty"`
+	Encoding string `json:"encoding,omitempty" yaml:"encoding,omitempty"`
+	Deleted  bool   `json:"deleted,omitempty" yaml:"deleted,omitempty"`
+}
+
+// printWatchRecord prints r as one line of JSON, or as a YAML document.
+func printWatchRecord(format outputFormat, r watchRecord) error {
+	if format == formatYAML {
+		fmt.Println("---")
+		return printStructured(format, r)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(r)
+}
+
+func watchCommand() *gcli.Command {
+	var once bool
+	interval := Duration{2 * time.Second}
+	return &gcli.Command{
+		Name: "watch",
+		Desc: "Print a key's value whenever it changes",
+		Help: `Polls key every --interval and prints the new value each time it is
+written. With key* every key under the prefix is watched and printed as
+key=value. Deletions are reported on stderr. Writes made between two polls
+are seen as one.
+
+With --format raw only the values are printed, and with json or yaml each
+change is a document of its own, deletions included:
+
+  pb watch --format json 'app/*' | jq -r .key`,
+		Config: func(c *gcli.Command) {
+			c.VarOpt(&interval, "interval", "i", "How often to check for changes")
+			c.BoolOpt(&once, "once", "", false, "Exit after the first change, e.g. to block a script until a key is set")
+			formatOpt(c, "the changes", formatText)
+			c.AddArg("key", "The key, or prefix followed by *, to watch", true)
+		},
+		Func: func(c *gcli.Command, args []string) error {
+			format := commandFormat(formatText)
+			if format == formatTable {
+				return fmt.Errorf("pb watch prints changes as they come and cannot align them in a table")
+			}
+			if err := connect(); err != nil {
+				return err
+			}
+			key := c.Arg("key").String()
+			prefix := strings.HasSuffix(key, "*")
+			key = strings.TrimSuffix(key, "*")
+			done, stop := context.WithCancel(ctx)
+			defer stop()
+			return watchKeys(done, key, prefix, interval.Duration, func(e keyEvent) error {
+				switch {
+				case e.Deleted && format.structured():
+					if err := printWatchRecord(format, watchRecord{Key: e.Key, Deleted: true}); err != nil {
+						return err
+					}
+				case e.Deleted:
+					fmt.Fprintf(os.Stderr, "%s deleted\n", e.Key)
+				default:
+					value, err := e.Value()
+					if err != nil {
+						return err
+					}
+					if err := maskSecret(e.Key, value); err != nil {
+						return err
+					}
+					switch {
+					case format.structured():
+						r := newRecord(e.Key, value)
+						if err := printWatchRecord(format, watchRecord{Key: r.Key, Value: r.Value, Encoding: r.Encoding}); err != nil {
+							return err
+						}
+					case prefix && format != formatRaw:
+						fmt.Printf("%s=%s\n", e.Key, value)
+					default:
+						fmt.Println(string(value))
+					}
+				}
+				if once {
+					stop()
+				}
+				return nil
+			})
+		},
+	}
+}
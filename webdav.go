@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// kvFS presents the keyspace as a file system for WebDAV: a key a/b/c is
+// the file /a/b/c, and every prefix ending in / is a directory. Directories
+// only exist while they contain keys, except that directories created by
+// MKCOL are remembered for the life of the server so clients can create a
+// folder and then upload into it.
+type kvFS struct {
+	mu   sync.Mutex
+	dirs map[string]bool
+}
+
+func newKVFS() *kvFS {
+	return &kvFS{dirs: map[string]bool{}}
+}
+
+// davKey maps a WebDAV path to a key, dropping the leading slash.
+func davKey(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (fsys *kvFS) Mkdir(_ context.Context, name string, _ os.FileMode) error {
+	key := davKey(name)
+	if fi, err := fsys.stat(key); err == nil {
+		if fi.IsDir() {
+			return os.ErrExist
+		}
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	fsys.mu.Lock()
+	fsys.dirs[key] = true
+	fsys.mu.Unlock()
+	return nil
+}
+
+func (fsys *kvFS) OpenFile(_ context.Context, name string, flag int, _ os.FileMode) (webdav.File, error) {
+	key := davKey(name)
+	writing := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	if !writing {
+		fi, err := fsys.stat(key)
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			return &davDir{fsys: fsys, key: key, info: fi}, nil
+		}
+	}
+	if key == "" {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	value, err := getKey(ctx, key)
+	switch {
+	case err == sql.ErrNoRows && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	case err == sql.ErrNoRows:
+		value = nil
+	case err != nil:
+		return nil, err
+	case flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+	if flag&os.O_TRUNC != 0 {
+		value = nil
+	}
+	f := &davFile{key: key, writing: writing}
+	f.buf.Write(value)
+	return f, nil
+}
+
+func (fsys *kvFS) RemoveAll(_ context.Context, name string) error {
+	key := davKey(name)
+	if key == "" {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	if _, err := deleteKey(ctx, key); err != nil {
+		return err
+	}
+	keys, err := listKeysWithPrefix(ctx, key+"/")
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if _, err := deleteKey(ctx, k); err != nil {
+			return err
+		}
+	}
+	fsys.mu.Lock()
+	for dir := range fsys.dirs {
+		if dir == key || strings.HasPrefix(dir, key+"/") {
+			delete(fsys.dirs, dir)
+		}
+	}
+	fsys.mu.Unlock()
+	return nil
+}
+
+func (fsys *kvFS) Rename(_ context.Context, oldName, newName string) error {
+	oldKey, newKey := davKey(oldName), davKey(newName)
+	fi, err := fsys.stat(oldKey)
+	if err != nil {
+		return err
+	}
+	if oldKey == "" || newKey == "" {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrPermission}
+	}
+	if !fi.IsDir() {
+		return moveKey(oldKey, newKey)
+	}
+	keys, err := listKeysWithPrefix(ctx, oldKey+"/")
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := moveKey(k, newKey+strings.TrimPrefix(k, oldKey)); err != nil {
+			return err
+		}
+	}
+	fsys.mu.Lock()
+	if fsys.dirs[oldKey] {
+		delete(fsys.dirs, oldKey)
+		fsys.dirs[newKey] = true
+	}
+	fsys.mu.Unlock()
+	return nil
+}
+
+func moveKey(from, to string) error {
+	value, err := getKey(ctx, from)
+	if err != nil {
+		return err
+	}
+	if err := putKeyValue(ctx, to, value); err != nil {
+		return err
+	}
+	_, err = deleteKey(ctx, from)
+	return err
+}
+
+func (fsys *kvFS) Stat(_ context.Context, name string) (os.FileInfo, error) {
+	return fsys.stat(davKey(name))
+}
+
+func (fsys *kvFS) stat(key string) (os.FileInfo, error) {
+	if key == "" {
+		return &davInfo{name: "/", dir: true}, nil
+	}
+	value, err := getKey(ctx, key)
+	if err == nil {
+		return &davInfo{name: path.Base(key), size: int64(len(value))}, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+	fsys.mu.Lock()
+	made := fsys.dirs[key]
+	fsys.mu.Unlock()
+	if made {
+		return &davInfo{name: path.Base(key), dir: true}, nil
+	}
+	keys, err := listKeysWithPrefix(ctx, key+"/")
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, &fs.PathError{Op: "stat", Path: "/" + key, Err: fs.ErrNotExist}
+	}
+	return &davInfo{name: path.Base(key), dir: true}, nil
+}
+
+// davInfo describes a key or directory. Keys carry no modification time,
+// so all entries report the zero time.
+type davInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (fi *davInfo) Name() string       { return fi.name }
+func (fi *davInfo) Size() int64        { return fi.size }
+func (fi *davInfo) ModTime() time.Time { return time.Time{} }
+func (fi *davInfo) IsDir() bool        { return fi.dir }
+func (fi *davInfo) Sys() any           { return nil }
+func (fi *davInfo) Mode() os.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+// davFile buffers a value in memory; writes are stored when it is closed.
+type davFile struct {
+	key     string
+	buf     bytes.Buffer
+	r       *bytes.Reader
+	writing bool
+	dirty   bool
+}
+
+func (f *davFile) reader() *bytes.Reader {
+	if f.r == nil {
+		f.r = bytes.NewReader(f.buf.Bytes())
+	}
+	return f.r
+}
+
+func (f *davFile) Read(p []byte) (int, error) { return f.reader().Read(p) }
+
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	return f.reader().Seek(offset, whence)
+}
+
+func (f *davFile) Write(p []byte) (int, error) {
+	if !f.writing {
+		return 0, fs.ErrPermission
+	}
+	f.dirty = true
+	f.r = nil
+	return f.buf.Write(p)
+}
+
+func (f *davFile) Readdir(int) ([]fs.FileInfo, error) {
+	return nil, &fs.PathError{Op: "readdir", Path: "/" + f.key, Err: fs.ErrInvalid}
+}
+
+func (f *davFile) Stat() (fs.FileInfo, error) {
+	return &davInfo{name: path.Base(f.key), size: int64(f.buf.Len())}, nil
+}
+
+func (f *davFile) Close() error {
+	if !f.writing || !f.dirty && f.buf.Len() > 0 {
+		return nil
+	}
+	// also store untouched new files so that creating an empty file works
+	return putKeyValue(ctx, f.key, f.buf.Bytes())
+}
+
+// davDir lists the immediate children of a directory.
+type davDir struct {
+	fsys    *kvFS
+	key     string
+	info    fs.FileInfo
+	entries []fs.FileInfo
+	read    bool
+}
+
+func (d *davDir) Read([]byte) (int, error)       { return 0, fs.ErrInvalid }
+func (d *davDir) Seek(int64, int) (int64, error) { return 0, fs.ErrInvalid }
+func (d *davDir) Write([]byte) (int, error)      { return 0, fs.ErrInvalid }
+func (d *davDir) Close() error                   { return nil }
+func (d *davDir) Stat() (fs.FileInfo, error)     { return d.info, nil }
+
+func (d *davDir) Readdir(count int) ([]fs.FileInfo, error) {
+	if !d.read {
+		if err := d.load(); err != nil {
+			return nil, err
+		}
+		d.read = true
+	}
+	if count <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n := min(count, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *davDir) load() error {
+	prefix := ""
+	if d.key != "" {
+		prefix = d.key + "/"
+	}
+	keys, err := listKeysWithPrefix(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, k := range keys {
+		rest := strings.TrimPrefix(k, prefix)
+		name, _, isDir := strings.Cut(rest, "/")
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if isDir {
+			d.entries = append(d.entries, &davInfo{name: name, dir: true})
+			continue
+		}
+		fi, err := d.fsys.stat(k)
+		if err != nil {
+			return err
+		}
+		d.entries = append(d.entries, fi)
+	}
+	d.fsys.mu.Lock()
+	for dir := range d.fsys.dirs {
+		name, ok := strings.CutPrefix(dir, prefix)
+		if ok && name != "" && !strings.Contains(name, "/") && !seen[name] {
+			d.entries = append(d.entries, &davInfo{name: name, dir: true})
+		}
+	}
+	d.fsys.mu.Unlock()
+	sort.Slice(d.entries, func(i, j int) bool { return d.entries[i].Name() < d.entries[j].Name() })
+	return nil
+}
+
+// davAccess rejects the WebDAV requests for keys the user may not read, or
+// may not write for the methods that change them. A directory, as a path
+// ending in /, is checked as the prefix of the keys in it, so listing
+// app/ takes a grant for all of app/.
+func davAccess(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := requestPrincipal(r.Context())
+		paths := []string{r.URL.Path}
+		if dest, err := url.Parse(r.Header.Get("Destination")); err == nil && dest.Path != "" {
+			paths = append(paths, dest.Path)
+		}
+		for i, name := range paths {
+			key := namespace + davKey(strings.TrimPrefix(name, davPrefix))
+			if strings.HasSuffix(name, "/") && key != namespace {
+				key += "/"
+			}
+			write := false
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+			case "COPY":
+				// only the destination changes
+				write = i > 0
+			default:
+				write = true
+			}
+			if !p.can(key, write) {
+				http.Error(w, fmt.Sprintf("%s: %s may not access %s", errForbidden, p.User, name), http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// webhookPoll is how often pb serve checks for changes to notify.
+	webhookPoll = 2 * time.Second
+	// webhookAttempts bounds the deliveries of one event, one second apart
+	// and doubling.
+	webhookAttempts = 5
+)
+
+// WebhookConfig has pb serve POST an event to URL whenever a key under
+// Prefix is written or deleted, by pb serve or anyone else.
+//
+// The body is {"type": "put" or "delete", "key": ..., "time": ...}; values
+// are not sent, fetch them from the API if needed. With Secret set, the
+// X-Postboard-Signature header is sha256= and the hex HMAC-SHA256 of the
+// body keyed with Secret. Failed deliveries are retried a few times, and
+// events for one webhook are delivered in order.
+type WebhookConfig struct {
+	Prefix string `json:"Prefix"`
+	URL    string `json:"URL"`
+	Secret string `json:"Secret,omitempty"`
+}
+
+// webhookEvent is the body POSTed to a webhook.
+type webhookEvent struct {
+	Type string    `json:"type"`
+	Key  string    `json:"key"`
+	Time time.Time `json:"time"`
+}
+
+var webhooks []*WebhookConfig
+
+func setupWebhooks(cfg *Config) {
+	webhooks = cfg.Webhooks
+}
+
+func (w *WebhookConfig) validate(field string) error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fieldErrorf(field+".URL", "must be an http:// or https:// URL")
+	}
+	return nil
+}
+
+// deliver POSTs e to the webhook until it answers with a 2xx status, at
+// most webhookAttempts times.
+func (w *WebhookConfig) deliver(e webhookEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = w.post(body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		log.Printf("webhook %s: %v, retrying in %s", w.URL, err, backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (w *WebhookConfig) post(body []byte) error {
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, "POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "postboard")
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Postboard-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// runWebhooks notifies every webhook of changes under its prefix until pb
+// is interrupted. An event that cannot be delivered is logged and dropped.
+func runWebhooks() {
+	for _, w := range webhooks {
+		log.Printf("notifying %s of changes under %s", w.URL, w.Prefix)
+		go func() {
+			for ctx.Err() == nil {
+				err := watchKeys(ctx, w.Prefix, true, webhookPoll, func(e keyEvent) error {
+					ev := webhookEvent{Type: "put", Key: e.Key, Time: time.Now().UTC()}
+					if e.Deleted {
+						ev.Type = "delete"
+					}
+					if err := w.deliver(ev); err != nil {
+						log.Printf("webhook %s: dropped %s %s: %v", w.URL, ev.Type, ev.Key, err)
+					}
+					return nil
+				})
+				if err != nil {
+					// changes made until the board is back are missed
+					log.Printf("webhook %s: %v", w.URL, err)
+					time.Sleep(webhookPoll)
+				}
+			}
+		}()
+	}
+}